@@ -0,0 +1,93 @@
+// Package client describes the Ion Channel API calls ionize makes, so the
+// workflows can be run against the ionic client or a stand-in for it.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/aliases"
+	"github.com/ion-channel/ionic/analyses"
+	"github.com/ion-channel/ionic/dependencies"
+	ionerrors "github.com/ion-channel/ionic/errors"
+	"github.com/ion-channel/ionic/pagination"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionic/teams"
+	"github.com/ion-channel/ionic/users"
+)
+
+// Client is every call ionize makes, which *ionic.IonClient implements
+type Client interface {
+	Analyzer
+	ScanAdder
+	Evaluator
+	Projects
+	Users
+	Dependencies
+}
+
+// Analyzer requests analyses, checks on them, and reads earlier ones
+type Analyzer interface {
+	AnalyzeProject(projectID, teamID, branch, token string) (*scanner.AnalysisStatus, error)
+	GetAnalysisStatus(analysisID, teamID, projectID, token string) (*scanner.AnalysisStatus, error)
+	GetLatestAnalysisStatus(teamID, projectID, token string) (*scanner.AnalysisStatus, error)
+	GetAnalyses(teamID, projectID, token string, page *pagination.Pagination) ([]analyses.Analysis, error)
+}
+
+// ScanAdder adds the results of external scans to an analysis
+type ScanAdder interface {
+	AddScanResult(scanResultID, teamID, projectID, status, scanType, token string, scanResults scanner.ExternalScan) (*scanner.AnalysisStatus, error)
+}
+
+// Evaluator reads the rulesets analyses are evaluated against, and the
+// evaluations
+type Evaluator interface {
+	GetAppliedRuleSet(projectID, teamID, analysisID, token string) (*rulesets.AppliedRulesetSummary, error)
+	GetRuleSets(teamID, token string, page *pagination.Pagination) ([]rulesets.RuleSet, error)
+}
+
+// Projects finds and creates projects
+type Projects interface {
+	CreateProject(project *projects.Project, teamID, token string) (*projects.Project, error)
+	GetProjects(teamID, token string, page *pagination.Pagination, filter *projects.Filter) ([]projects.Project, error)
+	GetProjectByURL(uri, teamID, token string) (*projects.Project, error)
+	AddAlias(alias ionic.AddAliasOptions, token string) (*aliases.Alias, error)
+}
+
+// Users logs in and reads the user the token belongs to
+type Users interface {
+	Login(username, password string) (*ionic.Session, error)
+	GetSelf(token string) (*users.User, error)
+	GetTeams(token string) ([]teams.Team, error)
+}
+
+// Dependencies resolves the dependencies of manifests and finds the versions
+// of packages
+type Dependencies interface {
+	ResolveDependenciesInFile(o dependencies.DependencyResolutionRequest, token string) (*dependencies.DependencyResolutionResponse, error)
+	GetLatestVersionForDependency(packageName, ecosystem, token string) (*dependencies.Dependency, error)
+	GetVersionsForDependency(packageName, ecosystem, token string) ([]dependencies.Dependency, error)
+}
+
+var _ Client = (*ionic.IonClient)(nil)
+
+// NotFound reports whether the error is the API saying what was asked for does
+// not exist.  Most ionic calls flatten the errors of their requests into
+// strings, so the status ionic prefixes them with is matched as well.
+func NotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var ierr *ionerrors.IonError
+	if errors.As(err, &ierr) {
+		return ierr.ResponseStatus == http.StatusNotFound
+	}
+
+	return strings.Contains(err.Error(), fmt.Sprintf("ionic: (%v)", http.StatusNotFound))
+}
@@ -0,0 +1,617 @@
+// Package clienttest provides a fake Ion Channel API for testing ionize
+// offline.  The fake keeps its users, teams, projects, and rulesets in memory,
+// moves each analysis through a scripted list of statuses, evaluates them with
+// scripted rule results, lists them with the coverage added to them, resolves
+// manifests to a scripted dependency tree, and can fail any endpoint on
+// demand.
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/aliases"
+	"github.com/ion-channel/ionic/dependencies"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionic/teams"
+	"github.com/ion-channel/ionic/users"
+)
+
+const (
+	// ArtifactsPath is where the contents of Artifacts are served, so they
+	// can be used as the source of artifact projects
+	ArtifactsPath = "/artifacts/"
+
+	// InjectedFailure is the message of responses failed with Fail
+	InjectedFailure = "injected failure"
+)
+
+// Epoch is when the first analysis was created, each one after it was created
+// a minute later
+var Epoch = time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+
+// Rule is the result of one rule of the ruleset an analysis is evaluated
+// against
+type Rule struct {
+	Summary string
+	Type    string
+	Risk    string
+	Passed  bool
+}
+
+// Scan is an external scan added to an analysis
+type Scan struct {
+	AnalysisID string
+	TeamID     string
+	ProjectID  string
+	Type       string
+	Results    scanner.ExternalScan
+}
+
+// Manifest is a manifest sent to have its dependencies resolved
+type Manifest struct {
+	Ecosystem string
+	File      string
+	Content   []byte
+}
+
+// Server is a fake Ion Channel API.  Its fields can be changed before the
+// first request and between requests.
+type Server struct {
+	*httptest.Server
+
+	// Key is the only key requests are accepted with besides the session
+	// token, any key is accepted when it is empty
+	Key string
+
+	// User is who the key belongs to, and who can log in with Password
+	User     users.User
+	Password string
+
+	// Token is the session token returned when logging in
+	Token string
+
+	Teams    []teams.Team
+	Projects []projects.Project
+	Rulesets []rulesets.RuleSet
+
+	// Artifacts are served under ArtifactsPath by name
+	Artifacts map[string][]byte
+
+	// Statuses are the statuses each analysis moves through, starting with
+	// the first when it is requested and moving to the next each time its
+	// status is checked, staying at the last
+	Statuses []string
+
+	// Message is the message of every analysis status, such as why it
+	// errored
+	Message string
+
+	// RetryAfter is sent as the Retry-After header of responses failed with
+	// Fail
+	RetryAfter string
+
+	// Rules are the results of evaluating every analysis, which passes when
+	// they all do
+	Rules []Rule
+
+	// Dependencies are the tree every manifest resolves to, DependencyMeta the
+	// counts given with it, and Versions the known versions of each package by
+	// name, oldest first
+	Dependencies   []dependencies.Dependency
+	DependencyMeta dependencies.Meta
+	Versions       map[string][]string
+
+	mu        sync.Mutex
+	analyses  []*analysis
+	scans     []Scan
+	manifests []Manifest
+	aliases   []ionic.AddAliasOptions
+	requests  []string
+	failures  map[string][]int
+}
+
+type analysis struct {
+	status scanner.AnalysisStatus
+	step   int
+}
+
+// NewServer starts a fake with one team, one ruleset, and one rule that
+// every analysis passes after being analyzed for one status check
+func NewServer() *Server {
+	s := &Server{
+		User: users.User{
+			ID:       "user-1",
+			Username: "someone",
+			Email:    "someone@example.com",
+			Teams:    map[string]string{"team-1": "admin"},
+		},
+		Token:    "session-token",
+		Teams:    []teams.Team{{ID: "team-1", Name: "Some Team"}},
+		Rulesets: []rulesets.RuleSet{{ID: "ruleset-1", TeamID: "team-1", Name: "Default"}},
+		Statuses: []string{scanner.AnalysisStatusAnalyzing, scanner.AnalysisStatusFinished},
+		Rules:    []Rule{{Summary: "Coverage", Type: "coverage", Risk: "low", Passed: true}},
+		failures: map[string][]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// Client returns an ionic client for the fake
+func (s *Server) Client() *ionic.IonClient {
+	cli, err := ionic.New(s.URL)
+	if err != nil {
+		panic(fmt.Sprintf("clienttest: failed to create client: %v", err.Error()))
+	}
+
+	return cli
+}
+
+// Fail responds to the next requests to the endpoint, such as
+// scanner.ScannerAnalyzeProjectEndpoint, with the statuses in order
+func (s *Server) Fail(endpoint string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoint = "/" + strings.TrimPrefix(endpoint, "/")
+	s.failures[endpoint] = append(s.failures[endpoint], statuses...)
+}
+
+// Requests returns the method and path of every request made, in order
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+// Analyses returns the current status of every analysis requested, in order
+func (s *Server) Analyses() []scanner.AnalysisStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := []scanner.AnalysisStatus{}
+	for _, a := range s.analyses {
+		statuses = append(statuses, a.status)
+	}
+
+	return statuses
+}
+
+// Scans returns every external scan added, in order
+func (s *Server) Scans() []Scan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Scan{}, s.scans...)
+}
+
+// Manifests returns every manifest resolved, in order
+func (s *Server) Manifests() []Manifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Manifest{}, s.manifests...)
+}
+
+// Aliases returns every alias added, in order
+func (s *Server) Aliases() []ionic.AddAliasOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ionic.AddAliasOptions{}, s.aliases...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if failures := s.failures[r.URL.Path]; len(failures) > 0 {
+		s.failures[r.URL.Path] = failures[1:]
+		if s.RetryAfter != "" {
+			w.Header().Set("Retry-After", s.RetryAfter)
+		}
+		s.error(w, failures[0], InjectedFailure)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, ArtifactsPath) {
+		s.artifact(w, r)
+		return
+	}
+
+	if r.URL.Path != "/v1/sessions/login" && !s.authorized(r) {
+		s.error(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	q := r.URL.Query()
+	switch r.URL.Path {
+	case "/v1/sessions/login":
+		s.login(w, r)
+	case "/v1/users/getSelf":
+		s.respond(w, s.User)
+	case "/v1/teams/getTeams":
+		s.respond(w, s.Teams)
+	case "/v1/project/createProject":
+		s.createProject(w, r)
+	case "/v1/project/getProjects":
+		ps := []projects.Project{}
+		for _, p := range s.Projects {
+			if team := q.Get("team_id"); team == "" || p.TeamID == nil || *p.TeamID == team {
+				ps = append(ps, p)
+			}
+		}
+		s.respond(w, ps)
+	case "/v1/project/getProjectByUrl":
+		for _, p := range s.Projects {
+			if p.Source != nil && *p.Source == q.Get("url") && (p.TeamID == nil || *p.TeamID == q.Get("team_id")) {
+				s.respond(w, p)
+				return
+			}
+		}
+		s.error(w, http.StatusNotFound, "project not found")
+	case "/v1/project/addAlias":
+		var o ionic.AddAliasOptions
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.aliases = append(s.aliases, o)
+		s.respond(w, aliases.Alias{ID: fmt.Sprintf("alias-%v", len(s.aliases)), Name: o.Name, Org: o.Org, Version: o.Version})
+	case "/v1/ruleset/getRulesets":
+		rs := []rulesets.RuleSet{}
+		for _, ruleset := range s.Rulesets {
+			if team := q.Get("team_id"); team == "" || ruleset.TeamID == "" || ruleset.TeamID == team {
+				rs = append(rs, ruleset)
+			}
+		}
+		s.respond(w, rs)
+	case "/v1/ruleset/getRuleset":
+		for _, rs := range s.Rulesets {
+			if rs.ID == q.Get("id") {
+				s.respond(w, rs)
+				return
+			}
+		}
+		s.error(w, http.StatusNotFound, "ruleset not found")
+	case "/v1/ruleset/getAppliedRulesetForProject":
+		s.evaluate(w, q.Get("analysis_id"))
+	case "/v1/scanner/analyzeProject":
+		s.analyze(w, r)
+	case "/v1/scanner/getAnalysisStatus":
+		a := s.find(q.Get("id"))
+		if a == nil {
+			s.error(w, http.StatusNotFound, "analysis not found")
+			return
+		}
+		if a.step < len(s.Statuses)-1 {
+			a.step++
+		}
+		a.status.Status = s.status(a.step)
+		s.respond(w, a.status)
+	case "/v1/scanner/getLatestAnalysisStatus":
+		for i := len(s.analyses) - 1; i >= 0; i-- {
+			if s.analyses[i].status.ProjectID == q.Get("project_id") && s.analyses[i].status.TeamID == q.Get("team_id") {
+				s.respond(w, s.analyses[i].status)
+				return
+			}
+		}
+		s.error(w, http.StatusNotFound, "analysis not found")
+	case "/v1/scanner/addScanResult":
+		s.addScan(w, r)
+	case "/v1/animal/getAnalyses":
+		s.listAnalyses(w, q.Get("team_id"), q.Get("project_id"), q.Get("offset"), q.Get("limit"))
+	case "/" + dependencies.ResolveDependenciesInFileEndpoint:
+		s.resolve(w, r)
+	case "/" + dependencies.GetLatestVersionForDependencyEndpoint:
+		versions := s.Versions[q.Get("name")]
+		if len(versions) == 0 {
+			s.error(w, http.StatusNotFound, "dependency not found")
+			return
+		}
+		s.respond(w, dependencies.Dependency{Version: versions[len(versions)-1], Type: q.Get("type")})
+	case "/" + dependencies.GetVersionsForDependencyEndpoint:
+		versions, ok := s.Versions[q.Get("name")]
+		if !ok {
+			s.error(w, http.StatusNotFound, "dependency not found")
+			return
+		}
+		s.respond(w, versions)
+	default:
+		s.error(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || auth == "Bearer " {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return s.Key == "" || token == s.Key || token == s.Token
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Username != s.User.Username || body.Password != s.Password {
+		s.error(w, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+
+	s.respond(w, ionic.Session{BearerToken: s.Token, User: s.User})
+}
+
+func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
+	var p projects.Project
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if missing := s.missing(&p); len(missing) > 0 {
+		s.error(w, http.StatusUnprocessableEntity, "invalid project, missing "+strings.Join(missing, ", "))
+		return
+	}
+
+	if p.ID == nil {
+		id := fmt.Sprintf("project-%v", len(s.Projects)+1)
+		p.ID = &id
+	}
+	s.Projects = append(s.Projects, p)
+
+	s.respond(w, p)
+}
+
+// missing returns the fields the API requires of a project that it is missing
+func (s *Server) missing(p *projects.Project) []string {
+	fields := []string{}
+	for field, value := range map[string]*string{
+		"team_id":     p.TeamID,
+		"ruleset_id":  p.RulesetID,
+		"name":        p.Name,
+		"type":        p.Type,
+		"source":      p.Source,
+		"description": p.Description,
+	} {
+		if value == nil || *value == "" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+func (s *Server) analyze(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TeamID    string `json:"team_id"`
+		ProjectID string `json:"project_id"`
+		Branch    string `json:"branch"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a := &analysis{
+		status: scanner.AnalysisStatus{
+			ID:        fmt.Sprintf("analysis-%v", len(s.analyses)+1),
+			TeamID:    body.TeamID,
+			ProjectID: body.ProjectID,
+			Branch:    body.Branch,
+			Message:   s.Message,
+			Status:    s.status(0),
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+	a.status.UpdatedAt = a.status.CreatedAt
+	s.analyses = append(s.analyses, a)
+
+	s.respond(w, a.status)
+}
+
+func (s *Server) addScan(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TeamID    string               `json:"team_id"`
+		ProjectID string               `json:"project_id"`
+		ID        string               `json:"analysis_id"`
+		Results   scanner.ExternalScan `json:"results"`
+		Type      string               `json:"scan_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a := s.find(body.ID)
+	if a == nil {
+		s.error(w, http.StatusNotFound, "analysis not found")
+		return
+	}
+
+	s.scans = append(s.scans, Scan{
+		AnalysisID: body.ID,
+		TeamID:     body.TeamID,
+		ProjectID:  body.ProjectID,
+		Type:       body.Type,
+		Results:    body.Results,
+	})
+
+	s.respond(w, a.status)
+}
+
+// listAnalyses lists the analyses of the project, newest first, with a scan
+// summary for each coverage scan added to them
+func (s *Server) listAnalyses(w http.ResponseWriter, team, project, offset, limit string) {
+	list := []map[string]interface{}{}
+	for i := len(s.analyses) - 1; i >= 0; i-- {
+		a := s.analyses[i]
+		if a.status.TeamID != team || a.status.ProjectID != project {
+			continue
+		}
+
+		summaries := []map[string]interface{}{}
+		for _, scan := range s.scans {
+			if scan.AnalysisID == a.status.ID && scan.Results.Coverage != nil {
+				summaries = append(summaries, map[string]interface{}{
+					"analysis_id": a.status.ID,
+					"name":        "coverage",
+					"results":     map[string]interface{}{"type": "coverage", "data": map[string]float64{"value": scan.Results.Coverage.Value}},
+				})
+			}
+		}
+
+		list = append(list, map[string]interface{}{
+			"id":             a.status.ID,
+			"team_id":        team,
+			"project_id":     project,
+			"branch":         a.status.Branch,
+			"status":         a.status.Status,
+			"created_at":     Epoch.Add(time.Duration(i) * time.Minute),
+			"updated_at":     Epoch.Add(time.Duration(i) * time.Minute),
+			"scan_summaries": summaries,
+		})
+	}
+
+	start, _ := strconv.Atoi(offset)
+	if start > len(list) {
+		start = len(list)
+	}
+	list = list[start:]
+	if n, err := strconv.Atoi(limit); err == nil && n < len(list) {
+		list = list[:n]
+	}
+
+	s.respond(w, list)
+}
+
+// resolve records the manifest uploaded and responds with the scripted
+// dependency tree
+func (s *Server) resolve(w http.ResponseWriter, r *http.Request) {
+	ecosystem := r.URL.Query().Get("type")
+	if ecosystem == "" {
+		s.error(w, http.StatusBadRequest, "type is required")
+		return
+	}
+
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.manifests = append(s.manifests, Manifest{Ecosystem: ecosystem, File: header.Filename, Content: content})
+
+	s.respond(w, dependencies.DependencyResolutionResponse{
+		Dependencies: s.Dependencies,
+		Meta:         s.DependencyMeta,
+	})
+}
+
+func (s *Server) evaluate(w http.ResponseWriter, id string) {
+	a := s.find(id)
+	if a == nil {
+		s.error(w, http.StatusNotFound, "analysis not found")
+		return
+	}
+
+	type result struct {
+		Summary string          `json:"summary"`
+		Type    string          `json:"type"`
+		Risk    string          `json:"risk"`
+		Passed  bool            `json:"passed"`
+		Results json.RawMessage `json:"results"`
+	}
+
+	passed := true
+	results := []result{}
+	for _, rule := range s.Rules {
+		passed = passed && rule.Passed
+		results = append(results, result{
+			Summary: rule.Summary,
+			Type:    rule.Type,
+			Risk:    rule.Risk,
+			Passed:  rule.Passed,
+			Results: json.RawMessage(`{"type":"coverage","data":{"value":0}}`),
+		})
+	}
+
+	s.respond(w, map[string]interface{}{
+		"project_id":  a.status.ProjectID,
+		"team_id":     a.status.TeamID,
+		"analysis_id": a.status.ID,
+		"rule_evaluation_summary": map[string]interface{}{
+			"passed":      passed,
+			"ruleresults": results,
+		},
+	})
+}
+
+func (s *Server) artifact(w http.ResponseWriter, r *http.Request) {
+	b, ok := s.Artifacts[strings.TrimPrefix(r.URL.Path, ArtifactsPath)]
+	if !ok {
+		s.error(w, http.StatusNotFound, "artifact not found")
+		return
+	}
+
+	w.Write(b)
+}
+
+func (s *Server) find(id string) *analysis {
+	for _, a := range s.analyses {
+		if a.status.ID == id {
+			return a
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) status(step int) string {
+	if len(s.Statuses) == 0 {
+		return scanner.AnalysisStatusFinished
+	}
+
+	return s.Statuses[step]
+}
+
+func (s *Server) respond(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (s *Server) error(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": message, "code": status})
+}
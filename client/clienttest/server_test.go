@@ -0,0 +1,186 @@
+package clienttest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/dependencies"
+	"github.com/ion-channel/ionic/pagination"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionic/scans"
+	. "github.com/onsi/gomega"
+)
+
+func TestServer(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Fake Ion Channel API", func() {
+		var server *Server
+
+		g.BeforeEach(func() {
+			server = NewServer()
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		g.It("should move analyses through the scripted statuses", func() {
+			server.Statuses = []string{"queued", "analyzing", "finished"}
+			cli := server.Client()
+
+			status, err := cli.AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err).To(BeNil())
+			Expect(status.ID).To(Equal("analysis-1"))
+			Expect(status.Status).To(Equal("queued"))
+
+			for _, expected := range []string{"analyzing", "finished", "finished"} {
+				status, err = cli.GetAnalysisStatus("analysis-1", "team-1", "project-1", "some-key")
+				Expect(err).To(BeNil())
+				Expect(status.Status).To(Equal(expected))
+			}
+
+			_, err = cli.GetAnalysisStatus("analysis-2", "team-1", "project-1", "some-key")
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should record scans and evaluate analyses with the scripted rules", func() {
+			server.Rules = append(server.Rules, Rule{Summary: "Vulnerabilities", Type: "vulnerabilities", Risk: "high"})
+			cli := server.Client()
+
+			status, _ := cli.AnalyzeProject("project-1", "team-1", "", "some-key")
+			scan := scanner.ExternalScan{Coverage: &scanner.ExternalCoverage{Value: 87.5}}
+			_, err := cli.AddScanResult(status.ID, "team-1", "project-1", "accepted", "coverage", "some-key", scan)
+			Expect(err).To(BeNil())
+			Expect(server.Scans()).To(Equal([]Scan{{AnalysisID: "analysis-1", TeamID: "team-1", ProjectID: "project-1", Type: "coverage", Results: scan}}))
+
+			eval, err := cli.GetAppliedRuleSet("project-1", "team-1", status.ID, "some-key")
+			Expect(err).To(BeNil())
+			Expect(eval.RuleEvaluationSummary.Passed).To(BeFalse())
+			Expect(eval.RuleEvaluationSummary.Ruleresults).To(HaveLen(2))
+			Expect(eval.RuleEvaluationSummary.Ruleresults[1].Risk).To(Equal("high"))
+		})
+
+		g.It("should list analyses with their coverage, newest first", func() {
+			server.Statuses = []string{"finished"}
+			cli := server.Client()
+
+			for i, branch := range []string{"main", "feature", "main"} {
+				status, _ := cli.AnalyzeProject("project-1", "team-1", branch, "some-key")
+				cli.AddScanResult(status.ID, "team-1", "project-1", "accepted", "coverage", "some-key", scanner.ExternalScan{Coverage: &scanner.ExternalCoverage{Value: 80 + float64(i)}})
+			}
+			cli.AnalyzeProject("project-2", "team-1", "main", "some-key")
+
+			list, err := cli.GetAnalyses("team-1", "project-1", "some-key", pagination.New(1, 5))
+			Expect(err).To(BeNil())
+			Expect(list).To(HaveLen(2))
+			Expect(list[0].ID).To(Equal("analysis-2"))
+			Expect(list[0].Branch).To(Equal("feature"))
+			Expect(list[0].Status).To(Equal("finished"))
+			Expect(list[0].CreatedAt).To(Equal(Epoch.Add(time.Minute)))
+			Expect(list[1].ScanSummaries).To(HaveLen(1))
+			Expect(list[1].ScanSummaries[0].TranslatedResults.Data).To(Equal(scans.CoverageResults{Value: 80}))
+		})
+
+		g.It("should resolve manifests to the scripted dependencies", func() {
+			dir, _ := ioutil.TempDir("", "ionize-clienttest")
+			defer os.RemoveAll(dir)
+			manifest := filepath.Join(dir, "Gemfile.lock")
+			ioutil.WriteFile(manifest, []byte("GEM\n"), 0644)
+
+			cli := server.Client()
+			server.Dependencies = []dependencies.Dependency{{Name: "rails", Version: "5.2.0"}}
+			server.DependencyMeta = dependencies.Meta{FirstDegreeCount: 1, TotalUniqueCount: 1}
+			server.Versions = map[string][]string{"rails": {"5.2.0", "6.0.3"}}
+
+			res, err := cli.ResolveDependenciesInFile(dependencies.DependencyResolutionRequest{Ecosystem: "ruby", File: manifest}, "some-key")
+			Expect(err).To(BeNil())
+			Expect(res.Dependencies).To(Equal(server.Dependencies))
+			Expect(res.Meta).To(Equal(server.DependencyMeta))
+			Expect(server.Manifests()).To(Equal([]Manifest{{Ecosystem: "ruby", File: "Gemfile.lock", Content: []byte("GEM\n")}}))
+
+			latest, err := cli.GetLatestVersionForDependency("rails", "ruby", "some-key")
+			Expect(err).To(BeNil())
+			Expect(latest.Version).To(Equal("6.0.3"))
+
+			versions, err := cli.GetVersionsForDependency("rails", "ruby", "some-key")
+			Expect(err).To(BeNil())
+			Expect(versions).To(HaveLen(2))
+
+			_, err = cli.GetLatestVersionForDependency("rake", "ruby", "some-key")
+			Expect(err.Error()).To(ContainSubstring("(404)"))
+		})
+
+		g.It("should fail the endpoint as many times as asked", func() {
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+			cli := server.Client()
+
+			_, err := cli.AnalyzeProject("project-1", "team-1", "", "some-key")
+			Expect(err.Error()).To(ContainSubstring("(503)"))
+			_, err = cli.AnalyzeProject("project-1", "team-1", "", "some-key")
+			Expect(err.Error()).To(ContainSubstring("(429)"))
+			_, err = cli.AnalyzeProject("project-1", "team-1", "", "some-key")
+			Expect(err).To(BeNil())
+
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(server.Requests()).To(HaveLen(3))
+		})
+
+		g.It("should only accept the key or the session token", func() {
+			server.Key = "some-key"
+			server.Password = "hunter2"
+			cli := server.Client()
+
+			_, err := cli.GetSelf("some-other-key")
+			Expect(err).NotTo(BeNil())
+
+			_, err = cli.Login("someone", "wrong")
+			Expect(err).NotTo(BeNil())
+
+			session, err := cli.Login("someone", "hunter2")
+			Expect(err).To(BeNil())
+
+			user, err := cli.GetSelf(session.BearerToken)
+			Expect(err).To(BeNil())
+			Expect(user.Username).To(Equal("someone"))
+		})
+
+		g.It("should create, find, and alias projects", func() {
+			cli := server.Client()
+			source := server.URL + ArtifactsPath + "widget.tgz"
+			server.Artifacts = map[string][]byte{"widget.tgz": []byte("widget")}
+			name, version, ty, team, ruleset, description := "widget", "1.0.0", "artifact", "team-1", "ruleset-1", "sha256:abc"
+
+			_, err := cli.CreateProject(&projects.Project{Name: &name, Source: &source, TeamID: &team}, team, "some-key")
+			Expect(err.Error()).To(ContainSubstring("(422)"))
+
+			created, err := cli.CreateProject(&projects.Project{Name: &name, Branch: &version, Source: &source, Type: &ty, TeamID: &team, RulesetID: &ruleset, Description: &description}, team, "some-key")
+			Expect(err).To(BeNil())
+			Expect(*created.ID).To(Equal("project-1"))
+
+			found, err := cli.GetProjectByURL(source, team, "some-key")
+			Expect(err).To(BeNil())
+			Expect(*found.ID).To(Equal("project-1"))
+
+			_, err = cli.GetProjectByURL(source, "team-2", "some-key")
+			Expect(err).NotTo(BeNil())
+
+			ps, err := cli.GetProjects(team, "some-key", nil, nil)
+			Expect(err).To(BeNil())
+			Expect(ps).To(HaveLen(1))
+
+			alias := ionic.AddAliasOptions{Name: name, ProjectID: *created.ID, TeamID: team, Version: version}
+			_, err = cli.AddAlias(alias, "some-key")
+			Expect(err).To(BeNil())
+			Expect(server.Aliases()).To(Equal([]ionic.AddAliasOptions{alias}))
+		})
+	})
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ion-channel/ionic/dependencies"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/scanner"
+)
+
+const (
+	defaultAttempts   = 4
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Policy is how requests that failed for a reason that may pass are retried
+type Policy struct {
+	// Attempts is how many times a request is made in all, it is not retried
+	// when one or less
+	Attempts int
+
+	// Backoff is the wait before the first retry, doubling with each retry
+	// up to MaxBackoff.  A Retry-After given by the API is waited for
+	// instead, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Logf is told about each retry, nothing is logged when it is nil
+	Logf func(format string, v ...interface{})
+}
+
+// DefaultPolicy makes four attempts, waiting one, two, and then four seconds
+// between them
+func DefaultPolicy() Policy {
+	return Policy{
+		Attempts:   defaultAttempts,
+		Backoff:    defaultBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+}
+
+// wait returns how long to wait before the retry following the attempt,
+// jittered so clients that failed together do not retry together
+func (p *Policy) wait(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if p.MaxBackoff > 0 && retryAfter > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+		return retryAfter
+	}
+
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (p *Policy) logf(format string, v ...interface{}) {
+	if p.Logf != nil {
+		p.Logf(format, v...)
+	}
+}
+
+// Transport retries the requests it passes on that failed with a server
+// error, were rate limited, or lost their connection, when they are safe to
+// make again.  Requests that are not, such as creating a project, are only
+// retried when rate limited, since the API turned them away unprocessed.
+type Transport struct {
+	Next   http.RoundTripper
+	Policy Policy
+
+	// Idempotent lists the paths of the POST endpoints that are safe to
+	// repeat, as other methods besides PATCH are
+	Idempotent map[string]bool
+
+	sleep func(time.Duration) <-chan time.Time
+}
+
+// NewTransport creates a transport retrying with the policy the requests
+// passed on to next, or http.DefaultTransport when it is nil
+func NewTransport(next http.RoundTripper, policy Policy) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Transport{
+		Next:   next,
+		Policy: policy,
+		Idempotent: map[string]bool{
+			"/v1/sessions/login": true,
+			// resolving a manifest changes nothing
+			"/" + dependencies.ResolveDependenciesInFileEndpoint: true,
+		},
+		sleep: time.After,
+	}
+}
+
+// RoundTrip makes the request, retrying it when it can
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method != http.MethodPost && req.Method != http.MethodPatch || t.Idempotent[req.URL.Path]
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+				return nil, fmt.Errorf("failed to retry request: the body can not be sent again")
+			}
+
+			r = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("failed to retry request: %v", err.Error())
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.Next.RoundTrip(r)
+
+		reason, retryAfter := retryable(resp, err, idempotent)
+		if reason == "" || attempt >= t.Policy.Attempts {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		wait := t.Policy.wait(attempt, retryAfter)
+		t.Policy.logf("Retrying %v %v in %v after %v (attempt %v of %v)", req.Method, req.URL.Path, wait.Round(time.Millisecond), reason, attempt+1, t.Policy.Attempts)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-t.sleep(wait):
+		}
+	}
+}
+
+// retryable returns why the response or error can be retried, and how long
+// the API asked to wait, or an empty reason when it can not be
+func retryable(resp *http.Response, err error, idempotent bool) (string, time.Duration) {
+	if err != nil {
+		if idempotent && transient(err) {
+			return err.Error(), 0
+		}
+		return "", 0
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return resp.Status, retryAfter(resp.Header.Get("Retry-After"))
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if idempotent {
+			return resp.Status, retryAfter(resp.Header.Get("Retry-After"))
+		}
+	}
+
+	return "", 0
+}
+
+// transient reports whether the connection failed in a way that may not
+// happen again
+func transient(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// retryAfter reads a Retry-After header given in seconds or as a date
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// failedStatus matches the errors of ionic calls that got a server error or
+// lost their connection, which the ionic client reports as status 0
+var failedStatus = regexp.MustCompile(`ionic: \((?:0|5\d\d)\)`)
+
+// adoptSkew is how long before the first attempt to request an analysis a
+// running analysis may have been created and still be taken for the one the
+// failed attempt requested, allowing for the API's clock being behind
+const adoptSkew = 30 * time.Second
+
+// WithRetries wraps the client so that creating projects and requesting
+// analyses, which the transport does not repeat, are retried after a server
+// error or lost connection.  Before each retry the API is checked for what
+// the failed attempt may have done anyway: a project created for the same
+// source and branch, or an analysis of the same branch created since the
+// first attempt and still running, is returned instead of making another.
+// Projects without a source can not be looked up, so creating them is not
+// retried.
+//
+// The API does not say which request created an analysis, so one requested
+// for the same branch by another build at about the same time may be taken
+// for the failed attempt's.
+func WithRetries(c Client, policy Policy) Client {
+	return &retrying{Client: c, policy: policy, sleep: time.Sleep, now: time.Now}
+}
+
+type retrying struct {
+	Client
+	policy Policy
+	sleep  func(time.Duration)
+	now    func() time.Time
+}
+
+func (r *retrying) retry(name string, attempt int, err error) bool {
+	if attempt >= r.policy.Attempts || !failedStatus.MatchString(err.Error()) {
+		return false
+	}
+
+	wait := r.policy.wait(attempt, 0)
+	r.policy.logf("Retrying %v in %v after %v (attempt %v of %v)", name, wait.Round(time.Millisecond), err.Error(), attempt+1, r.policy.Attempts)
+	r.sleep(wait)
+
+	return true
+}
+
+func (r *retrying) CreateProject(project *projects.Project, teamID, token string) (*projects.Project, error) {
+	for attempt := 1; ; attempt++ {
+		created, err := r.Client.CreateProject(project, teamID, token)
+		if err == nil || project.Source == nil || !r.retry("creating project", attempt, err) {
+			return created, err
+		}
+
+		existing, lerr := r.Client.GetProjectByURL(*project.Source, teamID, token)
+		if lerr == nil && existing.ID != nil && sameString(existing.Branch, project.Branch) {
+			r.policy.logf("Found project %v created by the failed attempt", *existing.ID)
+			return existing, nil
+		}
+	}
+}
+
+func (r *retrying) AnalyzeProject(projectID, teamID, branch, token string) (*scanner.AnalysisStatus, error) {
+	since := r.now().Add(-adoptSkew)
+	for attempt := 1; ; attempt++ {
+		status, err := r.Client.AnalyzeProject(projectID, teamID, branch, token)
+		if err == nil || !r.retry("analysis request", attempt, err) {
+			return status, err
+		}
+
+		latest, lerr := r.Client.GetLatestAnalysisStatus(teamID, projectID, token)
+		if lerr == nil && latest.ID != "" && !latest.Done() && latest.Branch == branch && !latest.CreatedAt.Before(since) {
+			r.policy.logf("Found analysis %v of %v still running", latest.ID, projectID)
+			return latest, nil
+		}
+	}
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client/clienttest"
+	. "github.com/onsi/gomega"
+)
+
+// flaky fails the calls it wraps once with a server error, after they have
+// already been made
+type flaky struct {
+	Client
+	failed bool
+}
+
+func (f *flaky) CreateProject(project *projects.Project, teamID, token string) (*projects.Project, error) {
+	created, err := f.Client.CreateProject(project, teamID, token)
+	if err == nil && !f.failed {
+		f.failed = true
+		return nil, fmt.Errorf("ionic: (502) failed to create project")
+	}
+
+	return created, err
+}
+
+func (f *flaky) AnalyzeProject(projectID, teamID, branch, token string) (*scanner.AnalysisStatus, error) {
+	status, err := f.Client.AnalyzeProject(projectID, teamID, branch, token)
+	if err == nil && !f.failed {
+		f.failed = true
+		return nil, fmt.Errorf("ionic: (0) http request: failed: EOF")
+	}
+
+	return status, err
+}
+
+func TestRetry(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Retries", func() {
+		var server *clienttest.Server
+		var logged []string
+		var policy Policy
+
+		g.BeforeEach(func() {
+			server = clienttest.NewServer()
+			logged = nil
+			policy = Policy{
+				Attempts:   3,
+				Backoff:    time.Millisecond,
+				MaxBackoff: 5 * time.Millisecond,
+				Logf: func(format string, v ...interface{}) {
+					logged = append(logged, fmt.Sprintf(format, v...))
+				},
+			}
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+		})
+
+		viaTransport := func() *ionic.IonClient {
+			cli, err := ionic.NewWithClient(server.URL, &http.Client{Transport: NewTransport(nil, policy)})
+			Expect(err).To(BeNil())
+			return cli
+		}
+
+		g.It("should wait longer before each retry", func() {
+			p := Policy{Backoff: time.Second, MaxBackoff: 3 * time.Second}
+			Expect(p.wait(1, 0)).To(BeNumerically("~", 750*time.Millisecond, 250*time.Millisecond))
+			Expect(p.wait(2, 0)).To(BeNumerically("~", 1500*time.Millisecond, 500*time.Millisecond))
+			Expect(p.wait(5, 0)).To(BeNumerically("~", 2250*time.Millisecond, 750*time.Millisecond))
+			Expect(p.wait(1, 2*time.Second)).To(Equal(2 * time.Second))
+			Expect(p.wait(1, time.Minute)).To(Equal(3 * time.Second))
+
+			Expect(retryAfter("2")).To(Equal(2 * time.Second))
+			Expect(retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))).To(BeNumerically("~", time.Minute, 2*time.Second))
+			Expect(retryAfter("soon")).To(BeZero())
+		})
+
+		g.It("should retry requests that are safe to repeat", func() {
+			server.RetryAfter = "1"
+			server.Fail("v1/users/getSelf", http.StatusServiceUnavailable, http.StatusBadGateway)
+
+			user, err := viaTransport().GetSelf("some-key")
+			Expect(err).To(BeNil())
+			Expect(user.Username).To(Equal("someone"))
+			Expect(server.Requests()).To(HaveLen(3))
+			Expect(logged).To(Equal([]string{
+				"Retrying GET /v1/users/getSelf in 5ms after 503 Service Unavailable (attempt 2 of 3)",
+				"Retrying GET /v1/users/getSelf in 5ms after 502 Bad Gateway (attempt 3 of 3)",
+			}))
+
+			server.Fail("v1/users/getSelf", http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+			_, err = viaTransport().GetSelf("some-key")
+			Expect(err.Error()).To(ContainSubstring("(503)"))
+
+			server.Fail("v1/users/getSelf", http.StatusNotFound)
+			_, err = viaTransport().GetSelf("some-key")
+			Expect(err.Error()).To(ContainSubstring("(404)"))
+			Expect(server.Requests()).To(HaveLen(7))
+		})
+
+		g.It("should only retry other requests when rate limited", func() {
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusServiceUnavailable)
+			_, err := viaTransport().AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err.Error()).To(ContainSubstring("(503)"))
+			Expect(server.Analyses()).To(BeEmpty())
+
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusTooManyRequests)
+			status, err := viaTransport().AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err).To(BeNil())
+			Expect(status.ID).To(Equal("analysis-1"))
+
+			// a retried scan result could be attached to the analysis twice
+			server.Fail(scanner.ScannerAddScanEndpoint, http.StatusInternalServerError)
+			_, err = viaTransport().AddScanResult(status.ID, "team-1", "project-1", "accepted", "coverage", "some-key", scanner.ExternalScan{Coverage: &scanner.ExternalCoverage{Value: 87.5}})
+			Expect(err.Error()).To(ContainSubstring("(500)"))
+			Expect(server.Scans()).To(BeEmpty())
+		})
+
+		g.It("should tell when the API did not find something", func() {
+			_, err := viaTransport().GetAnalysisStatus("analysis-9", "team-1", "project-1", "some-key")
+			Expect(NotFound(err)).To(BeTrue())
+
+			Expect(NotFound(errors.New("ionic: (500) api: error response"))).To(BeFalse())
+			Expect(NotFound(nil)).To(BeFalse())
+		})
+
+		g.It("should retry creating a project unless the failed attempt created it", func() {
+			server.Fail("v1/project/createProject", http.StatusServiceUnavailable)
+			cli := WithRetries(server.Client(), policy).(*retrying)
+			cli.sleep = func(time.Duration) {}
+
+			name, branch, ty, team, ruleset, description := "widget", "main", "git", "team-1", "ruleset-1", "widget"
+			source := "git@example.com:widget.git"
+			project := &projects.Project{Name: &name, Branch: &branch, Source: &source, Type: &ty, TeamID: &team, RulesetID: &ruleset, Description: &description}
+
+			created, err := cli.CreateProject(project, team, "some-key")
+			Expect(err).To(BeNil())
+			Expect(*created.ID).To(Equal("project-1"))
+
+			cli.Client = &flaky{Client: server.Client()}
+			source = "git@example.com:gadget.git"
+			created, err = cli.CreateProject(project, team, "some-key")
+			Expect(err).To(BeNil())
+			Expect(*created.ID).To(Equal("project-2"))
+			Expect(server.Projects).To(HaveLen(2))
+			Expect(logged[len(logged)-1]).To(Equal("Found project project-2 created by the failed attempt"))
+
+			name = ""
+			_, err = cli.CreateProject(project, team, "some-key")
+			Expect(err.Error()).To(ContainSubstring("(422)"))
+
+			name = "gizmo"
+			project.Source = nil
+			server.Fail("v1/project/createProject", http.StatusServiceUnavailable)
+			logged = nil
+			_, err = cli.CreateProject(project, team, "some-key")
+			Expect(err.Error()).To(ContainSubstring("(503)"))
+			Expect(server.Projects).To(HaveLen(2))
+			Expect(logged).To(BeEmpty())
+		})
+
+		g.It("should retry requesting an analysis unless the failed attempt is running", func() {
+			cli := WithRetries(&flaky{Client: server.Client()}, policy).(*retrying)
+			cli.sleep = func(time.Duration) {}
+
+			status, err := cli.AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err).To(BeNil())
+			Expect(status.ID).To(Equal("analysis-1"))
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(logged).To(HaveLen(2))
+			Expect(logged[1]).To(Equal("Found analysis analysis-1 of project-1 still running"))
+
+			server.Statuses = []string{scanner.AnalysisStatusFinished}
+			cli.Client = &flaky{Client: server.Client()}
+			status, err = cli.AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err).To(BeNil())
+			Expect(status.ID).To(Equal("analysis-3"))
+
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+			_, err = cli.AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err.Error()).To(ContainSubstring("(502)"))
+		})
+
+		g.It("should not take an analysis started before the request for its own", func() {
+			server.Client().AnalyzeProject("project-1", "team-1", "main", "some-key")
+
+			cli := WithRetries(server.Client(), policy).(*retrying)
+			cli.sleep = func(time.Duration) {}
+			cli.now = func() time.Time { return time.Now().Add(time.Hour) }
+
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusBadGateway)
+			status, err := cli.AnalyzeProject("project-1", "team-1", "main", "some-key")
+			Expect(err).To(BeNil())
+			Expect(status.ID).To(Equal("analysis-2"))
+			Expect(server.Analyses()).To(HaveLen(2))
+		})
+	})
+}
@@ -1,21 +1,33 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"time"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
 
-	"github.com/ion-channel/ionic"
-	"github.com/ion-channel/ionic/rulesets"
 	"github.com/ion-channel/ionize/cmd/external"
+	"github.com/ion-channel/ionize/config"
+	"github.com/ion-channel/ionize/git"
+	"github.com/ion-channel/ionize/redact"
+	"github.com/ion-channel/ionize/runner"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	async  = false
-	dryRun = false
+	async        = false
+	dryRun       = false
+	strict       = false
+	analyzeAll   = false
+	projectName  = ""
+	changedSince = ""
+	parallel     = 4
 )
 
 func init() {
@@ -23,6 +35,11 @@ func init() {
 
 	analyzeCmd.Flags().BoolVarP(&async, "async", "a", false, "run the command asynchronously without waiting for completion")
 	analyzeCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "run the command but don't return non zero on failure")
+	analyzeCmd.Flags().BoolVarP(&strict, "strict", "", false, "fail when the configs have problems instead of warning about them")
+	analyzeCmd.Flags().BoolVarP(&analyzeAll, "all", "", false, "analyze every project listed in the config")
+	analyzeCmd.Flags().StringVarP(&projectName, "project-name", "", "", "analyze the project with this name from the projects listed in the config")
+	analyzeCmd.Flags().StringVarP(&changedSince, "changed-since", "", "", "analyze the listed projects with files changed on HEAD since the git ref")
+	analyzeCmd.Flags().IntVarP(&parallel, "parallel", "", 4, "how many projects to analyze at once")
 }
 
 // AnalyzeCmd represents the doAnalysis command
@@ -33,132 +50,403 @@ var analyzeCmd = &cobra.Command{
 
 ionize analyze
 
-Will read the configuration from the $PWD/.ionize.yaml file and begin an analysis.
+Will read the configuration from the .ionize.yaml file in $PWD or its parents and
+begin an analysis.  When the config lists several projects, --all analyzes each of
+them and --project-name analyzes one by name.  --changed-since analyzes those
+with files changed on HEAD since it diverged from the ref, matching the changed
+files against each project's paths patterns, or its path when it has none.
+Otherwise the project whose path holds $PWD is analyzed.  Several projects are
+analyzed in parallel, and their reports are printed together with a summary.
+The external reports are checked against the local policy in the config once
+they are read, and with --fail-fast the analysis is not requested when a rule
+of it fails.
+With --offline the external reports are checked as by ionize external validate
+instead, without contacting the API.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Run the analysis from the . file")
-		key := viper.GetString("key")
-		api := viper.GetString("api")
-		cli, err := ionic.New(api)
+		os.Exit(runAnalyze())
+	},
+}
+
+// runAnalyze analyzes the configured projects and returns the code to exit
+// with
+func runAnalyze() int {
+	logger.Infof("Run the analysis from the . file")
+	problems := configProblems()
+	for _, p := range problems {
+		logger.Warnf("Config problem: %v", p)
+	}
+	if strict && len(problems) > 0 {
+		return failed(&runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Found %v config problem(s), see ionize configs validate", len(problems))})
+	}
+
+	p, err := loadPolicy()
+	if err != nil {
+		return failed(&runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Failed to read the local policy: %v", err.Error())})
+	}
+	localPolicy = p
+
+	if offline {
+		return runValidate()
+	}
+
+	r, err := newRunner()
+	if err != nil {
+		return failed(err)
+	}
+
+	targets, err := analysisTargets()
+	if err != nil {
+		return failed(&runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Failed to find the project to analyze: %v", err.Error())})
+	}
+
+	if len(targets) == 0 {
+		fmt.Fprintf(output, "No projects have changed since %v\n", changedSince)
+		return 0
+	}
+
+	branch := getBranch()
+	if len(targets) == 1 {
+		res, err := r.Analyze(context.Background(), targets[0].options(branch))
 		if err != nil {
-			log.Fatalf("Failed to create Ion Channel Client: %v", err.Error())
+			return failed(err)
 		}
-		project := viper.GetString("project")
-		team := viper.GetString("team")
-		branch := getBranch()
-		analysisStatus, err := cli.AnalyzeProject(project, team, branch, key)
-		if err != nil {
-			log.Fatalf("Analysis request failed for %s: %v", project, err.Error())
+		return resultCode(res, nil)
+	}
+
+	results := analyzeTargets(r, targets, branch, parallel)
+	return printResults(output, results)
+}
+
+// analysisTarget is a project to analyze along with the external reports to
+// add to its analysis
+type analysisTarget struct {
+	Name            string   `mapstructure:"name"`
+	Team            string   `mapstructure:"team"`
+	Project         string   `mapstructure:"project"`
+	Path            string   `mapstructure:"path"`
+	Paths           []string `mapstructure:"paths"`
+	Coverage        string   `mapstructure:"coverage"`
+	Vulnerabilities []string `mapstructure:"vulnerabilities"`
+	Fortify         string   `mapstructure:"fortify"`
+}
+
+// options returns the options for analyzing the target on the branch
+func (t *analysisTarget) options(branch string) *runner.AnalyzeOptions {
+	return &runner.AnalyzeOptions{
+		Team:    t.Team,
+		Project: t.Project,
+		Branch:  branch,
+		Reports: external.Reports{
+			Coverage:        t.Coverage,
+			Vulnerabilities: t.Vulnerabilities,
+			Fortify:         t.Fortify,
+		},
+		Policy: localPolicy,
+		Trend:  trendOptions(),
+		Async:  async,
+	}
+}
+
+// analysisTargets returns the projects to analyze, which is the project at
+// the top of the config unless it lists several projects
+func analysisTargets() ([]*analysisTarget, error) {
+	top := &analysisTarget{
+		Name:    viper.GetString("project"),
+		Team:    viper.GetString("team"),
+		Project: viper.GetString("project"),
+	}
+	if viper.IsSet("coverage") {
+		top.Coverage = viper.GetString("coverage")
+	}
+	if viper.IsSet("vulnerabilities") {
+		top.Vulnerabilities = viper.GetStringSlice("vulnerabilities")
+	}
+	if viper.IsSet("fortify") {
+		top.Fortify = viper.GetString("fortify")
+	}
+
+	var listed []*analysisTarget
+	err := viper.UnmarshalKey(config.ProjectsKey, &listed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read projects: %v", err.Error())
+	}
+
+	if len(listed) == 0 {
+		if analyzeAll || projectName != "" || changedSince != "" {
+			return nil, fmt.Errorf("the config does not list any projects")
 		}
-		id := analysisStatus.ID
-		aID := &external.AnalysisID{
-			ID:        id,
-			TeamID:    team,
-			ProjectID: project,
-			APIKey:    key,
+		return []*analysisTarget{top}, nil
+	}
+
+	names := []string{}
+	for _, t := range listed {
+		if t.Team == "" {
+			t.Team = top.Team
 		}
+		names = append(names, t.Name)
+	}
 
-		if viper.IsSet("coverage") {
-			coverage, err := external.ParseCoverage(viper.GetString("coverage"))
-			if err != nil {
-				log.Fatalf("Analysis request failed for %s: %v", project, err.Error())
-			}
-			analysisStatus, err = coverage.Save(aID, cli)
-			if err != nil {
-				log.Fatalf("Analysis Report request failed for %s: %v", project, err.Error())
+	switch {
+	case changedSince != "":
+		return changedTargets(listed, changedSince)
+
+	case analyzeAll:
+		return listed, nil
+
+	case projectName != "":
+		for _, t := range listed {
+			if t.Name == projectName {
+				return []*analysisTarget{t}, nil
 			}
 		}
+		return nil, fmt.Errorf("no project is named %v, the config lists %v", projectName, strings.Join(names, ", "))
 
-		if viper.IsSet("vulnerabilities") {
-			files := viper.GetStringSlice("vulnerabilities")
+	case top.Project != "":
+		return []*analysisTarget{top}, nil
+	}
 
-			for _, file := range files {
-				vulns, err := external.ParseVulnerabilities(file)
-				if err != nil {
-					log.Fatalf("Analysis request failed for %s: %v", project, err.Error())
-				}
-				analysisStatus, err = vulns.Save(aID, cli)
-				if err != nil {
-					log.Fatalf("Analysis Report request failed for %s: %v", project, err.Error())
-				}
-			}
+	wd, err := os.Getwd()
+	if err == nil {
+		if t := targetForDir(listed, wd); t != nil {
+			return []*analysisTarget{t}, nil
 		}
+	}
 
-		if viper.IsSet("fortify") {
-			fortify, err := external.ParseFortify(viper.GetString("fortify"))
-			if err != nil {
-				log.Fatalf("Analysis request failed for %s: %v", project, err.Error())
-			}
-			analysisStatus, err = fortify.Save(aID, cli)
-			if err != nil {
-				log.Fatalf("Analysis Report request failed for %s: %v", project, err.Error())
-			}
+	return nil, fmt.Errorf("the config lists %v projects (%v), choose with --all or --project-name", len(listed), strings.Join(names, ", "))
+}
+
+// targetForDir returns the listed project with the deepest path holding the
+// dir, if any does
+func targetForDir(listed []*analysisTarget, dir string) *analysisTarget {
+	var found *analysisTarget
+	longest := -1
+
+	for _, t := range listed {
+		if t.Path == "" {
+			continue
 		}
 
-		if !async {
-			fmt.Print("Waiting for analysis to finish")
-			for !analysisStatus.Done() {
-				fmt.Print(".")
-				time.Sleep(10 * time.Second)
-				analysisStatus, err = cli.GetAnalysisStatus(id, team, project, key)
-				if err != nil {
-					log.Fatalf("Analysis Status request failed for %s: %v", project, err.Error())
-				}
-			}
-			fmt.Printf("%s\n", analysisStatus.Status)
-			if analysisStatus.Status == "errored" {
-				log.Fatalf("Analysis error occurred. Final analysis status: %v", analysisStatus.Message)
+		path, err := filepath.Abs(t.Path)
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(path, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		if len(path) > longest {
+			found = t
+			longest = len(path)
+		}
+	}
+
+	return found
+}
+
+// changedTargets returns the listed projects with files changed on HEAD since
+// it diverged from the ref.  A project is changed when a file matches one of
+// its paths patterns, or is in its path when it has none.  Projects with
+// neither are always analyzed, as their changes can not be told.
+func changedTargets(listed []*analysisTarget, ref string) ([]*analysisTarget, error) {
+	root, err := git.Root(".")
+	if err != nil {
+		return nil, err
+	}
+	root = resolveSymlinks(root)
+
+	files, err := git.ChangedFiles(root, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		files[i] = filepath.ToSlash(filepath.Join(root, files[i]))
+	}
+
+	changed := []*analysisTarget{}
+	for _, t := range listed {
+		patterns := t.Paths
+		if len(patterns) == 0 && t.Path != "" {
+			patterns = []string{t.Path}
+		}
+
+		if len(patterns) == 0 {
+			changed = append(changed, t)
+			continue
+		}
+
+		if changedFiles(patterns, files) {
+			changed = append(changed, t)
+		}
+	}
+
+	return changed, nil
+}
+
+// changedFiles reports whether any of the absolute, slash separated files
+// match one of the patterns, with the symlinks leading to both resolved
+func changedFiles(patterns, files []string) bool {
+	for _, p := range patterns {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		abs = resolveSymlinks(abs)
+
+		for _, f := range files {
+			if git.Match(filepath.ToSlash(abs), f) {
+				return true
 			}
+		}
+	}
+
+	return false
+}
 
-			fmt.Println("Checking status of scans")
-			eval, err := cli.GetAppliedRuleSet(project, team, id, key)
-			if err != nil {
-				log.Fatalf("Analysis evaluation request failed for %s (%s): %v", project, id, err.Error())
+// resolveSymlinks resolves the symlinks of the longest leading part of the
+// path that exists and has no glob in it, since git reports the files of a
+// checkout reached through a symlink by their real path
+func resolveSymlinks(path string) string {
+	dir := path
+	for {
+		if !strings.ContainsAny(dir, "*?[") {
+			resolved, err := filepath.EvalSymlinks(dir)
+			if err == nil {
+				return filepath.Join(resolved, strings.TrimPrefix(path, dir))
 			}
+		}
 
-			os.Exit(printEval(eval))
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path
 		}
-	},
+		dir = parent
+	}
 }
 
-func getBranch() string {
-	branch := os.Getenv("GIT_BRANCH")
-	if branch != "" {
-		fmt.Println("Using branch from environment variable", branch)
-		return branch
+// analysisResult is the outcome of analyzing a target, along with what was
+// printed while analyzing it
+type analysisResult struct {
+	Target *analysisTarget
+	Result *runner.Result
+	Err    error
+
+	output *bytes.Buffer
+}
+
+// id returns the id of the analysis, if it was requested
+func (r *analysisResult) id() string {
+	if r.Result == nil {
+		return ""
 	}
 
-	branch = os.Getenv("TRAVIS_BRANCH")
-	if branch != "" {
-		fmt.Println("Using branch from travis-ci", branch)
-		return branch
+	return r.Result.AnalysisID
+}
+
+// status describes the outcome for the summary
+func (r *analysisResult) status() string {
+	switch {
+	case r.Err != nil:
+		return "errored"
+	case policyFailed(r.Result):
+		return "failed"
+	case async:
+		return "requested"
+	case r.Result.Passed():
+		return "passed"
 	}
 
-	//TODO: get it from git directly?
-	return ""
+	return "failed"
 }
 
-func printEval(rulesetEvaluation *rulesets.AppliedRulesetSummary) int {
-	for _, scanSummary := range rulesetEvaluation.RuleEvaluationSummary.Ruleresults {
-		fmt.Print(scanSummary.Summary, "...Rule Type: ")
-		fmt.Print(scanSummary.Type, "...")
-		if scanSummary.Passed {
-			fmt.Print("passed")
-		} else {
-			fmt.Print("not passed")
-		}
+// analyzeTargets analyzes the targets with at most parallel running at once,
+// buffering what each prints, and returns the results in the order of the
+// targets
+func analyzeTargets(r *runner.Runner, targets []*analysisTarget, branch string, parallel int) []*analysisResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]*analysisResult, len(targets))
+	sem := make(chan struct{}, parallel)
+	wg := &sync.WaitGroup{}
 
-		fmt.Println("...Risk: ", scanSummary.Risk)
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, t *analysisTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			buf := &bytes.Buffer{}
+			tr := *r
+			tr.Out = buf
+
+			res, err := tr.Analyze(context.Background(), t.options(branch))
+			results[i] = &analysisResult{Target: t, Result: res, Err: err, output: buf}
+		}(i, t)
 	}
+	wg.Wait()
+
+	return results
+}
+
+// printResults prints the report of each result followed by a summary, and
+// returns the worst exit code of them all
+func printResults(w io.Writer, results []*analysisResult) int {
+	exit := 0
+	passed := 0
+	for _, r := range results {
+		fmt.Fprintf(w, "==> %v\n", r.Target.Name)
+		if r.output != nil {
+			w.Write(r.output.Bytes())
+		}
+		if r.Err != nil {
+			fmt.Fprintf(w, "Analysis of %v failed: %v\n", r.Target.Name, redact.Error(r.Err))
+		}
+		fmt.Fprintln(w)
 
-	if !rulesetEvaluation.RuleEvaluationSummary.Passed {
-		fmt.Println("Analysis failed on a rule")
-		if !dryRun {
-			return 1
+		if code := resultCode(r.Result, r.Err); code > exit {
+			exit = code
 		}
+		if r.Err == nil && (async && !policyFailed(r.Result) || r.Result.Passed()) {
+			passed++
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tANALYSIS\tSTATUS")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", r.Target.Name, r.id(), r.status())
+	}
+	tw.Flush()
 
+	verb := "passed"
+	if async {
+		verb = "were requested"
 	}
+	fmt.Fprintf(w, "%v of %v analyses %v\n", passed, len(results), verb)
 
-	fmt.Println("Analysis passed all rules")
-	return 0
+	return exit
+}
+
+func getBranch() string {
+	branch := os.Getenv("GIT_BRANCH")
+	if branch != "" {
+		logger.Infof("Using branch from environment variable %v", branch)
+		return branch
+	}
+
+	branch = os.Getenv("TRAVIS_BRANCH")
+	if branch != "" {
+		logger.Infof("Using branch from travis-ci %v", branch)
+		return branch
+	}
+
+	//TODO: get it from git directly?
+	return ""
 }
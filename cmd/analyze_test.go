@@ -0,0 +1,418 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client/clienttest"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/runner"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestAnalyze(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Analysis Targets", func() {
+		var dir string
+
+		read := func(contents string) {
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewReader([]byte(contents)))
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-analyze")
+			dir, _ = filepath.EvalSymlinks(dir)
+			os.MkdirAll(filepath.Join(dir, "services", "api", "cmd"), 0755)
+			os.MkdirAll(filepath.Join(dir, "web"), 0755)
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+			analyzeAll = false
+			projectName = ""
+			changedSince = ""
+			viper.Reset()
+		})
+
+		monorepo := func() string {
+			return "team: someteam\nprojects:\n" +
+				"  - name: api\n    project: api-project\n    path: " + filepath.Join(dir, "services", "api") + "\n    vulnerabilities: vulns.json\n" +
+				"  - name: web\n    team: webteam\n    project: web-project\n    path: " + filepath.Join(dir, "web") + "\n    coverage: coverage.txt\n"
+		}
+
+		g.It("should analyze the project at the top of the config", func() {
+			read("team: someteam\nproject: someproject\ncoverage: coverage.txt\nvulnerabilities:\n  - a.json\n  - b.json\n")
+
+			targets, err := analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(Equal([]*analysisTarget{{
+				Name:            "someproject",
+				Team:            "someteam",
+				Project:         "someproject",
+				Coverage:        "coverage.txt",
+				Vulnerabilities: []string{"a.json", "b.json"},
+			}}))
+
+			analyzeAll = true
+			_, err = analysisTargets()
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should analyze every listed project with --all", func() {
+			read(monorepo())
+			analyzeAll = true
+
+			targets, err := analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(HaveLen(2))
+			Expect(targets[0].Team).To(Equal("someteam"))
+			Expect(targets[0].Vulnerabilities).To(Equal([]string{"vulns.json"}))
+			Expect(targets[1].Team).To(Equal("webteam"))
+			Expect(targets[1].Coverage).To(Equal("coverage.txt"))
+		})
+
+		g.It("should analyze the project named with --project-name", func() {
+			read(monorepo())
+
+			projectName = "web"
+			targets, err := analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].Project).To(Equal("web-project"))
+
+			projectName = "mobile"
+			_, err = analysisTargets()
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(Equal("no project is named mobile, the config lists api, web"))
+		})
+
+		g.It("should analyze the project holding the working directory", func() {
+			read(monorepo())
+
+			wd, _ := os.Getwd()
+			defer os.Chdir(wd)
+
+			os.Chdir(filepath.Join(dir, "services", "api", "cmd"))
+			targets, err := analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets[0].Name).To(Equal("api"))
+
+			os.Chdir(dir)
+			_, err = analysisTargets()
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("--all or --project-name"))
+		})
+
+		g.It("should analyze the projects changed since a ref", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+
+			git := func(args ...string) {
+				args = append([]string{"-C", dir, "-c", "user.name=ionize", "-c", "user.email=ionize@example.com"}, args...)
+				exec.Command("git", args...).Run()
+			}
+			commit := func(name string) {
+				os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+				ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+				git("add", name)
+				git("commit", "-q", "-m", name)
+			}
+
+			git("init", "-q")
+			commit("README.md")
+			git("tag", "base")
+
+			wd, _ := os.Getwd()
+			defer os.Chdir(wd)
+			os.Chdir(dir)
+
+			read(monorepo() + "    paths:\n      - web/**/*.js\n      - package.json\n" +
+				"  - name: docs\n    project: docs-project\n")
+			changedSince = "base"
+
+			targets, err := analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].Name).To(Equal("docs"))
+
+			commit("services/api/cmd/main.go")
+			commit("web/README.md")
+			targets, err = analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(HaveLen(2))
+			Expect(targets[0].Name).To(Equal("api"))
+			Expect(targets[1].Name).To(Equal("docs"))
+
+			commit("web/src/app.js")
+			targets, err = analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(HaveLen(3))
+
+			changedSince = "missing"
+			_, err = analysisTargets()
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should find the projects changed in a checkout reached through a symlink", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+
+			git := func(args ...string) {
+				args = append([]string{"-C", dir, "-c", "user.name=ionize", "-c", "user.email=ionize@example.com"}, args...)
+				exec.Command("git", args...).Run()
+			}
+
+			git("init", "-q")
+			ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("readme"), 0644)
+			git("add", "README.md")
+			git("commit", "-q", "-m", "README.md")
+			git("tag", "base")
+			ioutil.WriteFile(filepath.Join(dir, "web", "app.js"), []byte("app"), 0644)
+			git("add", "web/app.js")
+			git("commit", "-q", "-m", "web/app.js")
+
+			link := dir + "-link"
+			Expect(os.Symlink(dir, link)).To(BeNil())
+			defer os.Remove(link)
+
+			wd, _ := os.Getwd()
+			defer os.Chdir(wd)
+			os.Chdir(link)
+			defer os.Setenv("PWD", os.Getenv("PWD"))
+			os.Setenv("PWD", link)
+
+			read("team: someteam\nprojects:\n" +
+				"  - name: api\n    project: api-project\n    path: " + filepath.Join(link, "services", "api") + "\n" +
+				"  - name: web\n    project: web-project\n    paths:\n      - web/**/*.js\n")
+			changedSince = "base"
+
+			targets, err := analysisTargets()
+			Expect(err).To(BeNil())
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].Name).To(Equal("web"))
+		})
+	})
+
+	g.Describe("Analyze Command", func() {
+		var dir string
+		var server *clienttest.Server
+		var logs *bytes.Buffer
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-analyze")
+			ioutil.WriteFile(filepath.Join(dir, "coverage.txt"), []byte("87.5\n"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "vulns.json"), []byte(`{"external_vulnerability":{"critical":1}}`), 0644)
+
+			server = clienttest.NewServer()
+			server.Key = "supersecretapikey"
+			server.Statuses = []string{"finished"}
+
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewReader([]byte("team: team-1\nprojects:\n" +
+				"  - name: api\n    project: api-project\n    path: " + dir + "\n    coverage: " + filepath.Join(dir, "coverage.txt") + "\n    vulnerabilities: " + filepath.Join(dir, "vulns.json") + "\n" +
+				"  - name: web\n    project: web-project\n    path: " + dir + "\n")))
+			viper.Set("api", server.URL)
+			viper.Set("key", "supersecretapikey")
+			viper.Set("retry.backoff", time.Millisecond)
+
+			output = penname.New()
+			logs = &bytes.Buffer{}
+			logger, _ = logging.New(logs, logging.LevelInfo, logging.FormatText)
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+			initLogging()
+			analyzeAll = false
+			projectName = ""
+			dryRun = false
+			recordDir = ""
+			replayDir = ""
+			transport = nil
+			viper.Reset()
+		})
+
+		written := func() string {
+			return string(output.(*penname.PenName).Written())
+		}
+
+		g.It("should analyze the project and add its reports", func() {
+			projectName = "api"
+			Expect(runAnalyze()).To(Equal(0))
+
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(server.Analyses()[0].ProjectID).To(Equal("api-project"))
+			Expect(server.Scans()).To(HaveLen(2))
+			for _, s := range server.Scans() {
+				if s.Type == "coverage" {
+					Expect(s.Results.Coverage.Value).To(Equal(87.5))
+				} else {
+					Expect(s.Type).To(Equal("vulnerability"))
+				}
+			}
+			Expect(written()).To(MatchRegexp(`^SCAN +FILE +RESULT\ncoverage +\S+ +accepted\nvulnerability +\S+ +accepted\n`))
+			Expect(written()).To(HaveSuffix("\nCoverage...Rule Type: coverage...passed...Risk:  low\nAnalysis passed all rules\n"))
+			Expect(logs.String()).To(ContainSubstring("INFO  Found coverage 87.5 in " + filepath.Join(dir, "coverage.txt") + " phase=read project=api-project\n"))
+		})
+
+		g.It("should exit with the code for how the analysis went", func() {
+			projectName = "web"
+			server.Rules[0].Passed = false
+			Expect(runAnalyze()).To(Equal(exitFailed))
+
+			dryRun = true
+			Expect(runAnalyze()).To(Equal(0))
+
+			server.Statuses = []string{"errored"}
+			server.Message = "scanner crashed"
+			Expect(runAnalyze()).To(Equal(exitAnalysis))
+			Expect(logs.String()).To(ContainSubstring("Final analysis status: scanner crashed"))
+
+			server.Statuses = []string{"finished"}
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusInternalServerError)
+			Expect(runAnalyze()).To(Equal(0))
+			Expect(logs.String()).To(ContainSubstring("Retrying analysis request"))
+
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+			Expect(runAnalyze()).To(Equal(exitAPI))
+
+			viper.Set("key", "someotherkey")
+			Expect(runAnalyze()).To(Equal(exitAPI))
+		})
+
+		g.It("should replay a recorded run without the API", func() {
+			projectName = "api"
+			server.Rules[0].Passed = false
+			recordDir = filepath.Join(dir, "recording")
+			Expect(runAnalyze()).To(Equal(exitFailed))
+			recorded := written()
+			server.Close()
+
+			files, _ := filepath.Glob(filepath.Join(recordDir, "*.json"))
+			Expect(files).To(HaveLen(4))
+			for _, f := range files {
+				b, _ := ioutil.ReadFile(f)
+				Expect(string(b)).NotTo(ContainSubstring("supersecretapikey"))
+			}
+
+			recordDir = ""
+			replayDir = filepath.Join(dir, "recording")
+			transport = nil
+			output = penname.New()
+			Expect(runAnalyze()).To(Equal(exitFailed))
+			Expect(written()).To(Equal(recorded))
+
+			output = penname.New()
+			Expect(runAnalyze()).To(Equal(exitAPI))
+			Expect(logs.String()).To(ContainSubstring("no recorded response for POST " + server.URL + "/v1/scanner/analyzeProject"))
+
+			recordDir = filepath.Join(dir, "recording")
+			transport = nil
+			Expect(runAnalyze()).To(Equal(exitInput))
+			Expect(logs.String()).To(ContainSubstring("--record and --replay can not be used together"))
+		})
+
+		g.It("should fail on the local policy", func() {
+			projectName = "api"
+			viper.Set("policy.rules", []map[string]interface{}{{"value": "coverage", "min": 90}})
+			defer func() { async = false }()
+
+			async = true
+			Expect(runAnalyze()).To(Equal(exitFailed))
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(written()).To(ContainSubstring("coverage >= 90 (coverage=87.5)...Rule Type: local policy...not passed"))
+
+			viper.Set("policy.fail_fast", true)
+			Expect(runAnalyze()).To(Equal(exitFailed))
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(logs.String()).To(ContainSubstring("Not requesting an analysis of api-project as the local policy failed"))
+
+			viper.Set("policy.rules", []map[string]interface{}{{"expr": "coverage >="}})
+			Expect(runAnalyze()).To(Equal(exitInput))
+			Expect(logs.String()).To(ContainSubstring("Failed to read the local policy: policy rule 1: unexpected end of expression"))
+		})
+
+		g.It("should fail when the coverage drops too far", func() {
+			projectName = "api"
+			viper.Set("coverage_trend.max_drop", 1)
+
+			Expect(runAnalyze()).To(Equal(0))
+			Expect(written()).To(ContainSubstring("Coverage drops by at most 1 (skipped, coverage.delta unavailable)...Rule Type: local policy...passed"))
+
+			ioutil.WriteFile(filepath.Join(dir, "coverage.txt"), []byte("80\n"), 0644)
+			Expect(runAnalyze()).To(Equal(exitFailed))
+			Expect(written()).To(ContainSubstring("Coverage 80 is down 7.5 from 87.5 in analysis analysis-1 on the default branch"))
+			Expect(written()).To(ContainSubstring("Coverage drops by at most 1 (coverage.delta=-7.5)...Rule Type: local policy...not passed"))
+		})
+
+		g.It("should only validate the reports when offline", func() {
+			projectName = "api"
+			offline = true
+			defer func() { offline = false }()
+			viper.Set("key", "")
+
+			Expect(runAnalyze()).To(Equal(0))
+			Expect(server.Requests()).To(BeEmpty())
+			Expect(written()).To(HaveSuffix("2 of 2 reports are valid\n"))
+		})
+
+		g.It("should analyze every project and summarize them", func() {
+			analyzeAll = true
+			viper.Set("retry.attempts", 1)
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusServiceUnavailable)
+			Expect(runAnalyze()).To(Equal(exitAPI))
+
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(written()).To(ContainSubstring("(503)"))
+			Expect(written()).To(HaveSuffix("1 of 2 analyses passed\n"))
+		})
+	})
+
+	g.Describe("Analysis Results", func() {
+		g.It("should print each report and a summary", func() {
+			evaluation := func(passed bool) *rulesets.AppliedRulesetSummary {
+				return &rulesets.AppliedRulesetSummary{RuleEvaluationSummary: &rulesets.RuleEvaluationSummary{Passed: passed}}
+			}
+
+			results := []*analysisResult{
+				{Target: &analysisTarget{Name: "api"}, Result: &runner.Result{AnalysisID: "analysis-1", Evaluation: evaluation(true)}, output: bytes.NewBufferString("api report\n")},
+				{Target: &analysisTarget{Name: "web"}, Result: &runner.Result{AnalysisID: "analysis-2", Evaluation: evaluation(false)}, output: bytes.NewBufferString("web report\n")},
+				{Target: &analysisTarget{Name: "docs"}, Err: &runner.Error{Kind: runner.ErrAPI, Err: fmt.Errorf("failed to find project")}},
+			}
+
+			buf := &bytes.Buffer{}
+			code := printResults(buf, results)
+			Expect(code).To(Equal(exitAPI))
+
+			dryRun = true
+			code = printResults(&bytes.Buffer{}, results[:2])
+			dryRun = false
+			Expect(code).To(Equal(0))
+
+			out := buf.String()
+			Expect(out).To(ContainSubstring("==> api\napi report\n"))
+			Expect(out).To(ContainSubstring("==> web\nweb report\n"))
+			Expect(out).To(ContainSubstring("Analysis of docs failed: failed to find project"))
+			Expect(out).To(ContainSubstring("PROJECT  ANALYSIS    STATUS\napi      analysis-1  passed\nweb      analysis-2  failed\ndocs                 errored\n"))
+			Expect(out).To(HaveSuffix("1 of 3 analyses passed\n"))
+		})
+	})
+}
@@ -2,24 +2,215 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/ion-channel/ionize/config"
+	"github.com/ion-channel/ionize/redact"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var (
+	showSecrets = false
+)
+
 func init() {
 	RootCmd.AddCommand(configsCmd)
+	configsCmd.AddCommand(configsValidateCmd)
+
+	configsCmd.Flags().BoolVarP(&showSecrets, "show-secrets", "", false, "print the key and other credentials instead of redacting them")
 }
 
 var configsCmd = &cobra.Command{
 	Use:   "configs",
 	Short: "Print the configs in use.",
-	Long:  `Print out the configs and their values that have been loaded into ionize.`,
-	Run:   runConfigsCmd,
+	Long: `Print out the configs and their values that have been loaded into ionize,
+along with the layer that supplied each value.  Layers are applied in the order
+system (/etc/ionize/config.yaml), user (~/.config/ionize/config.yaml), and repo
+(.ionize.yaml in $PWD or its parents, or --config), each preceded by the files
+it includes and followed by its profile when one is selected, then environment
+variables and flags.  Later layers take precedence, and the order they were
+applied in is printed.  The key and other credentials are redacted unless
+--show-secrets is given.`,
+	Run: runConfigsCmd,
 }
 
 func runConfigsCmd(cmd *cobra.Command, args []string) {
-	fmt.Fprintf(output, "Config File: %v\n", viper.ConfigFileUsed())
-	fmt.Fprintf(output, "Secret Key: %v\n", viper.GetString("key"))
-	fmt.Fprintf(output, "API: %v\n", viper.GetString("api"))
+	fmt.Fprintf(output, "Config File: %v\n", strings.Join(configFiles(), ", "))
+	fmt.Fprintf(output, "Secret Key: %v\n", configValue("key", viper.GetString("key")))
+	fmt.Fprintf(output, "API: %v\n", configValue("api", viper.GetString("api")))
+
+	if configs == nil {
+		return
+	}
+
+	if configs.Profile != "" {
+		fmt.Fprintf(output, "Profile: %v\n", configs.Profile)
+	}
+
+	fmt.Fprintln(output, "Precedence, lowest first:")
+	for _, l := range configPrecedence() {
+		fmt.Fprintf(output, "  %v\n", l)
+	}
+
+	fmt.Fprintln(output)
+	w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, k := range configKeys() {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", k, configValue(k, viper.Get(k)), configSource(cmd, k))
+	}
+	w.Flush()
+}
+
+var configsValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configs for mistakes.",
+	Long: `Check every config layer, including the profiles they define, for unknown
+keys, values of the wrong type, malformed UUIDs, and files that do not exist,
+and check that the team and project are set.  Exits non zero when any problem
+is found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !validateConfigs() {
+			os.Exit(1)
+		}
+	},
+}
+
+// validateConfigs prints the problems found with the configs, or that they
+// are valid, and reports whether they were
+func validateConfigs() bool {
+	problems := configProblems()
+	for _, p := range problems {
+		fmt.Fprintf(output, "%v\n", p)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(output, "Found %v config problem(s)\n", len(problems))
+		return false
+	}
+
+	fmt.Fprintln(output, "Configs are valid")
+	return true
+}
+
+// configProblems describes every problem with the configs, starting with why
+// they failed to load if they did
+func configProblems() []string {
+	if configErr != nil {
+		return []string{configErr.Error()}
+	}
+
+	if configs == nil {
+		return []string{}
+	}
+
+	problems := []string{}
+	for _, p := range configs.Validate() {
+		problems = append(problems, p.String())
+	}
+
+	return problems
+}
+
+// configValue formats the value of the key for printing, masking it when the
+// key is a secret and removing any credentials it holds, unless secrets are
+// to be shown
+func configValue(k string, v interface{}) string {
+	s := fmt.Sprintf("%v", v)
+	if showSecrets {
+		return s
+	}
+
+	for _, secret := range config.Secrets() {
+		if k == secret {
+			return redact.Value(s)
+		}
+	}
+
+	return redact.String(s)
+}
+
+// configPrecedence describes the sources of values in the order they are
+// applied, each taking precedence over those before it
+func configPrecedence() []string {
+	sources := []string{"default"}
+	if configs != nil {
+		for _, l := range configs.Layers {
+			sources = append(sources, l.String())
+		}
+	}
+
+	names := []string{}
+	for _, env := range envs {
+		names = append(names, env)
+	}
+	sort.Strings(names)
+	sources = append(sources, "env "+strings.Join(names, ", "))
+
+	names = []string{}
+	for _, name := range flagKeys {
+		names = append(names, "--"+name)
+	}
+	sort.Strings(names)
+
+	return append(sources, "flags "+strings.Join(names, ", "))
+}
+
+func configFiles() []string {
+	if configs == nil {
+		return []string{}
+	}
+
+	return configs.Files()
+}
+
+// configKeys returns every key with a value from a config layer, the
+// environment, or a default
+func configKeys() []string {
+	set := map[string]bool{}
+	for _, k := range viper.AllKeys() {
+		set[k] = true
+	}
+	if configs != nil {
+		for _, k := range configs.Keys() {
+			set[k] = true
+		}
+	}
+
+	keys := []string{}
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// configSource describes where the value of the key came from, following the
+// same precedence viper applies, with the flags given to the command first
+func configSource(cmd *cobra.Command, k string) string {
+	if name, ok := flagKeys[k]; ok && cmd != nil {
+		if f := cmd.Flag(name); f != nil && f.Changed {
+			return "flag --" + name
+		}
+	}
+
+	if env, ok := envs[k]; ok && os.Getenv(env) != "" {
+		return "env " + env
+	}
+
+	if k == "key" && keySource != "" {
+		return keySource
+	}
+
+	if configs != nil {
+		if l, ok := configs.Sources[k]; ok {
+			return l.String()
+		}
+	}
+
+	return "default"
 }
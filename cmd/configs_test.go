@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
 
 	"github.com/franela/goblin"
 	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionize/config"
+	"github.com/ion-channel/ionize/logging"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
 )
 
 func TestFunctionality(t *testing.T) {
@@ -22,5 +30,180 @@ func TestFunctionality(t *testing.T) {
 			runConfigsCmd(nil, nil)
 			Expect(string(mw.Written())).To(Equal("Config File: \nSecret Key: \nAPI: \n"))
 		})
+
+		g.It("should output the layer that supplied each config", func() {
+			dir, _ := ioutil.TempDir("", "ionize-configs")
+			defer os.RemoveAll(dir)
+
+			repo := filepath.Join(dir, ".ionize.yaml")
+			ioutil.WriteFile(repo, []byte("team: someteam\nprofiles:\n  test:\n    api: https://api.test.ionchannel.io\n"), 0644)
+
+			os.Setenv("XDG_CONFIG_HOME", dir)
+			defer os.Unsetenv("XDG_CONFIG_HOME")
+
+			mw := penname.New()
+			output = mw
+			cfgFile = repo
+			profileName = "test"
+			defer func() {
+				cfgFile = ""
+				profileName = ""
+				configs = nil
+				viper.Reset()
+			}()
+
+			initDefaults()
+			initConfig()
+			runConfigsCmd(nil, nil)
+
+			out := string(mw.Written())
+			Expect(out).To(HavePrefix("Config File: " + repo + "\nSecret Key: \nAPI: https://api.test.ionchannel.io\nProfile: test\n"))
+			Expect(out).To(MatchRegexp(`api\s+https://api.test.ionchannel.io\s+repo profile test \(` + regexp.QuoteMeta(repo) + `\)\n`))
+			Expect(out).To(MatchRegexp(`bucket\s+dropbox.ionchannel.io\s+default\n`))
+			Expect(out).To(MatchRegexp(`team\s+someteam\s+repo \(` + regexp.QuoteMeta(repo) + `\)\n`))
+			Expect(out).To(ContainSubstring("Precedence, lowest first:\n  default\n  repo (" + repo + ")\n  repo profile test (" + repo + ")\n  env IONCHANNEL_DROP_BUCKET, IONCHANNEL_ENDPOINT_URL, IONCHANNEL_SECRET_KEY\n" +
+				"  flags --fail-fast, --insecure-skip-verify, --retries, --retry-backoff\n"))
+		})
+
+		g.It("should report the values given with flags as coming from them", func() {
+			os.Setenv(envs["api"], "https://api.env.example")
+			defer os.Unsetenv(envs["api"])
+			Expect(configSource(configsCmd, "retry.attempts")).To(Equal("default"))
+
+			f := RootCmd.PersistentFlags().Lookup("retries")
+			RootCmd.PersistentFlags().Set("retries", "5")
+			defer func() {
+				f.Value.Set(f.DefValue)
+				f.Changed = false
+			}()
+
+			Expect(configSource(configsCmd, "retry.attempts")).To(Equal("flag --retries"))
+			Expect(configSource(configsCmd, "retry.backoff")).To(Equal("default"))
+			Expect(configSource(configsCmd, "api")).To(Equal("env " + envs["api"]))
+		})
+
+		g.It("should show the files included and their precedence", func() {
+			dir, _ := ioutil.TempDir("", "ionize-configs")
+			defer os.RemoveAll(dir)
+
+			org := filepath.Join(dir, "org.yaml")
+			ioutil.WriteFile(org, []byte("bucket: ${IONIZE_TEST_BUCKET:-org-bucket}\nteam: org-team\n"), 0644)
+			repo := filepath.Join(dir, ".ionize.yaml")
+			ioutil.WriteFile(repo, []byte("include: org.yaml\nteam: someteam\n"), 0644)
+
+			os.Setenv("XDG_CONFIG_HOME", dir)
+			defer os.Unsetenv("XDG_CONFIG_HOME")
+
+			mw := penname.New()
+			output = mw
+			cfgFile = repo
+			defer func() {
+				cfgFile = ""
+				configs = nil
+				viper.Reset()
+			}()
+
+			initDefaults()
+			initConfig()
+			runConfigsCmd(nil, nil)
+
+			out := string(mw.Written())
+			Expect(out).To(ContainSubstring("  default\n  repo include (" + org + ")\n  repo (" + repo + ")\n"))
+			Expect(out).To(MatchRegexp(`bucket\s+org-bucket\s+repo include \(` + regexp.QuoteMeta(org) + `\)\n`))
+			Expect(out).To(MatchRegexp(`team\s+someteam\s+repo \(` + regexp.QuoteMeta(repo) + `\)\n`))
+		})
+
+		g.It("should report the problems with the configs", func() {
+			dir, _ := ioutil.TempDir("", "ionize-configs")
+			defer os.RemoveAll(dir)
+
+			repo := filepath.Join(dir, ".ionize.yaml")
+			ioutil.WriteFile(repo, []byte("team: someteam\nprojcet: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\n"), 0644)
+
+			os.Setenv("XDG_CONFIG_HOME", dir)
+			defer os.Unsetenv("XDG_CONFIG_HOME")
+
+			mw := penname.New()
+			output = mw
+			cfgFile = repo
+			defer func() {
+				cfgFile = ""
+				configs = nil
+				viper.Reset()
+			}()
+
+			initConfig()
+			Expect(validateConfigs()).To(BeFalse())
+
+			source := "repo (" + repo + "): "
+			Expect(string(mw.Written())).To(Equal(source + "projcet: is not a known key, did you mean project?\n" +
+				source + "team: \"someteam\" is not a valid UUID\n" +
+				"project: is required but not set\n" +
+				"Found 3 config problem(s)\n"))
+		})
+
+		g.It("should report when the configs fail to load", func() {
+			mw := penname.New()
+			output = mw
+			cfgFile = "missing.yaml"
+			defer func() {
+				cfgFile = ""
+				configErr = nil
+			}()
+
+			initConfig()
+			Expect(validateConfigs()).To(BeFalse())
+			Expect(string(mw.Written())).To(ContainSubstring("Found 1 config problem(s)\n"))
+			Expect(configCode(configsValidateCmd)).To(Equal(0))
+			Expect(configs).To(BeNil())
+		})
+
+		g.It("should stop other commands when the configs fail to load", func() {
+			logs := &bytes.Buffer{}
+			logger, _ = logging.New(logs, logging.LevelInfo, logging.FormatText)
+			cfgFile = "missing.yaml"
+			defer func() {
+				cfgFile = ""
+				configErr = nil
+				initLogging()
+			}()
+
+			initConfig()
+			Expect(configCode(analyzeCmd)).To(Equal(exitInput))
+			Expect(logs.String()).To(ContainSubstring("Failed reading config: "))
+
+			cfgFile = ""
+			initConfig()
+			Expect(configCode(analyzeCmd)).To(Equal(0))
+		})
+
+		g.It("should redact secrets unless asked to show them", func() {
+			viper.Set("key", "0123456789abcdefWXYZ")
+			viper.Set("dropbox.token", "droptoken")
+			configs = &config.Config{Values: map[string]interface{}{}}
+			defer func() {
+				showSecrets = false
+				configs = nil
+				viper.Reset()
+			}()
+
+			mw := penname.New()
+			output = mw
+			runConfigsCmd(nil, nil)
+
+			out := string(mw.Written())
+			Expect(out).To(ContainSubstring("Secret Key: ********WXYZ\n"))
+			Expect(out).To(MatchRegexp(`dropbox.token\s+\*{8}\s+default\n`))
+			Expect(out).NotTo(ContainSubstring("droptoken"))
+
+			mw = penname.New()
+			output = mw
+			showSecrets = true
+			runConfigsCmd(nil, nil)
+
+			out = string(mw.Written())
+			Expect(out).To(ContainSubstring("Secret Key: 0123456789abcdefWXYZ\n"))
+			Expect(out).To(MatchRegexp(`dropbox.token\s+droptoken\s+default\n`))
+		})
 	})
 }
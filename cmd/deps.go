@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ion-channel/ionize/runner"
+	"github.com/spf13/cobra"
+)
+
+// The formats dependencies are printed in
+const (
+	depsTable = "table"
+	depsJSON  = "json"
+)
+
+var (
+	depsEcosystem = ""
+	depsOutput    = depsTable
+)
+
+func init() {
+	RootCmd.AddCommand(depsCmd)
+	depsCmd.AddCommand(depsResolveCmd)
+	depsCmd.AddCommand(depsVersionsCmd)
+
+	depsCmd.PersistentFlags().StringVarP(&depsEcosystem, "ecosystem", "", "", "the ecosystem of the dependencies, such as npm, pypi, or ruby")
+	depsCmd.PersistentFlags().StringVarP(&depsOutput, "output", "o", depsTable, "print a table, or json")
+}
+
+var depsCmd = &cobra.Command{
+	Use:   "deps",
+	Short: "Work with the dependencies of manifests and packages.",
+	Long: `Resolve the dependency trees of manifests, and find the versions of packages,
+with the Ion Channel API.`,
+}
+
+var depsResolveCmd = &cobra.Command{
+	Use:   "resolve manifest",
+	Short: "Print the dependency tree of a manifest.",
+	Long: `Resolve the dependencies of a go.mod, package.json, package-lock.json,
+requirements.txt, Gemfile.lock, pom.xml, or Cargo.lock and print them as a
+tree, each with its latest version, followed by how many are first degree and
+how many have an update available.  The ecosystem is detected from the name of
+the manifest unless --ecosystem is given.  With --output json, the tree and
+its counts are printed as JSON instead.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runDepsResolve(args[0]))
+	},
+}
+
+var depsVersionsCmd = &cobra.Command{
+	Use:   "versions package",
+	Short: "Print the known versions of a package.",
+	Long: `Print every known version of the package in the --ecosystem, marking the
+latest.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runDepsVersions(args[0]))
+	},
+}
+
+// runDepsResolve prints the dependency tree of the manifest, and returns the
+// code to exit with
+func runDepsResolve(file string) int {
+	err := checkDepsOutput()
+	if err != nil {
+		return failed(err)
+	}
+
+	r, err := newRunner()
+	if err != nil {
+		return failed(err)
+	}
+
+	res, err := r.ResolveDependencies(context.Background(), &runner.ResolveOptions{File: file, Ecosystem: depsEcosystem})
+	if err != nil {
+		return failed(err)
+	}
+
+	if depsOutput == depsJSON {
+		return printDepsJSON(res)
+	}
+
+	runner.PrintDependencies(output, res)
+	return 0
+}
+
+// runDepsVersions prints the versions of the package, and returns the code to
+// exit with
+func runDepsVersions(name string) int {
+	err := checkDepsOutput()
+	if err != nil {
+		return failed(err)
+	}
+
+	r, err := newRunner()
+	if err != nil {
+		return failed(err)
+	}
+
+	v, err := r.DependencyVersions(name, depsEcosystem)
+	if err != nil {
+		return failed(err)
+	}
+
+	if depsOutput == depsJSON {
+		return printDepsJSON(v)
+	}
+
+	runner.PrintVersions(output, v)
+	return 0
+}
+
+func checkDepsOutput() error {
+	if depsOutput != depsTable && depsOutput != depsJSON {
+		return &runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Unknown output %q, use %v or %v", depsOutput, depsTable, depsJSON)}
+	}
+
+	return nil
+}
+
+func printDepsJSON(v interface{}) int {
+	err := runner.PrintJSON(output, v)
+	if err != nil {
+		return failed(err)
+	}
+
+	return 0
+}
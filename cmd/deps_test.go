@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionic/dependencies"
+	"github.com/ion-channel/ionize/client/clienttest"
+	"github.com/ion-channel/ionize/logging"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestDeps(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Deps Command", func() {
+		var dir string
+		var server *clienttest.Server
+		var manifest string
+		var logs *bytes.Buffer
+
+		written := func() string {
+			return string(output.(*penname.PenName).Written())
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-deps")
+			manifest = filepath.Join(dir, "go.mod")
+			ioutil.WriteFile(manifest, []byte("module example.com/widget\n"), 0644)
+
+			server = clienttest.NewServer()
+			server.Dependencies = []dependencies.Dependency{
+				{Name: "github.com/spf13/cobra", Version: "v1.0.0", LatestVersion: "v1.1.1", Dependencies: []dependencies.Dependency{
+					{Name: "github.com/spf13/pflag", Version: "v1.0.5", LatestVersion: "v1.0.5"},
+				}},
+			}
+			server.Versions = map[string][]string{"github.com/spf13/cobra": {"v1.0.0", "v1.1.1"}}
+
+			viper.Set("api", server.URL)
+			viper.Set("key", "supersecretapikey")
+
+			output = penname.New()
+			logs = &bytes.Buffer{}
+			logger, _ = logging.New(logs, logging.LevelInfo, logging.FormatText)
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+			initLogging()
+			viper.Reset()
+			depsEcosystem = ""
+			depsOutput = depsTable
+		})
+
+		g.It("should print the dependency tree of the manifest", func() {
+			Expect(runDepsResolve(manifest)).To(Equal(0))
+			Expect(server.Manifests()[0].Ecosystem).To(Equal("golang"))
+			Expect(written()).To(Equal("" +
+				"DEPENDENCY                VERSION  LATEST\n" +
+				"github.com/spf13/cobra    v1.0.0   v1.1.1 (update available)\n" +
+				"  github.com/spf13/pflag  v1.0.5   v1.0.5\n" +
+				"1 first degree and 2 unique dependencies, 1 with an update available\n"))
+		})
+
+		g.It("should print the dependency tree as json", func() {
+			depsOutput = "json"
+			Expect(runDepsResolve(manifest)).To(Equal(0))
+
+			var res struct {
+				Ecosystem    string                    `json:"ecosystem"`
+				Dependencies []dependencies.Dependency `json:"dependencies"`
+				Meta         dependencies.Meta         `json:"meta"`
+			}
+			Expect(json.Unmarshal(output.(*penname.PenName).Written(), &res)).To(BeNil())
+			Expect(res.Ecosystem).To(Equal("golang"))
+			Expect(res.Dependencies[0].Dependencies[0].Name).To(Equal("github.com/spf13/pflag"))
+			Expect(res.Meta).To(Equal(dependencies.Meta{FirstDegreeCount: 1, TotalUniqueCount: 2, UpdateAvailableCount: 1}))
+		})
+
+		g.It("should print the versions of a package", func() {
+			depsEcosystem = "golang"
+			Expect(runDepsVersions("github.com/spf13/cobra")).To(Equal(0))
+			Expect(written()).To(Equal("v1.0.0\nv1.1.1 (latest)\n"))
+		})
+
+		g.It("should exit with the code for what went wrong", func() {
+			Expect(runDepsResolve(filepath.Join(dir, "deps.txt"))).To(Equal(exitInput))
+			Expect(logs.String()).To(ContainSubstring("can not tell the ecosystem of deps.txt"))
+
+			Expect(runDepsVersions("github.com/spf13/cobra")).To(Equal(exitInput))
+
+			depsEcosystem = "golang"
+			Expect(runDepsVersions("github.com/spf13/viper")).To(Equal(exitAPI))
+
+			depsOutput = "yaml"
+			Expect(runDepsResolve(manifest)).To(Equal(exitInput))
+			Expect(logs.String()).To(ContainSubstring(`Unknown output "yaml", use table or json`))
+			Expect(server.Manifests()).To(BeEmpty())
+		})
+	})
+}
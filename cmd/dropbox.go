@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ion-channel/ionize/client"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	cleanupRetention time.Duration
+	cleanupDryRun    = false
+)
+
+func init() {
+	RootCmd.AddCommand(dropboxCmd)
+	dropboxCmd.AddCommand(dropboxCleanupCmd)
+
+	dropboxCleanupCmd.Flags().DurationVarP(&cleanupRetention, "retention", "", 0, "remove uploads older than this regardless of their tags (default is the dropbox retention config)")
+	dropboxCleanupCmd.Flags().BoolVarP(&cleanupDryRun, "dry-run", "", false, "list the uploads that would be removed without removing them")
+}
+
+var dropboxCmd = &cobra.Command{
+	Use:   "dropbox",
+	Short: "Manage the artifacts ionize has uploaded",
+	Long:  `Manage the artifacts ionize has uploaded to the dropbox for analysis.`,
+}
+
+// dropboxCleanupCmd removes uploads that are no longer needed
+var dropboxCleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove uploaded artifacts that are no longer needed",
+	Long: `Remove uploaded artifacts that are no longer needed. For example:
+
+ionize dropbox cleanup --retention 720h
+
+Will remove every artifact ionize uploaded that has passed its expiry, is older
+than the retention, or whose analysis has finished or no longer exists.  An
+artifact whose analysis can not be checked is skipped with a warning and left
+for the next cleanup.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := loadKey()
+		if err != nil {
+			os.Exit(failed(err))
+		}
+
+		key := viper.GetString("key")
+		cli, err := newClient()
+		if err != nil {
+			fatalf("Failed to create Ion Channel Client: %v", err.Error())
+		}
+
+		cfg, err := loadDropboxConfig()
+		if err != nil {
+			fatalf("Failed to load dropbox config: %v", err.Error())
+		}
+
+		store, err := dropbox.NewStore(cfg)
+		if err != nil {
+			fatalf("Failed to create dropbox store: %v", err.Error())
+		}
+
+		lc, ok := store.(dropbox.Lifecycle)
+		if !ok {
+			fatalf("The %v dropbox backend does not support cleanup", cfg.Backend)
+		}
+
+		retention := cleanupRetention
+		if retention == 0 {
+			retention = cfg.Retention
+		}
+
+		removed, err := dropbox.Cleanup(lc, dropbox.CleanupOptions{
+			Retention: retention,
+			DryRun:    cleanupDryRun,
+			Finished: func(tags map[string]string) (bool, error) {
+				status, err := cli.GetAnalysisStatus(tags[dropbox.AnalysisTag], tags[dropbox.TeamTag], tags[dropbox.ProjectTag], key)
+				if client.NotFound(err) {
+					return true, nil
+				}
+				if err != nil {
+					return false, err
+				}
+				return status.Done(), nil
+			},
+			Logf: logger.Warnf,
+		})
+		for _, k := range removed {
+			if cleanupDryRun {
+				fmt.Fprintf(output, "Would remove %v\n", k)
+			} else {
+				fmt.Fprintf(output, "Removed %v\n", k)
+			}
+		}
+		if err != nil {
+			fatalf("Failed to clean up dropbox: %v", err.Error())
+		}
+	},
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/runner"
+	"github.com/spf13/viper"
+)
+
+// The codes ionize exits with
+const (
+	// exitFailed is for an analysis that failed a rule, and any error not
+	// covered by another code
+	exitFailed = 1
+	// exitInput is for configs, flags, or reports that can not be used
+	exitInput = 2
+	// exitAPI is for requests to the Ion Channel API or the dropbox that
+	// failed
+	exitAPI = 3
+	// exitAnalysis is for an analysis that errored
+	exitAnalysis = 4
+)
+
+// exitCode returns the code to exit with for the error
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, runner.ErrInput):
+		return exitInput
+	case errors.Is(err, runner.ErrAPI), errors.Is(err, runner.ErrUpload):
+		return exitAPI
+	case errors.Is(err, runner.ErrAnalysis):
+		return exitAnalysis
+	}
+
+	return exitFailed
+}
+
+// resultCode returns the code to exit with for the result of an analysis,
+// which fails when a rule did unless it was a dry run or not waited for.  A
+// local policy rule fails it even when it was not waited for.
+func resultCode(res *runner.Result, err error) int {
+	if err != nil {
+		return exitCode(err)
+	}
+
+	if dryRun || res.Passed() || async && !policyFailed(res) {
+		return 0
+	}
+
+	return exitFailed
+}
+
+// policyFailed reports whether the external reports of the analysis failed
+// the local policy
+func policyFailed(res *runner.Result) bool {
+	return res != nil && res.Policy != nil && !res.Policy.Passed
+}
+
+// failed logs the error and returns the code to exit with for it
+func failed(err error) int {
+	logger.Errorf("%v", err)
+	return exitCode(err)
+}
+
+// fatalf logs the error and exits
+func fatalf(format string, v ...interface{}) {
+	logger.Errorf(format, v...)
+	os.Exit(exitFailed)
+}
+
+// newRunner creates a runner for the configured API and key, uploading to the
+// configured dropbox
+func newRunner() (*runner.Runner, error) {
+	err := loadKey()
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := newClient()
+	if err != nil {
+		return nil, &runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Failed to create Ion Channel Client: %v", err.Error())}
+	}
+
+	r := runner.New(cli, viper.GetString("key"), output)
+	r.Log = logger
+	r.NewUploader = func() (*dropbox.Uploader, error) {
+		cfg, err := loadDropboxConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load dropbox config: %v", err.Error())
+		}
+
+		u, err := dropbox.NewUploader(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create dropbox uploader: %v", err.Error())
+		}
+
+		return u, nil
+	}
+
+	return r, nil
+}
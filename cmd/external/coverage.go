@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/ion-channel/ionic"
 	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client"
 )
 
 // ParseCoverage - takes the path the the file containing
@@ -27,9 +27,7 @@ type Coverage struct {
 }
 
 //Save persists the code coverage external scan data
-func (c *Coverage) Save(aID *AnalysisID, cli *ionic.IonClient) (*scanner.AnalysisStatus, error) {
-	fmt.Println("Adding external coverage scan data")
-
+func (c *Coverage) Save(aID *AnalysisID, cli client.ScanAdder) (*scanner.AnalysisStatus, error) {
 	scan := scanner.ExternalScan{}
 	scan.Coverage = c.Value
 	analysisStatus, err := cli.AddScanResult(aID.ID, aID.TeamID, aID.ProjectID, "accepted", "coverage", aID.APIKey, scan)
@@ -41,7 +39,6 @@ func (c *Coverage) Save(aID *AnalysisID, cli *ionic.IonClient) (*scanner.Analysi
 
 func loadCoverage(path string) (*scanner.ExternalCoverage, error) {
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		fmt.Println("Reading coverage value from", path)
 		var value float64
 		f, err := os.Open(path)
 		defer f.Close()
@@ -53,7 +50,6 @@ func loadCoverage(path string) (*scanner.ExternalCoverage, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Could read coverage from coverage file %v", err.Error())
 		}
-		fmt.Println("Found coverage", value)
 		return &scanner.ExternalCoverage{Value: value}, nil
 	}
 	return nil, fmt.Errorf("File does not exist %s", path)
@@ -0,0 +1,116 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxDetectSize is the largest json file read when looking for
+	// vulnerability reports
+	maxDetectSize = 10 * 1024 * 1024
+)
+
+// skipDirs are never searched for reports
+var skipDirs = map[string]bool{
+	"vendor":       true,
+	"node_modules": true,
+}
+
+//Reports lists the external report files found in a directory, relative to
+//it
+type Reports struct {
+	Coverage        string
+	Vulnerabilities []string
+	Fortify         string
+}
+
+//Detect searches the directory for coverage values, vulnerability reports,
+//and Fortify FPR files that ionize can add to an analysis.  Hidden, vendor,
+//and node_modules directories are skipped, and the first coverage value and
+//FPR file found are used.
+func Detect(root string) (*Reports, error) {
+	r := &Reports{Vulnerabilities: []string{}}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := strings.ToLower(info.Name())
+		if info.IsDir() {
+			if path != root && (strings.HasPrefix(name, ".") || skipDirs[name]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case filepath.Ext(name) == ".fpr":
+			if r.Fortify == "" {
+				r.Fortify = rel
+			}
+		case name == "coverage.txt" || name == "coverage":
+			if r.Coverage == "" && isCoverage(path) {
+				r.Coverage = rel
+			}
+		case filepath.Ext(name) == ".json" && info.Size() <= maxDetectSize:
+			if isVulnerabilities(path) {
+				r.Vulnerabilities = append(r.Vulnerabilities, rel)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for reports: %v", err.Error())
+	}
+
+	return r, nil
+}
+
+// isCoverage reports whether the file starts with a coverage value
+func isCoverage(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		return false
+	}
+
+	_, err = strconv.ParseFloat(strings.TrimSpace(s.Text()), 64)
+	return err == nil
+}
+
+// isVulnerabilities reports whether the file is an external vulnerability
+// report
+func isVulnerabilities(path string) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var report map[string]json.RawMessage
+	if json.Unmarshal(b, &report) != nil {
+		return false
+	}
+
+	_, ok := report["external_vulnerability"]
+	return ok
+}
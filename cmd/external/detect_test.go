@@ -0,0 +1,57 @@
+package external
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestDetect(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Detecting reports", func() {
+		var dir string
+
+		write := func(name, contents string) {
+			path := filepath.Join(dir, name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			ioutil.WriteFile(path, []byte(contents), 0644)
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-detect")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should find the reports ionize can add", func() {
+			write("coverage.txt", "not a number\n")
+			write("build/coverage.txt", "92.1\n")
+			write("reports/a.json", `{"external_vulnerability":{"high":2}}`)
+			write("reports/b.json", `{"external_vulnerability":{"low":1}}`)
+			write("reports/c.json", `["not", "a", "report"]`)
+			write("target/audit.fpr", "fpr")
+			write("vendor/github.com/x/y/coverage.txt", "10\n")
+			write(".cache/other.fpr", "fpr")
+
+			r, err := Detect(dir)
+			Expect(err).To(BeNil())
+			Expect(r.Coverage).To(Equal("build/coverage.txt"))
+			Expect(r.Vulnerabilities).To(Equal([]string{"reports/a.json", "reports/b.json"}))
+			Expect(r.Fortify).To(Equal("target/audit.fpr"))
+		})
+
+		g.It("should find nothing in an empty directory", func() {
+			r, err := Detect(dir)
+			Expect(err).To(BeNil())
+			Expect(r).To(Equal(&Reports{Vulnerabilities: []string{}}))
+		})
+	})
+}
@@ -12,41 +12,48 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/ion-channel/ionic"
 	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client"
 	"github.com/ion-channel/ionize/dropbox"
 )
 
-//ParseFortify a Fortify FPR file at the path provided
-func ParseFortify(path string) (*Fortify, error) {
-	dir, err := unzip(path)
+//ParseFortify a Fortify FPR file at the path provided, uploading the FPR with
+//the uploader so it can be referenced by the analysis
+func ParseFortify(path string, uploader *dropbox.Uploader) (*Fortify, error) {
+	f, err := ReadFortify(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unzip, %s: %v", path, err)
+		return nil, err
 	}
 
-	fvdlFile := filepath.Join(dir, "audit.fvdl")
-	b, err := ioutil.ReadFile(fvdlFile) // just pass the file name
+	err = f.Upload(uploader)
 	if err != nil {
 		return nil, err
 	}
 
-	fvdl := FVDL{}
+	return f, nil
+}
 
-	err = xml.Unmarshal(b, &fvdl)
+//ReadFortify reads a Fortify FPR file at the path provided without uploading
+//it, so a malformed file is found before anything is sent
+func ReadFortify(path string) (*Fortify, error) {
+	dir, err := unzip(path)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to unzip, %s: %v", path, err)
 	}
 
-	rando, err := dropbox.Randomizer()
+	fvdlFile := filepath.Join(dir, "audit.fvdl")
+	b, err := ioutil.ReadFile(fvdlFile) // just pass the file name
 	if err != nil {
 		return nil, err
 	}
 
-	erl, err := dropbox.ParseURL(path, rando)
+	fvdl := FVDL{}
+
+	err = xml.Unmarshal(b, &fvdl)
 	if err != nil {
 		return nil, err
 	}
-	raw := json.RawMessage(fmt.Sprintf("{\"fpr\": \"%v\"}", erl))
+
 	ex := scanner.ExternalScan{}
 	ex.Vulnerability = &scanner.ExternalVulnerability{}
 
@@ -77,9 +84,9 @@ func ParseFortify(path string) (*Fortify, error) {
 	ex.Source = scanner.Source{
 		Name: "Fortify",
 	}
-	ex.Raw = &raw
 
 	return &Fortify{
+		Path:  path,
 		FVDL:  &fvdl,
 		Value: &ex,
 	}, nil
@@ -87,14 +94,34 @@ func ParseFortify(path string) (*Fortify, error) {
 
 //Fortify struct container for encapsalating external vulnerability scan data
 type Fortify struct {
+	Path  string
 	FVDL  *FVDL
 	Value *scanner.ExternalScan
 }
 
-//Save sends the external vulnerability scan data to ion channel for persistance
-func (f *Fortify) Save(aID *AnalysisID, cli *ionic.IonClient) (*scanner.AnalysisStatus, error) {
-	fmt.Println("Adding external fortify scan data")
+//Upload uploads the FPR file with the uploader, referencing it and its digest
+//from the scan data so the analysis can retrieve it
+func (f *Fortify) Upload(uploader *dropbox.Uploader) error {
+	artifact, err := uploader.ParseURL(f.Path)
+	if err != nil {
+		return err
+	}
 
+	b, err := json.Marshal(map[string]string{
+		"fpr":    artifact.URL,
+		"sha256": artifact.Digest,
+	})
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(b)
+	f.Value.Raw = &raw
+
+	return nil
+}
+
+//Save sends the external vulnerability scan data to ion channel for persistance
+func (f *Fortify) Save(aID *AnalysisID, cli client.ScanAdder) (*scanner.AnalysisStatus, error) {
 	analysisStatus, err := cli.AddScanResult(aID.ID, aID.TeamID, aID.ProjectID, "accepted", "vulnerability", aID.APIKey, *f.Value)
 	if err != nil {
 		return nil, fmt.Errorf("Analysis vulnerabilities save failed: %v", err.Error())
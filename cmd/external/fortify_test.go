@@ -1,42 +1,34 @@
 package external
 
 import (
-	"net/http"
-	"net/http/httptest"
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/franela/goblin"
 	"github.com/ion-channel/ionize/dropbox"
 	. "github.com/onsi/gomega"
 )
 
-type mockS3Client struct {
-	s3iface.S3API
-}
-
-func (c mockS3Client) PutObjectRequest(input *s3.PutObjectInput) (req *request.Request, output *s3.PutObjectOutput) {
-	out := s3.PutObjectOutput{}
-	req = new(request.Request)
-	r := httptest.NewRequest(http.MethodGet, "/login", nil)
-	req.HTTPRequest = r
-	return req, &out
-}
-
 func TestFortify(t *testing.T) {
 	g := goblin.Goblin(t)
 	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
 
 	g.Describe("FPR file handling", func() {
+		var uploader *dropbox.Uploader
+		var storeDir string
+
 		g.BeforeEach(func() {
-			mc := mockS3Client{}
-			dropbox.Uploader = s3manager.NewUploaderWithClient(mc)
+			storeDir, _ = ioutil.TempDir("", "ionize-dropbox")
+			store, _ := dropbox.NewFileStore(storeDir)
+			uploader = dropbox.NewUploaderWithStore(store)
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(storeDir)
 		})
 
 		g.It("should unzip and fpr file", func() {
@@ -52,7 +44,7 @@ func TestFortify(t *testing.T) {
 
 			path := strings.Join([]string{dir, "fortify.zip"}, "/")
 
-			fort, err := ParseFortify(path)
+			fort, err := ParseFortify(path, uploader)
 			Expect(err).To(BeNil())
 			Expect(fort.Value).NotTo(BeNil())
 			// matches the pdf for the fpr input
@@ -60,6 +52,12 @@ func TestFortify(t *testing.T) {
 			Expect(fort.Value.Vulnerability.High).To(Equal(262))
 			Expect(fort.Value.Vulnerability.Medium).To(Equal(0))
 			Expect(fort.Value.Vulnerability.Low).To(Equal(79))
+			Expect(fort.FVDL.Categories()["Memory Leak"]).To(Equal(156))
+
+			var raw map[string]string
+			Expect(json.Unmarshal(*fort.Value.Raw, &raw)).To(BeNil())
+			Expect(raw["fpr"]).To(HavePrefix("file://" + storeDir))
+			Expect(raw["sha256"]).To(HaveLen(64))
 		})
 
 		g.It("should gather all of the rules", func() {
@@ -67,7 +65,7 @@ func TestFortify(t *testing.T) {
 
 			path := strings.Join([]string{dir, "fortify.zip"}, "/")
 
-			fort, err := ParseFortify(path)
+			fort, err := ParseFortify(path, uploader)
 			Expect(err).To(BeNil())
 
 			rules := fort.FVDL.Rules()
@@ -80,7 +78,7 @@ func TestFortify(t *testing.T) {
 
 			path := strings.Join([]string{dir, "fortify.zip"}, "/")
 
-			fort, _ := ParseFortify(path)
+			fort, _ := ParseFortify(path, uploader)
 
 			value := fort.FVDL.Group("10683D0C-25FA-4984-41CC-651C955D640A", Accuracy)
 			Expect(value).NotTo(Equal(""))
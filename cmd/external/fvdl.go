@@ -389,3 +389,13 @@ func (f *FVDL) Group(ruleID, groupName string) string {
 
 	return value
 }
+
+//Categories returns the number of findings of each category, the type of
+//vulnerability Fortify classed them as, such as SQL Injection
+func (f *FVDL) Categories() map[string]int {
+	categories := make(map[string]int)
+	for _, v := range f.Vulnerabilities.Vulnerability {
+		categories[v.ClassInfo.Type]++
+	}
+	return categories
+}
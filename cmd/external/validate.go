@@ -0,0 +1,181 @@
+package external
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client"
+)
+
+const (
+	//PendingUpload stands in for the dropbox url of an FPR file that was read
+	//without being uploaded
+	PendingUpload = "(uploaded to the dropbox)"
+)
+
+//Report is external scan data read from a file, to be added to an analysis
+type Report interface {
+	//Scan returns the scan data that is sent to the API
+	Scan() scanner.ExternalScan
+	//Problems returns what is wrong with the scan data
+	Problems() []string
+	//Save sends the scan data to the analysis
+	Save(aID *AnalysisID, cli client.ScanAdder) (*scanner.AnalysisStatus, error)
+}
+
+var (
+	_ Report = (*Coverage)(nil)
+	_ Report = (*Vulnerabilities)(nil)
+	_ Report = (*Fortify)(nil)
+)
+
+//knownFields are the fields of an external vulnerability report, by the
+//object holding them
+var knownFields = map[string][]string{
+	"":                       {"coverage", "external_vulnerability", "source", "notes", "raw"},
+	"coverage":               {"value"},
+	"external_vulnerability": {"critical", "high", "medium", "low"},
+	"source":                 {"name", "url"},
+}
+
+//Problems returns what is wrong with the scan data that the API would reject
+//or misread, such as negative counts or coverage outside of 0 to 100
+func Problems(scan *scanner.ExternalScan) []string {
+	problems := []string{}
+
+	if scan.Coverage == nil && scan.Vulnerability == nil {
+		problems = append(problems, "has neither coverage nor external_vulnerability data")
+	}
+
+	if scan.Coverage != nil && (scan.Coverage.Value < 0 || scan.Coverage.Value > 100) {
+		problems = append(problems, fmt.Sprintf("coverage %v is not a percentage from 0 to 100", scan.Coverage.Value))
+	}
+
+	if v := scan.Vulnerability; v != nil {
+		for _, c := range []struct {
+			name  string
+			count int
+		}{{"critical", v.Critcal}, {"high", v.High}, {"medium", v.Medium}, {"low", v.Low}} {
+			if c.count < 0 {
+				problems = append(problems, fmt.Sprintf("external_vulnerability.%v is negative", c.name))
+			}
+		}
+	}
+
+	return problems
+}
+
+//unknownFields returns the fields of the JSON report that are not part of an
+//external scan, which are dropped rather than sent, such as a misspelled
+//severity
+func unknownFields(raw []byte) []string {
+	var report map[string]json.RawMessage
+	if json.Unmarshal(raw, &report) != nil {
+		return nil
+	}
+
+	unknown := []string{}
+	check := func(prefix string, fields map[string]json.RawMessage) {
+		for name := range fields {
+			if !contains(knownFields[prefix], name) {
+				if prefix != "" {
+					name = prefix + "." + name
+				}
+				unknown = append(unknown, name)
+			}
+		}
+	}
+
+	check("", report)
+	for _, nested := range []string{"coverage", "external_vulnerability", "source"} {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(report[nested], &fields) == nil {
+			check(nested, fields)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Scan returns the coverage as the scan data sent to the API
+func (c *Coverage) Scan() scanner.ExternalScan {
+	return scanner.ExternalScan{Coverage: c.Value}
+}
+
+//Problems returns what is wrong with the coverage value
+func (c *Coverage) Problems() []string {
+	scan := c.Scan()
+	return Problems(&scan)
+}
+
+//Scan returns the vulnerability scan data sent to the API
+func (c *Vulnerabilities) Scan() scanner.ExternalScan {
+	return *c.Value
+}
+
+//Problems returns what is wrong with the vulnerability report, including any
+//fields it has that are not part of the schema
+func (c *Vulnerabilities) Problems() []string {
+	problems := Problems(c.Value)
+	for _, f := range unknownFields(c.raw) {
+		problems = append(problems, fmt.Sprintf("%v is not a known field and is not sent", f))
+	}
+
+	return problems
+}
+
+//Scan returns the vulnerability counts of the FPR file sent to the API
+func (f *Fortify) Scan() scanner.ExternalScan {
+	return *f.Value
+}
+
+//Problems returns what is wrong with the counts read from the FPR file
+func (f *Fortify) Problems() []string {
+	return Problems(f.Value)
+}
+
+//Preview references the FPR file from the scan data as Upload would, with its
+//digest but a placeholder for the dropbox url, so the scan data can be shown
+//without uploading anything
+func (f *Fortify) Preview() error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(map[string]string{
+		"fpr":    PendingUpload,
+		"sha256": hex.EncodeToString(h.Sum(nil)),
+	})
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(b)
+	f.Value.Raw = &raw
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package external
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionic/scanner"
+	. "github.com/onsi/gomega"
+)
+
+func TestValidate(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Report validation", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-validate")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should find problems the API would reject", func() {
+			Expect(Problems(&scanner.ExternalScan{Coverage: &scanner.ExternalCoverage{Value: 87.5}})).To(BeEmpty())
+			Expect(Problems(&scanner.ExternalScan{})).To(Equal([]string{"has neither coverage nor external_vulnerability data"}))
+			Expect(Problems(&scanner.ExternalScan{Coverage: &scanner.ExternalCoverage{Value: 875}})).To(Equal([]string{"coverage 875 is not a percentage from 0 to 100"}))
+			Expect(Problems(&scanner.ExternalScan{Vulnerability: &scanner.ExternalVulnerability{High: -1, Low: -2}})).To(Equal([]string{
+				"external_vulnerability.high is negative",
+				"external_vulnerability.low is negative",
+			}))
+		})
+
+		g.It("should find fields that are not sent", func() {
+			path := filepath.Join(dir, "vulns.json")
+			ioutil.WriteFile(path, []byte(`{"external_vulnerability":{"critical":1,"hgih":2},"source":{"name":"scanner"},"tool":"scanner"}`), 0644)
+
+			vulns, err := ParseVulnerabilities(path)
+			Expect(err).To(BeNil())
+			Expect(vulns.Scan().Vulnerability.Critcal).To(Equal(1))
+			Expect(vulns.Problems()).To(Equal([]string{
+				"external_vulnerability.hgih is not a known field and is not sent",
+				"tool is not a known field and is not sent",
+			}))
+		})
+
+		g.It("should preview an fpr file without uploading it", func() {
+			root, _ := filepath.Abs(filepath.Join(os.Getenv("PWD"), "..", ".."))
+
+			fort, err := ReadFortify(filepath.Join(root, "fortify.zip"))
+			Expect(err).To(BeNil())
+			Expect(fort.Preview()).To(BeNil())
+			Expect(fort.Problems()).To(BeEmpty())
+
+			var raw map[string]string
+			Expect(json.Unmarshal(*fort.Scan().Raw, &raw)).To(BeNil())
+			Expect(raw["fpr"]).To(Equal(PendingUpload))
+			Expect(raw["sha256"]).To(HaveLen(64))
+		})
+	})
+}
@@ -0,0 +1,437 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/teams"
+	"github.com/ion-channel/ionize/client"
+	"github.com/ion-channel/ionize/cmd/external"
+	"github.com/ion-channel/ionize/config"
+	"github.com/ion-channel/ionize/git"
+	"github.com/ion-channel/ionize/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// initOptions are the answers to the init wizard given as flags
+type initOptions struct {
+	// Dir is where the repository is looked for
+	Dir string
+
+	File    string
+	Remote  string
+	URL     string
+	Branch  string
+	Team    string
+	Project string
+	Ruleset string
+	Name    string
+
+	Create         bool
+	NonInteractive bool
+	Force          bool
+}
+
+var initOpts = &initOptions{Dir: "."}
+
+func init() {
+	RootCmd.AddCommand(initRepoCmd)
+
+	f := initRepoCmd.Flags()
+	f.StringVarP(&initOpts.File, "file", "f", "", "config file to write (default is .ionize.yaml in the repository root)")
+	f.StringVarP(&initOpts.Remote, "remote", "", "origin", "git remote to read the url and default branch from")
+	f.StringVarP(&initOpts.URL, "url", "", "", "source url of the project (default is the url of the git remote)")
+	f.StringVarP(&initOpts.Branch, "branch", "", "", "branch of the project (default is the default branch of the git remote)")
+	f.StringVarP(&initOpts.Team, "team", "", "", "id of the team the project is in")
+	f.StringVarP(&initOpts.Project, "project", "", "", "id of the project, instead of looking it up by url")
+	f.StringVarP(&initOpts.Ruleset, "ruleset", "", "", "id of the ruleset to evaluate the project against")
+	f.StringVarP(&initOpts.Name, "name", "", "", "name of the project to create (default is the repository name)")
+	f.BoolVarP(&initOpts.Create, "create", "", false, "create the project when none matches the url without asking")
+	f.BoolVarP(&initOpts.NonInteractive, "non-interactive", "", false, "never prompt, taking every answer from flags and defaults")
+	f.BoolVarP(&initOpts.Force, "force", "", false, "overwrite the config file if it exists")
+}
+
+var initRepoCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a .ionize.yaml for the repository",
+	Long: `Write a commented .ionize.yaml for the repository.  The project is looked up by
+the url of the git remote, and can be created when there is none.  When the key
+belongs to more than one team, or the team has more than one ruleset, ionize
+asks which to use.  Coverage values, vulnerability reports, and Fortify FPR
+files already in the repository are added to the config.
+
+For templated repositories every answer can be given as a flag, for example:
+
+ionize init --non-interactive --team <team id> --create --ruleset <ruleset id>
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := loadKey()
+		if err != nil {
+			os.Exit(failed(err))
+		}
+
+		cli, err := newClient()
+		if err != nil {
+			fatalf("Failed to create Ion Channel Client: %v", err.Error())
+		}
+
+		err = initRepo(cli, viper.GetString("key"), os.Stdin, initOpts)
+		if err != nil {
+			fatalf("Failed to initialize the repository: %v", err.Error())
+		}
+	},
+}
+
+// initWizard asks the questions needed to write the config, taking the
+// answers from the options first
+type initWizard struct {
+	cli   client.Client
+	token string
+	in    *os.File
+	opts  *initOptions
+}
+
+func initRepo(cli client.Client, token string, in *os.File, opts *initOptions) error {
+	if token == "" {
+		return fmt.Errorf("no key is set, run ionize login")
+	}
+
+	root, err := git.Root(opts.Dir)
+	if err != nil {
+		fmt.Fprintf(output, "Not in a git repository, using %v\n", opts.Dir)
+		root = opts.Dir
+	}
+
+	file := opts.File
+	if file == "" {
+		file = filepath.Join(root, config.RepoFile)
+	}
+	if _, err := os.Stat(file); err == nil && !opts.Force {
+		return fmt.Errorf("%v already exists, use --force to overwrite it", file)
+	}
+
+	source := opts.URL
+	if source == "" {
+		source, err = git.RemoteURL(root, opts.Remote)
+		if err != nil {
+			return fmt.Errorf("failed to find the url of the project, use --url: %v", err.Error())
+		}
+	}
+
+	branch := opts.Branch
+	if branch == "" {
+		branch = git.DefaultBranch(root, opts.Remote)
+	}
+
+	w := &initWizard{cli: cli, token: token, in: in, opts: opts}
+
+	team, err := w.team()
+	if err != nil {
+		return err
+	}
+
+	project, err := w.project(team, source, branch)
+	if err != nil {
+		return err
+	}
+
+	reports, err := external.Detect(root)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(file, []byte(initConfigFile(source, team, project, reports)), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write config: %v", err.Error())
+	}
+
+	fmt.Fprintf(output, "Wrote %v\n", file)
+	return nil
+}
+
+func (w *initWizard) team() (*teams.Team, error) {
+	ts, err := w.cli.GetTeams(w.token)
+	if err != nil {
+		if w.opts.Team != "" {
+			return &teams.Team{ID: w.opts.Team}, nil
+		}
+		return nil, err
+	}
+
+	if w.opts.Team != "" {
+		for i := range ts {
+			if ts[i].ID == w.opts.Team {
+				return &ts[i], nil
+			}
+		}
+		return &teams.Team{ID: w.opts.Team}, nil
+	}
+
+	switch {
+	case len(ts) == 0:
+		return nil, fmt.Errorf("the key does not belong to any teams")
+	case len(ts) == 1:
+		fmt.Fprintf(output, "Using team %v (%v)\n", ts[0].Name, ts[0].ID)
+		return &ts[0], nil
+	case w.opts.NonInteractive:
+		return nil, fmt.Errorf("the key belongs to %v teams, choose one with --team", len(ts))
+	}
+
+	names := []string{}
+	for _, t := range ts {
+		names = append(names, fmt.Sprintf("%v (%v)", t.Name, t.ID))
+	}
+
+	i, err := w.choose("team", names)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ts[i], nil
+}
+
+func (w *initWizard) project(team *teams.Team, source, branch string) (*projects.Project, error) {
+	if w.opts.Project != "" {
+		p := &projects.Project{ID: &w.opts.Project}
+		if w.opts.Ruleset != "" {
+			p.RulesetID = &w.opts.Ruleset
+		}
+		return p, nil
+	}
+
+	urls := []string{source}
+	if https := git.HTTPSURL(source); https != source {
+		urls = append(urls, https)
+	}
+
+	for _, u := range urls {
+		p, err := w.cli.GetProjectByURL(u, team.ID, w.token)
+		if err == nil && p.ID != nil && *p.ID != "" {
+			fmt.Fprintf(output, "Found project %v (%v) for %v\n", stringValue(p.Name), *p.ID, u)
+			if w.opts.Ruleset != "" {
+				p.RulesetID = &w.opts.Ruleset
+			}
+			return p, nil
+		}
+	}
+
+	create := w.opts.Create
+	if !create && !w.opts.NonInteractive {
+		var err error
+		create, err = w.confirm(fmt.Sprintf("No project matches %v, create one?", source), true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !create {
+		return nil, fmt.Errorf("no project matches %v, use --create to create one or --project to use another", source)
+	}
+
+	rulesetID, err := w.ruleset(team)
+	if err != nil {
+		return nil, err
+	}
+
+	name := w.opts.Name
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(filepath.ToSlash(source)), ".git")
+		if !w.opts.NonInteractive {
+			answer, err := terminal.ReadLine(w.in, output, fmt.Sprintf("Project name [%v]: ", name))
+			if err != nil {
+				return nil, err
+			}
+			if answer != "" {
+				name = answer
+			}
+		}
+	}
+
+	// the project is created with the https url the API can clone, and found by
+	// it again on the next run
+	source = git.HTTPSURL(source)
+	ty := "git"
+	description := "Created by ionize init"
+	p := &projects.Project{
+		Name:        &name,
+		Type:        &ty,
+		Source:      &source,
+		Branch:      &branch,
+		Description: &description,
+		TeamID:      &team.ID,
+		RulesetID:   &rulesetID,
+		Active:      true,
+	}
+
+	p, err = w.cli.CreateProject(p, team.ID, w.token)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(output, "Created project %v (%v) for %v\n", name, stringValue(p.ID), source)
+	return p, nil
+}
+
+func (w *initWizard) ruleset(team *teams.Team) (string, error) {
+	if w.opts.Ruleset != "" {
+		return w.opts.Ruleset, nil
+	}
+
+	rs, err := w.cli.GetRuleSets(team.ID, w.token, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if len(rs) == 0 {
+		return "", fmt.Errorf("team %v has no rulesets, create one in the console", team.ID)
+	}
+
+	if len(rs) == 1 || w.opts.NonInteractive {
+		fmt.Fprintf(output, "Using ruleset %v (%v)\n", rs[0].Name, rs[0].ID)
+		return rs[0].ID, nil
+	}
+
+	names := []string{}
+	for _, r := range rs {
+		names = append(names, fmt.Sprintf("%v (%v)", r.Name, r.ID))
+	}
+
+	i, err := w.choose("ruleset", names)
+	if err != nil {
+		return "", err
+	}
+
+	return rs[i].ID, nil
+}
+
+// choose asks for one of the options by number, defaulting to the first
+func (w *initWizard) choose(what string, options []string) (int, error) {
+	fmt.Fprintf(output, "Choose a %v:\n", what)
+	for i, o := range options {
+		fmt.Fprintf(output, "  %v) %v\n", i+1, o)
+	}
+
+	for {
+		answer, err := terminal.ReadLine(w.in, output, "Enter a number [1]: ")
+		if err != nil {
+			return 0, err
+		}
+
+		if answer == "" {
+			return 0, nil
+		}
+
+		n, err := strconv.Atoi(answer)
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+
+		fmt.Fprintf(output, "%v is not one of the choices\n", answer)
+	}
+}
+
+// confirm asks a yes or no question
+func (w *initWizard) confirm(question string, def bool) (bool, error) {
+	hint := "[Y/n]"
+	if !def {
+		hint = "[y/N]"
+	}
+
+	for {
+		answer, err := terminal.ReadLine(w.in, output, fmt.Sprintf("%v %v: ", question, hint))
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// initConfigFile returns the commented config written by init, with the
+// reports that were not found left as commented examples
+func initConfigFile(source string, team *teams.Team, project *projects.Project, reports *external.Reports) string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "# Written by ionize init for %v\n", source)
+	fmt.Fprintln(b, "# See .ionize.yaml.example for every setting.  The key is not written here,")
+	fmt.Fprintln(b, "# set IONCHANNEL_SECRET_KEY, key_file or key_command in the user config, or")
+	fmt.Fprintln(b, "# run ionize login.")
+	fmt.Fprintln(b)
+
+	fmt.Fprintln(b, "# The team id of the team your project resides in")
+	if team.Name != "" {
+		fmt.Fprintf(b, "# (%v)\n", team.Name)
+	}
+	fmt.Fprintf(b, "team: %v\n\n", yamlString(team.ID))
+
+	fmt.Fprintln(b, "# The project id of the Ion Channel project record")
+	if project.Name != nil {
+		fmt.Fprintf(b, "# (%v)\n", *project.Name)
+	}
+	fmt.Fprintf(b, "project: %v\n\n", yamlString(stringValue(project.ID)))
+
+	if project.RulesetID != nil {
+		fmt.Fprintln(b, "# The ruleset projects created by scrutinize are evaluated against")
+		fmt.Fprintf(b, "ruleset: %v\n\n", yamlString(*project.RulesetID))
+	}
+
+	fmt.Fprintln(b, "# Specify the location of the coverage value")
+	fmt.Fprintln(b, "# must be a float value")
+	if reports.Coverage != "" {
+		fmt.Fprintf(b, "coverage: %v\n\n", yamlString(reports.Coverage))
+	} else {
+		fmt.Fprintf(b, "# coverage: coverage.txt\n\n")
+	}
+
+	fmt.Fprintln(b, "# Vulnerabilities found by other tools, in the Ion Channel external format")
+	if len(reports.Vulnerabilities) > 0 {
+		fmt.Fprintln(b, "vulnerabilities:")
+		for _, v := range reports.Vulnerabilities {
+			fmt.Fprintf(b, "  - %v\n", yamlString(v))
+		}
+		fmt.Fprintln(b)
+	} else {
+		fmt.Fprintf(b, "# vulnerabilities:\n#   - vulnerabilities.json\n\n")
+	}
+
+	fmt.Fprintln(b, "# Fortify FPR file uploaded to the dropbox for the analysis")
+	if reports.Fortify != "" {
+		fmt.Fprintf(b, "fortify: %v\n", yamlString(reports.Fortify))
+	} else {
+		fmt.Fprintf(b, "# fortify: audit.fpr\n")
+	}
+
+	return b.String()
+}
+
+// yamlString formats the string as a yaml scalar, quoting it when needed
+func yamlString(s string) string {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return strconv.Quote(s)
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
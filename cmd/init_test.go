@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/teams"
+	"github.com/ion-channel/ionize/client/clienttest"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+const (
+	initTeamID    = "0f4e5a1c-6a4e-4e6b-8d52-3f1e2f7d9a10"
+	initOtherTeam = "1a2b3c4d-6a4e-4e6b-8d52-3f1e2f7d9a10"
+	initProjectID = "9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e"
+	initRulesetID = "5c6d7e8f-1c2a-4b5c-9d8e-7f6a5b4c3d2e"
+	initOtherRule = "6d7e8f90-1c2a-4b5c-9d8e-7f6a5b4c3d2e"
+)
+
+func TestInit(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Init Command", func() {
+		var dir string
+		var server *clienttest.Server
+
+		stdin := func(contents string) *os.File {
+			path := filepath.Join(dir, ".git", "stdin")
+			ioutil.WriteFile(path, []byte(contents), 0600)
+			f, _ := os.Open(path)
+			return f
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-init")
+			dir, _ = filepath.EvalSymlinks(dir)
+			exec.Command("git", "-C", dir, "init", "-q").Run()
+			exec.Command("git", "-C", dir, "checkout", "-q", "-b", "main").Run()
+			exec.Command("git", "-C", dir, "remote", "add", "origin", "git@github.com:ion-channel/widget.git").Run()
+
+			os.MkdirAll(filepath.Join(dir, "build", "reports"), 0755)
+			ioutil.WriteFile(filepath.Join(dir, "build", "coverage.txt"), []byte("87.5\n"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "build", "reports", "vulns.json"), []byte(`{"external_vulnerability":{"critical":1}}`), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "build", "reports", "other.json"), []byte(`{"name":"other"}`), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "build", "audit.fpr"), []byte("fpr"), 0644)
+
+			server = clienttest.NewServer()
+			server.Teams = []teams.Team{{ID: initTeamID, Name: "Widgets"}}
+			server.Rulesets = []rulesets.RuleSet{{ID: initRulesetID, Name: "Default"}, {ID: initOtherRule, Name: "Strict"}}
+			output = penname.New()
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+			viper.Reset()
+		})
+
+		g.It("should write the config for the project matching the remote", func() {
+			id, name, source, ruleset := initProjectID, "widget", "https://github.com/ion-channel/widget.git", initRulesetID
+			server.Projects = []projects.Project{{ID: &id, Name: &name, Source: &source, RulesetID: &ruleset}}
+
+			err := initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: filepath.Join(dir, "build"), Remote: "origin", NonInteractive: true})
+			Expect(err).To(BeNil())
+
+			b, _ := ioutil.ReadFile(filepath.Join(dir, ".ionize.yaml"))
+			Expect(string(b)).To(Equal(`# Written by ionize init for git@github.com:ion-channel/widget.git
+# See .ionize.yaml.example for every setting.  The key is not written here,
+# set IONCHANNEL_SECRET_KEY, key_file or key_command in the user config, or
+# run ionize login.
+
+# The team id of the team your project resides in
+# (Widgets)
+team: ` + initTeamID + `
+
+# The project id of the Ion Channel project record
+# (widget)
+project: ` + initProjectID + `
+
+# The ruleset projects created by scrutinize are evaluated against
+ruleset: ` + initRulesetID + `
+
+# Specify the location of the coverage value
+# must be a float value
+coverage: build/coverage.txt
+
+# Vulnerabilities found by other tools, in the Ion Channel external format
+vulnerabilities:
+  - build/reports/vulns.json
+
+# Fortify FPR file uploaded to the dropbox for the analysis
+fortify: build/audit.fpr
+`))
+		})
+
+		g.It("should create the project when asked to without prompting", func() {
+			err := initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: dir, Remote: "origin", NonInteractive: true, Create: true})
+			Expect(err).To(BeNil())
+
+			Expect(server.Projects).To(HaveLen(1))
+			created := server.Projects[0]
+			Expect(*created.Name).To(Equal("widget"))
+			Expect(*created.Source).To(Equal("https://github.com/ion-channel/widget.git"))
+			Expect(*created.Branch).To(Equal("main"))
+			Expect(*created.Type).To(Equal("git"))
+			Expect(*created.RulesetID).To(Equal(initRulesetID))
+			Expect(*created.TeamID).To(Equal(initTeamID))
+
+			err = initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: dir, Remote: "origin", NonInteractive: true, Create: true, Force: true})
+			Expect(err).To(BeNil())
+			Expect(server.Projects).To(HaveLen(1))
+		})
+
+		g.It("should ask for the team, ruleset, and name", func() {
+			server.Teams = append(server.Teams, teams.Team{ID: initOtherTeam, Name: "Gadgets"})
+			in := stdin("2\n\n5\n2\nwidget-service\n")
+			defer in.Close()
+
+			err := initRepo(server.Client(), "supersecretapikey", in, &initOptions{Dir: dir, Remote: "origin"})
+			Expect(err).To(BeNil())
+
+			Expect(server.Projects).To(HaveLen(1))
+			created := server.Projects[0]
+			Expect(*created.TeamID).To(Equal(initOtherTeam))
+			Expect(*created.RulesetID).To(Equal(initOtherRule))
+			Expect(*created.Name).To(Equal("widget-service"))
+			Expect(string(output.(*penname.PenName).Written())).To(ContainSubstring("5 is not one of the choices\n"))
+
+			b, _ := ioutil.ReadFile(filepath.Join(dir, ".ionize.yaml"))
+			Expect(string(b)).To(ContainSubstring("# (Gadgets)\nteam: " + initOtherTeam + "\n"))
+			Expect(string(b)).To(ContainSubstring("# (widget-service)\nproject: " + *created.ID + "\n"))
+		})
+
+		g.It("should not guess when it can not prompt", func() {
+			server.Teams = append(server.Teams, teams.Team{ID: initOtherTeam, Name: "Gadgets"})
+			err := initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: dir, Remote: "origin", NonInteractive: true})
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("--team"))
+
+			err = initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: dir, Remote: "origin", NonInteractive: true, Team: initTeamID})
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("--create"))
+		})
+
+		g.It("should not overwrite an existing config", func() {
+			ioutil.WriteFile(filepath.Join(dir, ".ionize.yaml"), []byte("team: someteam\n"), 0644)
+
+			err := initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: dir, Remote: "origin", NonInteractive: true, Project: initProjectID, Team: initTeamID})
+			Expect(err).NotTo(BeNil())
+
+			err = initRepo(server.Client(), "supersecretapikey", nil, &initOptions{Dir: dir, Remote: "origin", NonInteractive: true, Project: initProjectID, Team: initTeamID, Force: true})
+			Expect(err).To(BeNil())
+		})
+	})
+}
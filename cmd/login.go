@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ion-channel/ionize/client"
+	"github.com/ion-channel/ionize/credentials"
+	"github.com/ion-channel/ionize/redact"
+	"github.com/ion-channel/ionize/terminal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	loginKey      = false
+	loginUsername = ""
+)
+
+func init() {
+	RootCmd.AddCommand(loginCmd)
+	RootCmd.AddCommand(logoutCmd)
+	RootCmd.AddCommand(whoamiCmd)
+
+	loginCmd.Flags().BoolVarP(&loginKey, "key", "", false, "save an API key instead of logging in with a username and password")
+	loginCmd.Flags().StringVarP(&loginUsername, "username", "u", "", "username to login with instead of prompting for it")
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Login to the API and save the session in the credentials file",
+	Long: `Prompt for a username and password, login to the configured API, and save the
+session token in the credentials file in the user config dir
+(~/.config/ionize/credentials).  With --key an API key is prompted for and saved
+instead.  The file is only protected at rest when $` + credentials.PassphraseEnv + `
+is set, as it is encrypted with that passphrase.  Otherwise it is encrypted with
+a key generated beside it, which anyone able to read the file can also read,
+and ionize warns about it.  The answers are read
+from stdin when it is not a terminal, for example:
+
+printf '%s\n' "$PASSWORD" | ionize login --username someone
+echo "$KEY" | ionize login --key
+
+The saved credentials are used when neither $` + key + `, key, key_file, nor
+key_command are set, and ionize warns when the session has expired.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := login(os.Stdin)
+		if err != nil {
+			fatalf("Failed to login: %v", err.Error())
+		}
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the saved credentials for the API",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := logout()
+		if err != nil {
+			fatalf("Failed to logout: %v", err.Error())
+		}
+	},
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Print the user the key or session belongs to and their teams",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := loadKey()
+		if err != nil {
+			os.Exit(failed(err))
+		}
+
+		cli, err := newClient()
+		if err != nil {
+			fatalf("Failed to create Ion Channel Client: %v", err.Error())
+		}
+
+		err = whoami(cli, viper.GetString("key"))
+		if err != nil {
+			fatalf("Failed to find the user: %v", err.Error())
+		}
+	},
+}
+
+func login(in *os.File) error {
+	api := viper.GetString("api")
+
+	var entry *credentials.Entry
+	if loginKey {
+		k, err := terminal.ReadSecret(in, output, "API Key: ")
+		if err != nil {
+			return err
+		}
+		if k == "" {
+			return fmt.Errorf("no key given")
+		}
+
+		entry = &credentials.Entry{Key: k}
+	} else {
+		username := loginUsername
+		if username == "" {
+			u, err := terminal.ReadLine(in, output, "Username: ")
+			if err != nil {
+				return err
+			}
+			username = u
+		}
+
+		password, err := terminal.ReadSecret(in, output, "Password: ")
+		if err != nil {
+			return err
+		}
+		if username == "" || password == "" {
+			return fmt.Errorf("a username and password are required")
+		}
+		redact.Add(password)
+
+		cli, err := newClient()
+		if err != nil {
+			return fmt.Errorf("failed to create Ion Channel Client: %v", err.Error())
+		}
+
+		session, err := cli.Login(username, password)
+		if err != nil {
+			return err
+		}
+
+		entry = &credentials.Entry{
+			Token:    session.BearerToken,
+			Username: username,
+			Expires:  credentials.TokenExpiry(session.BearerToken),
+		}
+	}
+
+	f := credentials.DefaultFile()
+	creds, err := f.Load()
+	if err != nil {
+		return err
+	}
+
+	creds.Set(api, entry)
+	err = f.Save(creds)
+	if err != nil {
+		return err
+	}
+	if !f.Protected() {
+		logger.Warnf("%v is encrypted with the key in %v, which does not protect it from anyone able to read your files, set %v to encrypt it with a passphrase", f.Path, f.KeyPath, credentials.PassphraseEnv)
+	}
+
+	if entry.Key != "" {
+		fmt.Fprintf(output, "Saved the key for %v to %v\n", api, f.Path)
+		return nil
+	}
+
+	fmt.Fprintf(output, "Logged in to %v as %v, saved the session to %v\n", api, entry.Username, f.Path)
+	if !entry.Expires.IsZero() {
+		fmt.Fprintf(output, "The session expires at %v\n", entry.Expires.Local().Format(time.RFC1123))
+	}
+
+	return nil
+}
+
+func logout() error {
+	api := viper.GetString("api")
+
+	f := credentials.DefaultFile()
+	creds, err := f.Load()
+	if err != nil {
+		return err
+	}
+
+	if creds.Get(api) == nil {
+		fmt.Fprintf(output, "No credentials are saved for %v\n", api)
+		return nil
+	}
+
+	creds.Delete(api)
+	err = f.Save(creds)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output, "Removed the credentials for %v from %v\n", api, f.Path)
+	return nil
+}
+
+func whoami(cli client.Client, token string) error {
+	if token == "" {
+		return fmt.Errorf("no key is set, run ionize login")
+	}
+
+	user, err := cli.GetSelf(token)
+	if err != nil {
+		return err
+	}
+
+	teams, err := cli.GetTeams(token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output, "Username: %v\n", user.Username)
+	fmt.Fprintf(output, "Email: %v\n", user.Email)
+	fmt.Fprintf(output, "ID: %v\n", user.ID)
+	fmt.Fprintf(output, "Key Source: %v\n", configSource(nil, "key"))
+	if !keyExpires.IsZero() {
+		fmt.Fprintf(output, "Session Expires: %v\n", keyExpires.Local().Format(time.RFC1123))
+	}
+
+	fmt.Fprintln(output)
+	w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TEAM\tID\tROLE")
+	for _, t := range teams {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", t.Name, t.ID, user.Teams[t.ID])
+	}
+	w.Flush()
+
+	return nil
+}
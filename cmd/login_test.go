@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionize/client/clienttest"
+	"github.com/ion-channel/ionize/config"
+	"github.com/ion-channel/ionize/credentials"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/runner"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestLogin(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Credentials", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-login")
+			os.Setenv("XDG_CONFIG_HOME", dir)
+			viper.Set("api", "https://api.test.ionchannel.io")
+		})
+
+		g.AfterEach(func() {
+			os.Unsetenv("XDG_CONFIG_HOME")
+			os.RemoveAll(dir)
+			keySource = ""
+			keyExpires = time.Time{}
+			loginKey = false
+			loginUsername = ""
+			viper.Reset()
+		})
+
+		stdin := func(contents string) *os.File {
+			in := filepath.Join(dir, "stdin")
+			ioutil.WriteFile(in, []byte(contents), 0600)
+			f, _ := os.Open(in)
+			return f
+		}
+
+		g.It("should save the key and use it when no other is set", func() {
+			f := stdin("supersecretapikey\n")
+			defer f.Close()
+
+			mw := penname.New()
+			output = mw
+			logs := &bytes.Buffer{}
+			logger, _ = logging.New(logs, logging.LevelInfo, logging.FormatText)
+			defer initLogging()
+			loginKey = true
+			Expect(login(f)).To(BeNil())
+
+			path := filepath.Join(dir, "ionize", "credentials")
+			Expect(string(mw.Written())).To(Equal("API Key: Saved the key for https://api.test.ionchannel.io to " + path + "\n"))
+			Expect(logs.String()).To(ContainSubstring(path + " is encrypted with the key in " + path + ".key, which does not protect it"))
+
+			logs.Reset()
+			os.Setenv(credentials.PassphraseEnv, "correct horse")
+			defer os.Unsetenv(credentials.PassphraseEnv)
+			f.Seek(0, 0)
+			Expect(login(f)).To(BeNil())
+			Expect(logs.String()).To(BeEmpty())
+
+			loadKey()
+			Expect(viper.GetString("key")).To(Equal("supersecretapikey"))
+			Expect(configSource(nil, "key")).To(Equal("credentials " + path))
+		})
+
+		g.It("should read the key from key_file before the credentials file", func() {
+			path := filepath.Join(dir, "key")
+			ioutil.WriteFile(path, []byte("mountedsecretkey\n"), 0600)
+			viper.Set("key_file", path)
+
+			loadKey()
+			Expect(viper.GetString("key")).To(Equal("mountedsecretkey"))
+			Expect(configSource(nil, "key")).To(Equal("key_file " + path))
+		})
+
+		g.It("should read the key from key_command", func() {
+			viper.Set("key_command", "echo helpersecretkey")
+
+			loadKey()
+			Expect(viper.GetString("key")).To(Equal("helpersecretkey"))
+			Expect(configSource(nil, "key")).To(Equal("key_command"))
+		})
+
+		g.It("should not read the key from a command the repo config names", func() {
+			marker := filepath.Join(dir, "ran")
+			repo := filepath.Join(dir, ".ionize.yaml")
+			ioutil.WriteFile(repo, []byte("key_command: touch "+marker+"\n"), 0644)
+			configs, _ = config.Load(&config.Options{RepoPath: repo})
+			defer func() { configs = nil }()
+			viper.Set("key_command", "touch "+marker)
+
+			err := loadKey()
+			Expect(errors.Is(err, runner.ErrInput)).To(BeTrue())
+			Expect(err.Error()).To(Equal("key_command is only read from the system and user configs, not the repo (" + repo + ")"))
+			Expect(marker).NotTo(BeAnExistingFile())
+			Expect(viper.GetString("key")).To(Equal(""))
+		})
+
+		g.It("should leave a key that is already set", func() {
+			viper.Set("key", "configuredkey")
+			viper.Set("key_command", "echo helpersecretkey")
+
+			loadKey()
+			Expect(viper.GetString("key")).To(Equal("configuredkey"))
+			Expect(keySource).To(Equal(""))
+		})
+
+		g.It("should login with a username and password and save the session", func() {
+			claims := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":4102444800}`))
+			token := "eyJhbGciOiJIUzI1NiJ9." + claims + ".sig"
+
+			server := clienttest.NewServer()
+			server.Password = "hunter2"
+			server.Token = token
+			defer server.Close()
+			viper.Set("api", server.URL)
+
+			f := stdin("someone\nhunter2\n")
+			defer f.Close()
+
+			mw := penname.New()
+			output = mw
+			Expect(login(f)).To(BeNil())
+
+			path := filepath.Join(dir, "ionize", "credentials")
+			Expect(server.Requests()).To(Equal([]string{"POST /v1/sessions/login"}))
+			Expect(string(mw.Written())).To(HavePrefix("Username: Password: Logged in to " + server.URL + " as someone, saved the session to " + path + "\nThe session expires at "))
+
+			loadKey()
+			Expect(viper.GetString("key")).To(Equal(token))
+			Expect(keyExpires).To(Equal(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)))
+		})
+
+		g.It("should remove the saved credentials on logout", func() {
+			f := stdin("supersecretapikey\n")
+			defer f.Close()
+			loginKey = true
+			output = penname.New()
+			login(f)
+
+			mw := penname.New()
+			output = mw
+			Expect(logout()).To(BeNil())
+			Expect(logout()).To(BeNil())
+
+			Expect(string(mw.Written())).To(Equal("Removed the credentials for https://api.test.ionchannel.io from " + filepath.Join(dir, "ionize", "credentials") + "\n" +
+				"No credentials are saved for https://api.test.ionchannel.io\n"))
+
+			loadKey()
+			Expect(viper.GetString("key")).To(Equal(""))
+		})
+
+		g.It("should print the user and their teams", func() {
+			server := clienttest.NewServer()
+			server.Key = "supersecretapikey"
+			defer server.Close()
+
+			cli := server.Client()
+			viper.Set("key", "supersecretapikey")
+
+			mw := penname.New()
+			output = mw
+			Expect(whoami(cli, "supersecretapikey")).To(BeNil())
+
+			out := string(mw.Written())
+			Expect(out).To(HavePrefix("Username: someone\nEmail: someone@example.com\nID: user-1\nKey Source: default\n\n"))
+			Expect(out).To(MatchRegexp(`Some Team\s+team-1\s+admin\n`))
+
+			Expect(whoami(cli, "")).NotTo(BeNil())
+			Expect(whoami(cli, "someotherkey")).NotTo(BeNil())
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ion-channel/ionize/policy"
+	"github.com/ion-channel/ionize/runner"
+	"github.com/spf13/viper"
+)
+
+var (
+	// localPolicy is checked against the external reports of each project
+	// analyzed
+	localPolicy *policy.Policy
+)
+
+func init() {
+	analyzeCmd.Flags().Bool("fail-fast", false, "do not request the analysis when the external reports fail the local policy")
+	bindFlag("policy.fail_fast", analyzeCmd, "fail-fast")
+}
+
+// loadPolicy compiles the local policy in the config, along with the most
+// coverage may drop by, which is nil when it has no rules
+func loadPolicy() (*policy.Policy, error) {
+	var rules []policy.Rule
+	err := viper.UnmarshalKey("policy.rules", &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if viper.IsSet("coverage_trend.max_drop") {
+		drop := viper.GetFloat64("coverage_trend.max_drop")
+		rules = append(rules, policy.Rule{
+			Name: fmt.Sprintf("Coverage drops by at most %v", drop),
+			Expr: fmt.Sprintf("coverage.delta >= -%v", drop),
+		})
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	return policy.New(rules, viper.GetBool("policy.fail_fast"))
+}
+
+// trendOptions returns how coverage is compared with earlier analyses, nil
+// when it is not
+func trendOptions() *runner.TrendOptions {
+	enabled := viper.GetBool("coverage_trend.enabled")
+	for _, k := range []string{"base_branch", "max_drop", "cache"} {
+		enabled = enabled || viper.IsSet("coverage_trend."+k)
+	}
+	if !enabled {
+		return nil
+	}
+
+	return &runner.TrendOptions{
+		BaseBranch: viper.GetString("coverage_trend.base_branch"),
+		Cache:      viper.GetString("coverage_trend.cache"),
+	}
+}
@@ -1,23 +1,55 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"time"
 
+	"github.com/ion-channel/ionize/config"
+	"github.com/ion-channel/ionize/credentials"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/git"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/redact"
+	"github.com/ion-channel/ionize/runner"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 const (
-	key    = "IONCHANNEL_SECRET_KEY"
-	api    = "IONCHANNEL_ENDPOINT_URL"
-	bucket = "IONCHANNEL_DROP_BUCKET"
+	key     = "IONCHANNEL_SECRET_KEY"
+	api     = "IONCHANNEL_ENDPOINT_URL"
+	bucket  = "IONCHANNEL_DROP_BUCKET"
+	profile = "IONIZE_PROFILE"
 )
 
 var (
-	output  io.Writer
-	cfgFile string
+	output      io.Writer
+	cfgFile     string
+	profileName string
+	configs     *config.Config
+
+	// configErr is why the config failed to load, if it did
+	configErr error
+
+	// keySource describes where the key came from when it was not set by the
+	// environment or a config layer
+	keySource string
+
+	// keyExpires is when the session the key came from expires, if it does
+	keyExpires time.Time
+
+	verbose   bool
+	quiet     bool
+	logLevel  string
+	logFormat string
+
+	// logger writes diagnostics to stderr, leaving stdout to reports and
+	// other output meant to be read by people or programs
+	logger *logging.Logger
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -31,10 +63,50 @@ perform supply chain analysis for a project.
 
 func init() {
 	output = os.Stdout
+	initLogging()
+
+	cobra.OnInitialize(initLogging, initDefaults, initEnvs, initConfig, warnPlaintextKeys, initRedaction)
+	RootCmd.PersistentPreRun = checkConfig
+
+	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is .ionize.yaml in $PWD or its parents up to the repository root)")
+	RootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "named profile from the config files to use (default is $"+profile+")")
+
+	RootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "log debug messages, such as each check of an analysis, as well as progress")
+	RootCmd.PersistentFlags().BoolVar(&quiet, "quiet", false, "only log warnings and errors, not progress")
+	RootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "the least important messages logged: debug, info, warn, or error (default info)")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "log as text, or as json lines with the team, project, analysis_id, and phase of each message")
+}
+
+// initLogging creates the logger for the level and format given by the
+// flags, writing to stderr, and passes anything logged with the log package
+// through it
+func initLogging() {
+	level := logging.LevelInfo
+	var problem error
+	switch {
+	case logLevel != "":
+		level, problem = logging.ParseLevel(logLevel)
+	case verbose:
+		level = logging.LevelDebug
+	case quiet:
+		level = logging.LevelWarn
+	}
+
+	l, err := logging.New(os.Stderr, level, logFormat)
+	if err != nil {
+		problem = err
+		l, _ = logging.New(os.Stderr, level, logging.FormatText)
+	}
+	logger = l
 
-	cobra.OnInitialize(initDefaults, initEnvs, initConfig)
+	if problem != nil {
+		logger.Warnf("%v", problem)
+	}
 
-	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $PWD/.ionize.yaml)")
+	// the libraries logging with the log package only do so for problems, such
+	// as failed handshakes, so those are warnings that --quiet keeps
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelWarn))
 }
 
 func initDefaults() {
@@ -48,18 +120,185 @@ func initEnvs() {
 	viper.BindEnv("bucket", bucket)
 }
 
+// envs maps the config keys that can be set from the environment to their
+// environment variables
+var envs = map[string]string{
+	"key":    key,
+	"api":    api,
+	"bucket": bucket,
+}
+
+// flagKeys maps the config keys bound to flags to the names of their flags
+var flagKeys = map[string]string{}
+
+// bindFlag binds the config key to the command's flag, which takes precedence
+// over every other source when it is given
+func bindFlag(k string, cmd *cobra.Command, name string) {
+	flagKeys[k] = name
+	viper.BindPFlag(k, cmd.Flag(name))
+}
+
 func initConfig() {
 	viper.SetConfigType("yaml")
 
-	viper.SetConfigFile(".ionize.yaml")
+	opts := config.DefaultOptions()
 	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
+		opts.RepoPath = cfgFile
+		opts.RepoRequired = true
+		opts.ResolveRepoPaths = false
+	}
+
+	opts.Profile = profileName
+	if opts.Profile == "" {
+		opts.Profile = os.Getenv(profile)
+	}
+
+	configs, configErr = nil, nil
+	loaded, err := config.Load(opts)
+	if err != nil {
+		configErr = err
+		return
+	}
+
+	b, err := loaded.YAML()
+	if err == nil {
+		err = viper.ReadConfig(bytes.NewReader(b))
+	}
+	if err != nil {
+		configErr = err
+		return
+	}
+
+	configs = loaded
+}
+
+// checkConfig exits when the configs failed to load, rather than running the
+// command without them, unless the command is configs validate, which reports
+// why they failed
+func checkConfig(cmd *cobra.Command, args []string) {
+	code := configCode(cmd)
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// configCode logs why the configs failed to load and returns the code to exit
+// with for the command, or 0 when it can run
+func configCode(cmd *cobra.Command) int {
+	if configErr == nil || cmd == configsValidateCmd {
+		return 0
+	}
+
+	return failed(&runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Failed reading config: %v", configErr.Error())})
+}
+
+// loadKey finds the key when it is not set by the environment or a config
+// layer, trying key_file, key_command, and then the key or session saved by
+// ionize login.  Only the commands calling the API load it, so the others
+// never run key_command, and key_file and key_command are only read from the
+// system and user configs, so a repository can not choose what is run.
+func loadKey() error {
+	if viper.GetString("key") != "" {
+		return nil
+	}
+
+	keySource = ""
+	keyExpires = time.Time{}
+
+	if configs != nil {
+		for _, k := range config.TrustedKeys {
+			if l := configs.Untrusted(k); l != nil {
+				return &runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("%v is only read from the system and user configs, not the %v", k, l)}
+			}
+		}
+	}
+
+	var k string
+	var err error
+	switch {
+	case viper.GetString("key_file") != "":
+		path := viper.GetString("key_file")
+		k, err = credentials.ReadKeyFile(path)
+		keySource = "key_file " + path
+
+	case viper.GetString("key_command") != "":
+		k, err = credentials.RunKeyCommand(viper.GetString("key_command"))
+		keySource = "key_command"
+
+	default:
+		f := credentials.DefaultFile()
+		var creds *credentials.Credentials
+		creds, err = f.Load()
+		if err == nil {
+			if e := creds.Get(viper.GetString("api")); e != nil {
+				k = e.Secret()
+				if e.Key == "" {
+					keyExpires = e.Expires
+				}
+			}
+		}
+		keySource = "credentials " + f.Path
 	}
 
-	err := viper.ReadInConfig()
 	if err != nil {
-		fmt.Printf("Failed reading config: %v\n", err.Error())
+		logger.Errorf("Failed reading key: %v", err.Error())
+	}
+
+	if k == "" {
+		keySource = ""
+		return nil
+	}
+
+	redact.Add(k)
+	viper.Set("key", k)
+	warnExpiry(time.Now())
+	return nil
+}
+
+// warnExpiry warns when the session the key came from has expired or is about
+// to
+func warnExpiry(now time.Time) {
+	if keyExpires.IsZero() {
+		return
+	}
+
+	expires := keyExpires.Local().Format(time.RFC1123)
+	switch {
+	case now.After(keyExpires):
+		logger.Warnf("The session for %v expired at %v, run ionize login", viper.GetString("api"), expires)
+	case now.Add(time.Hour).After(keyExpires):
+		logger.Warnf("The session for %v expires at %v", viper.GetString("api"), expires)
+	}
+}
+
+// warnPlaintextKeys warns about every config file holding a key that git
+// tracks, as committing a key shares it with everyone who can read the repo
+func warnPlaintextKeys() {
+	if configs == nil {
+		return
+	}
+
+	warned := map[string]bool{}
+	for _, l := range configs.Layers {
+		k, _ := l.Values["key"].(string)
+		if k == "" || l.Path == "" || l.Interpolated["key"] || warned[l.Path] {
+			continue
+		}
+
+		if git.Tracked(l.Path) {
+			warned[l.Path] = true
+			logger.Warnf("%v holds a plaintext key and is tracked by git, use key_file, key_command, or ionize login instead", l.Path)
+		}
+	}
+}
+
+// initRedaction registers every secret in the configs and the environment so
+// they are removed from anything logged
+func initRedaction() {
+	for _, k := range config.Secrets() {
+		redact.Add(viper.GetString(k))
 	}
+	redact.Add(os.Getenv(dropbox.FetchPasswordEnv), os.Getenv(dropbox.FetchTokenEnv))
 }
 
 func init() {
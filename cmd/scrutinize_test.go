@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionize/client/clienttest"
+	"github.com/ion-channel/ionize/logging"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestScrutinize(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Scrutinize Command", func() {
+		var dir string
+		var server *clienttest.Server
+		var url string
+		var logs *bytes.Buffer
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-scrutinize")
+
+			server = clienttest.NewServer()
+			server.Statuses = []string{"finished"}
+			server.Artifacts = map[string][]byte{"widget.tgz": []byte("widget")}
+
+			url = server.URL + clienttest.ArtifactsPath + "widget.tgz"
+			id, team, version := "project-1", "team-1", "1.0.0"
+			server.Projects = []projects.Project{{ID: &id, Source: &url, Branch: &version, TeamID: &team}}
+
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewReader([]byte("team: team-1\ndropbox:\n  backend: file\n  dir: " + filepath.Join(dir, "dropbox") + "\n")))
+			viper.Set("api", server.URL)
+			viper.Set("key", "supersecretapikey")
+
+			output = penname.New()
+			logs = &bytes.Buffer{}
+			logger, _ = logging.New(logs, logging.LevelInfo, logging.FormatJSON)
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+			initLogging()
+			viper.Reset()
+		})
+
+		g.It("should analyze the artifact as the project created for it", func() {
+			Expect(runScrutinize(url, "widget", "1.0.0")).To(Equal(0))
+
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(server.Analyses()[0].ProjectID).To(Equal("project-1"))
+			Expect(server.Analyses()[0].Branch).To(Equal("1.0.0"))
+
+			Expect(logs.String()).To(ContainSubstring(`"level":"info","msg":"Created project: project-1 (team-1) for ` + url + `","phase":"project","project":"project-1","team":"team-1"}`))
+			Expect(string(output.(*penname.PenName).Written())).To(Equal("Coverage...Rule Type: coverage...passed...Risk:  low\nAnalysis passed all rules\n"))
+		})
+
+		g.It("should exit with the code for how the analysis went", func() {
+			server.Rules[0].Passed = false
+			Expect(runScrutinize(url, "widget", "1.0.0")).To(Equal(exitFailed))
+
+			Expect(runScrutinize(url, "widget", "2.0.0")).To(Equal(exitAPI))
+			Expect(server.Projects).To(HaveLen(1))
+
+			viper.Set("team", "")
+			Expect(runScrutinize(url, "widget", "1.0.0")).To(Equal(exitInput))
+		})
+	})
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionize/client"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/replay"
+	"github.com/spf13/viper"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	maxIdleConns        = 25
+	maxIdleConnsPerHost = 25
+)
+
+var (
+	recordDir string
+	replayDir string
+
+	// transport is shared by the Ion Channel and dropbox clients, so a
+	// recording holds every request of the run in the order they were made
+	transport http.RoundTripper
+)
+
+func init() {
+	f := RootCmd.PersistentFlags()
+	f.StringVar(&recordDir, "record", "", "record every Ion Channel and dropbox request and response to fixture files in the directory, with secrets redacted")
+	f.StringVar(&replayDir, "replay", "", "serve the requests recorded with --record in the directory instead of making them")
+
+	policy := client.DefaultPolicy()
+	f.Int("retries", policy.Attempts, "how many times to make an Ion Channel request that fails for a reason that may pass")
+	f.Duration("retry-backoff", policy.Backoff, "how long to wait before retrying a failed Ion Channel request, doubling with each retry")
+	bindFlag("retry.attempts", RootCmd, "retries")
+	bindFlag("retry.backoff", RootCmd, "retry-backoff")
+
+	f.Bool("insecure-skip-verify", false, "do not verify the certificates of the Ion Channel API and the dropbox, which lets anyone on the network read and change what is sent, only for testing")
+	bindFlag("tls.insecure_skip_verify", RootCmd, "insecure-skip-verify")
+}
+
+// retryPolicy returns how failed Ion Channel requests are retried, logging
+// each retry
+func retryPolicy() client.Policy {
+	policy := client.DefaultPolicy()
+	if viper.IsSet("retry.attempts") {
+		policy.Attempts = viper.GetInt("retry.attempts")
+	}
+	if viper.IsSet("retry.backoff") {
+		policy.Backoff = viper.GetDuration("retry.backoff")
+	}
+	if viper.IsSet("retry.max_backoff") {
+		policy.MaxBackoff = viper.GetDuration("retry.max_backoff")
+	}
+	policy.Logf = logger.Warnf
+
+	return policy
+}
+
+// httpClient returns the client the Ion Channel API and the dropbox are
+// reached with, recording or replaying the requests when asked to
+func httpClient() (*http.Client, error) {
+	if transport == nil {
+		t, err := newTransport()
+		if err != nil {
+			return nil, err
+		}
+		transport = t
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func newTransport() (http.RoundTripper, error) {
+	if recordDir != "" && replayDir != "" {
+		return nil, fmt.Errorf("--record and --replay can not be used together")
+	}
+
+	if replayDir != "" {
+		p, err := replay.NewPlayer(replayDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay requests: %v", err.Error())
+		}
+		return p, nil
+	}
+
+	tlsCfg, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = maxIdleConns
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.Proxy = proxy()
+	t.TLSClientConfig = tlsCfg
+
+	if recordDir != "" {
+		r, err := replay.NewRecorder(recordDir, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record requests: %v", err.Error())
+		}
+		return r, nil
+	}
+
+	return t, nil
+}
+
+// proxy returns the proxy requests are sent through, taken from the
+// HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables unless the
+// proxy configs override them
+func proxy() func(*http.Request) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+	if viper.IsSet("proxy.https_proxy") {
+		cfg.HTTPSProxy = viper.GetString("proxy.https_proxy")
+	}
+	if viper.IsSet("proxy.http_proxy") {
+		cfg.HTTPProxy = viper.GetString("proxy.http_proxy")
+	}
+	if viper.IsSet("proxy.no_proxy") {
+		cfg.NoProxy = viper.GetString("proxy.no_proxy")
+	}
+
+	proxyURL := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyURL(req.URL)
+	}
+}
+
+// tlsConfig returns the TLS config for the API and the dropbox, trusting the
+// CA bundles in the tls configs along with the system's, presenting the
+// client certificate when one is configured, and not verifying certificates
+// at all when told to
+func tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	bundles := viper.GetStringSlice("tls.ca_files")
+	if len(bundles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, b := range bundles {
+			pem, err := ioutil.ReadFile(b)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %v", err.Error())
+			}
+
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("failed to read CA bundle %v: no certificates found", b)
+			}
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	cert, key := viper.GetString("tls.client_cert"), viper.GetString("tls.client_key")
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, fmt.Errorf("tls.client_cert and tls.client_key must be set together")
+		}
+
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err.Error())
+		}
+
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	if viper.GetBool("tls.insecure_skip_verify") {
+		cfg.InsecureSkipVerify = true
+		logger.Warnf("Not verifying the certificates of %v or the dropbox, anyone on the network can read and change what is sent", viper.GetString("api"))
+	}
+
+	return cfg, nil
+}
+
+// newClient creates an Ion Channel client for the configured API, retrying
+// requests that fail for a reason that may pass
+func newClient() (client.Client, error) {
+	c, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := retryPolicy()
+	c.Transport = client.NewTransport(c.Transport, policy)
+
+	cli, err := ionic.NewWithClient(viper.GetString("api"), c)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.WithRetries(cli, policy), nil
+}
+
+// loadDropboxConfig loads the dropbox config, reaching the dropbox with the
+// same client as the API
+func loadDropboxConfig() (*dropbox.Config, error) {
+	cfg, err := dropbox.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.HTTPClient, err = httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
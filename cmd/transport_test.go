@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionize/logging"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestTransport(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Transport", func() {
+		var dir string
+		var server *httptest.Server
+		var logs *bytes.Buffer
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-transport")
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}))
+			logs = &bytes.Buffer{}
+			logger, _ = logging.New(logs, logging.LevelInfo, logging.FormatText)
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+			initLogging()
+			transport = nil
+			viper.Reset()
+		})
+
+		// writeServerCA writes the certificate of the test server, which is its
+		// own CA, returning its path
+		writeServerCA := func() string {
+			path := filepath.Join(dir, "ca.pem")
+			ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+			return path
+		}
+
+		// writeClientCert writes a new self signed client certificate and its
+		// key, returning the certificate and their paths
+		writeClientCert := func() (*x509.Certificate, string, string) {
+			key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			template := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "ionize"},
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+				ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			Expect(err).To(BeNil())
+			cert, _ := x509.ParseCertificate(der)
+
+			keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+			certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+			ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+			ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+
+			return cert, certPath, keyPath
+		}
+
+		get := func() error {
+			transport = nil
+			c, err := httpClient()
+			if err != nil {
+				return err
+			}
+
+			resp, err := c.Get(server.URL)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			return nil
+		}
+
+		g.It("should send requests through the configured proxy", func() {
+			os.Setenv("HTTPS_PROXY", "http://env-proxy.example.com:3128")
+			defer os.Unsetenv("HTTPS_PROXY")
+
+			req, _ := http.NewRequest(http.MethodGet, "https://api.ionchannel.io/v1/users/getSelf", nil)
+			u, err := proxy()(req)
+			Expect(err).To(BeNil())
+			Expect(u.String()).To(Equal("http://env-proxy.example.com:3128"))
+
+			viper.Set("proxy.https_proxy", "http://proxy.example.com:8080")
+			u, err = proxy()(req)
+			Expect(err).To(BeNil())
+			Expect(u.String()).To(Equal("http://proxy.example.com:8080"))
+
+			viper.Set("proxy.no_proxy", ".ionchannel.io")
+			u, err = proxy()(req)
+			Expect(err).To(BeNil())
+			Expect(u).To(BeNil())
+		})
+
+		g.It("should trust the configured CA bundles", func() {
+			server.StartTLS()
+			Expect(get()).NotTo(BeNil())
+
+			viper.Set("tls.ca_files", []string{writeServerCA()})
+			Expect(get()).To(BeNil())
+
+			viper.Set("tls.ca_files", []string{filepath.Join(dir, "missing.pem")})
+			Expect(get()).NotTo(BeNil())
+
+			ioutil.WriteFile(filepath.Join(dir, "empty.pem"), []byte("not a certificate"), 0600)
+			viper.Set("tls.ca_files", []string{filepath.Join(dir, "empty.pem")})
+			Expect(get().Error()).To(ContainSubstring("no certificates found"))
+		})
+
+		g.It("should present the configured client certificate", func() {
+			cert, certPath, keyPath := writeClientCert()
+			pool := x509.NewCertPool()
+			pool.AddCert(cert)
+			server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+			server.StartTLS()
+
+			viper.Set("tls.ca_files", []string{writeServerCA()})
+			Expect(get()).NotTo(BeNil())
+
+			viper.Set("tls.client_cert", certPath)
+			Expect(get().Error()).To(ContainSubstring("must be set together"))
+
+			viper.Set("tls.client_key", keyPath)
+			Expect(get()).To(BeNil())
+		})
+
+		g.It("should warn when not verifying certificates", func() {
+			server.StartTLS()
+			viper.Set("tls.insecure_skip_verify", true)
+			Expect(get()).To(BeNil())
+			Expect(logs.String()).To(ContainSubstring("WARN  Not verifying the certificates"))
+		})
+
+		g.It("should keep what the log package logs when quiet", func() {
+			stderr := os.Stderr
+			os.Stderr, _ = os.Create(filepath.Join(dir, "stderr"))
+			quiet = true
+			defer func() {
+				os.Stderr.Close()
+				os.Stderr = stderr
+				quiet = false
+			}()
+
+			initLogging()
+			log.Printf("http: TLS handshake error from 127.0.0.1: EOF")
+
+			b, _ := ioutil.ReadFile(filepath.Join(dir, "stderr"))
+			Expect(string(b)).To(ContainSubstring("WARN  http: TLS handshake error from 127.0.0.1: EOF"))
+		})
+	})
+}
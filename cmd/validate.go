@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ion-channel/ionize/cmd/external"
+	"github.com/ion-channel/ionize/redact"
+	"github.com/ion-channel/ionize/runner"
+	"github.com/spf13/cobra"
+)
+
+var (
+	offline = false
+)
+
+func init() {
+	RootCmd.AddCommand(externalCmd)
+	externalCmd.AddCommand(externalValidateCmd)
+
+	externalValidateCmd.Flags().BoolVarP(&analyzeAll, "all", "", false, "validate the reports of every project listed in the config")
+	externalValidateCmd.Flags().StringVarP(&projectName, "project-name", "", "", "validate the reports of the project with this name from the projects listed in the config")
+	externalValidateCmd.Flags().StringVarP(&changedSince, "changed-since", "", "", "validate the reports of the listed projects with files changed on HEAD since the git ref")
+
+	analyzeCmd.Flags().BoolVarP(&offline, "offline", "", false, "validate the external reports instead of analyzing, without contacting the API")
+}
+
+var externalCmd = &cobra.Command{
+	Use:   "external",
+	Short: "Work with the external reports added to analyses.",
+	Long: `Work with the coverage, vulnerability, and Fortify FPR reports configured to be
+added to the analysis of each project.`,
+}
+
+var externalValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the external reports without sending them.",
+	Long: `Read every configured coverage, vulnerability, and Fortify FPR report and print
+the request that would add each to an analysis, with an empty analysis_id,
+along with its severity counts and any problems with it, such as counts that
+are negative or fields that are not part of the schema and would be dropped.
+Nothing is sent to the API or uploaded to the dropbox, so no key is needed,
+and the dropbox url of an FPR file is shown as a placeholder.  The reports of each project are also checked
+against the local policy.  The projects are chosen as they are for analyze.
+Exits 2 when any report can not be read or has problems, and 1 when the local
+policy fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runValidate())
+	},
+}
+
+// runValidate checks the external reports of the projects that would be
+// analyzed, and returns the code to exit with
+func runValidate() int {
+	targets, err := analysisTargets()
+	if err != nil {
+		return failed(&runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Failed to find the project to validate: %v", err.Error())})
+	}
+
+	p, err := loadPolicy()
+	if err != nil {
+		return failed(&runner.Error{Kind: runner.ErrInput, Err: fmt.Errorf("Failed to read the local policy: %v", err.Error())})
+	}
+
+	total, valid := 0, 0
+	policyFailures := []string{}
+	for _, t := range targets {
+		opts := t.options("")
+		scans := runner.CheckReports(&opts.Reports)
+		for _, s := range scans {
+			fmt.Fprintf(output, "==> %v %v %v\n", t.Name, s.Type, s.File)
+			if printScan(output, s, &external.AnalysisID{TeamID: opts.Team, ProjectID: opts.Project}) {
+				valid++
+			}
+			fmt.Fprintln(output)
+			total++
+		}
+
+		if p.Len() > 0 {
+			fmt.Fprintf(output, "==> %v policy\n", t.Name)
+			eval := runner.CheckPolicy(p, scans)
+			runner.PrintPolicy(output, eval)
+			fmt.Fprintln(output)
+			if !eval.Passed {
+				policyFailures = append(policyFailures, t.Name)
+			}
+		}
+	}
+
+	if total == 0 {
+		fmt.Fprintln(output, "No external reports are configured")
+	} else {
+		fmt.Fprintf(output, "%v of %v reports are valid\n", valid, total)
+	}
+	if len(policyFailures) > 0 {
+		fmt.Fprintf(output, "The local policy failed for %v\n", strings.Join(policyFailures, ", "))
+	}
+
+	switch {
+	case valid < total:
+		return exitInput
+	case len(policyFailures) > 0:
+		return exitFailed
+	}
+
+	return 0
+}
+
+// printScan prints the severity counts of the scan and the request that would
+// add it to an analysis of the project, or why its report could not be read,
+// followed by its problems, and reports whether it is valid
+func printScan(w io.Writer, s *runner.Scan, aID *external.AnalysisID) bool {
+	if s.Err != nil {
+		fmt.Fprintf(w, "Error: %v\n", redact.Error(s.Err))
+		return false
+	}
+
+	if v := s.Payload.Vulnerability; v != nil {
+		fmt.Fprintf(w, "Critical: %v  High: %v  Medium: %v  Low: %v\n", v.Critcal, v.High, v.Medium, v.Low)
+	}
+	if c := s.Payload.Coverage; c != nil {
+		fmt.Fprintf(w, "Coverage: %v\n", c.Value)
+	}
+
+	req, err := s.Request(aID)
+	if err != nil {
+		fmt.Fprintf(w, "Error: %v\n", redact.Error(err))
+		return false
+	}
+
+	b, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return false
+	}
+	fmt.Fprintln(w, string(b))
+
+	for _, p := range s.Problems {
+		fmt.Fprintf(w, "Problem: %v %v\n", s.File, p)
+	}
+
+	return len(s.Problems) == 0
+}
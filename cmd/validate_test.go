@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
+	"github.com/ion-channel/ionize/logging"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestValidate(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("External Validate Command", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-validate")
+			ioutil.WriteFile(filepath.Join(dir, "coverage.txt"), []byte("87.5\n"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "vulns.json"), []byte(`{"external_vulnerability":{"critical":1,"high":2,"low":-3}}`), 0644)
+
+			viper.SetConfigType("yaml")
+			viper.ReadConfig(bytes.NewReader([]byte("team: team-1\nproject: api-project\n" +
+				"coverage: " + filepath.Join(dir, "coverage.txt") + "\n" +
+				"vulnerabilities:\n  - " + filepath.Join(dir, "vulns.json") + "\n  - " + filepath.Join(dir, "missing.json") + "\n")))
+
+			output = penname.New()
+			logger, _ = logging.New(ioutil.Discard, logging.LevelInfo, logging.FormatText)
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+			initLogging()
+			viper.Reset()
+		})
+
+		written := func() string {
+			return string(output.(*penname.PenName).Written())
+		}
+
+		g.It("should print what would be sent and the problems with it", func() {
+			Expect(runValidate()).To(Equal(exitInput))
+
+			out := written()
+			Expect(out).To(ContainSubstring("==> api-project coverage " + filepath.Join(dir, "coverage.txt") + "\nCoverage: 87.5\n{\n  \"team_id\": \"team-1\",\n  \"project_id\": \"api-project\",\n  \"analysis_id\": \"\",\n  \"status\": \"\",\n  \"results\": {\n    \"coverage\": {\n      \"value\": 87.5\n    },\n    \"source\": {\n      \"name\": \"\",\n      \"url\": \"\"\n    },\n    \"notes\": \"\"\n  },\n  \"scan_type\": \"coverage\"\n}\n"))
+			Expect(out).To(ContainSubstring("Critical: 1  High: 2  Medium: 0  Low: -3\n"))
+			Expect(out).To(ContainSubstring("Problem: " + filepath.Join(dir, "vulns.json") + " external_vulnerability.low is negative\n"))
+			Expect(out).To(ContainSubstring("==> api-project vulnerability " + filepath.Join(dir, "missing.json") + "\nError: "))
+			Expect(out).To(HaveSuffix("1 of 3 reports are valid\n"))
+		})
+
+		g.It("should print the scan type a Fortify report is sent as", func() {
+			b, _ := ioutil.ReadFile(filepath.Join("..", "fortify.zip"))
+			ioutil.WriteFile(filepath.Join(dir, "fortify.zip"), b, 0644)
+			viper.Set("coverage", "")
+			viper.Set("vulnerabilities", []string{})
+			viper.Set("fortify", filepath.Join(dir, "fortify.zip"))
+
+			Expect(runValidate()).To(Equal(0))
+
+			out := written()
+			Expect(out).To(ContainSubstring("==> api-project fortify " + filepath.Join(dir, "fortify.zip") + "\n"))
+			Expect(out).To(ContainSubstring("  \"scan_type\": \"vulnerability\"\n}\n"))
+			Expect(out).NotTo(ContainSubstring("\"scan_type\": \"fortify\""))
+		})
+
+		g.It("should check the reports against the local policy", func() {
+			viper.Set("vulnerabilities", []string{})
+			viper.Set("policy.rules", []map[string]interface{}{{"name": "Enough coverage", "value": "coverage", "min": 90}})
+
+			Expect(runValidate()).To(Equal(exitFailed))
+			Expect(written()).To(HaveSuffix("==> api-project policy\n" +
+				"Enough coverage (coverage=87.5)...Rule Type: local policy...not passed...Risk:  high\n" +
+				"Local policy failed on a rule\n\n" +
+				"1 of 1 reports are valid\nThe local policy failed for api-project\n"))
+		})
+
+		g.It("should pass when every report is valid", func() {
+			viper.Set("vulnerabilities", []string{})
+			Expect(runValidate()).To(Equal(0))
+			Expect(written()).To(HaveSuffix("1 of 1 reports are valid\n"))
+
+			viper.Set("coverage", "")
+			output = penname.New()
+			Expect(runValidate()).To(Equal(0))
+			Expect(written()).To(Equal("No external reports are configured\n"))
+		})
+	})
+}
@@ -0,0 +1,486 @@
+// Package config loads the ionize configuration from its layered sources.
+// Each layer is a yaml file that may also hold named profiles, include other
+// files, and reference environment variables, and layers loaded later take
+// precedence over those loaded earlier.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ProfilesKey is the key holding the named profiles within a layer
+	ProfilesKey = "profiles"
+
+	// ProjectsKey is the key listing the projects of a repository holding
+	// more than one
+	ProjectsKey = "projects"
+
+	// IncludeKey is the key naming the files a layer includes, which are
+	// loaded before it so its own values take precedence over theirs
+	IncludeKey = "include"
+
+	// RepoFile is the name of the config file found in a repository
+	RepoFile = ".ionize.yaml"
+)
+
+// TrustedKeys are only read from trusted layers, as they name a file the key
+// is read from and a command run to print it, which anyone able to change a
+// repository could otherwise point wherever ionize runs on it
+var TrustedKeys = []string{"key_file", "key_command"}
+
+// Layer is a single source of configuration values
+type Layer struct {
+	Name   string
+	Path   string
+	Values map[string]interface{}
+
+	// Dir is what relative file paths in the layer are relative to, the
+	// working directory when empty
+	Dir string
+
+	// Profiles are the named profiles the layer defines, applied or not
+	Profiles map[string]interface{}
+
+	// Interpolated holds the dotted keys of the values that referenced
+	// environment variables
+	Interpolated map[string]bool
+
+	// Trusted is set for the system and user configs and their profiles,
+	// which only the people running ionize can change, unlike the repo
+	// config and the files any layer includes
+	Trusted bool
+}
+
+// String describes the layer and where it was read from
+func (l *Layer) String() string {
+	if l.Path == "" {
+		return l.Name
+	}
+
+	return fmt.Sprintf("%v (%v)", l.Name, l.Path)
+}
+
+// Options controls where the layers are loaded from and which profile is
+// applied
+type Options struct {
+	SystemPath string
+	UserPath   string
+	RepoPath   string
+
+	// RepoRequired fails loading when the repo layer can not be read, such
+	// as when the path was given explicitly
+	RepoRequired bool
+
+	// ResolveRepoPaths makes relative file paths in the repo layer relative
+	// to the file instead of the working directory, for files found in a
+	// parent directory
+	ResolveRepoPaths bool
+
+	Profile string
+}
+
+// Config holds every layer loaded and the values that result from merging
+// them
+type Config struct {
+	Profile string
+	Layers  []*Layer
+
+	// Values are the merged values of every layer
+	Values map[string]interface{}
+
+	// Sources maps each dotted key in Values to the layer that supplied it
+	Sources map[string]*Layer
+}
+
+// DefaultOptions returns the options for loading the system, user, and repo
+// config files from their default locations, looking for the repo file in
+// the working directory and its parents
+func DefaultOptions() *Options {
+	return &Options{
+		SystemPath:       filepath.Join(string(filepath.Separator), "etc", "ionize", "config.yaml"),
+		UserPath:         filepath.Join(UserDir(), "config.yaml"),
+		RepoPath:         FindRepoFile("."),
+		ResolveRepoPaths: true,
+	}
+}
+
+// FindRepoFile looks for the repo file in the dir and then each parent up to
+// the root of the repository, the first holding a .git entry, and returns
+// its path relative to the dir.  RepoFile is returned when none is found.
+func FindRepoFile(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return RepoFile
+	}
+
+	rel := ""
+	for {
+		if _, err := os.Stat(filepath.Join(abs, RepoFile)); err == nil {
+			return filepath.Join(rel, RepoFile)
+		}
+
+		if _, err := os.Stat(filepath.Join(abs, ".git")); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			break
+		}
+		abs = parent
+		rel = filepath.Join(rel, "..")
+	}
+
+	return RepoFile
+}
+
+// UserDir returns the directory ionize keeps per user configuration in
+func UserDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(".config", "ionize")
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "ionize")
+}
+
+// Load reads the system, user, and repo layers in that order, each preceded
+// by the files it includes and followed by its profile when it has it, and
+// merges them
+func Load(opts *Options) (*Config, error) {
+	c := &Config{
+		Profile: opts.Profile,
+		Values:  map[string]interface{}{},
+		Sources: map[string]*Layer{},
+	}
+
+	repoDir := ""
+	if opts.ResolveRepoPaths {
+		repoDir = filepath.Dir(opts.RepoPath)
+	}
+
+	files := []struct {
+		name     string
+		path     string
+		required bool
+		dir      string
+	}{
+		{"system", opts.SystemPath, false, filepath.Dir(opts.SystemPath)},
+		{"user", opts.UserPath, false, filepath.Dir(opts.UserPath)},
+		{"repo", opts.RepoPath, opts.RepoRequired, repoDir},
+	}
+
+	profileFound := false
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+
+		found, err := c.load(f.name, f.path, f.required, f.dir, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		profileFound = profileFound || found
+	}
+
+	if opts.Profile != "" && !profileFound {
+		return nil, fmt.Errorf("profile %v was not found in any config", opts.Profile)
+	}
+
+	return c, nil
+}
+
+// load reads the file and adds its layers, first those of the files it
+// includes, then its own, then its profile, and reports whether the profile
+// was found in it or the files it includes.  Included paths are relative to
+// the file including them, and unless the file's own paths are relative to
+// the working directory, the paths in an included file are relative to it.
+func (c *Config) load(name, path string, required bool, dir string, seen map[string]bool) (bool, error) {
+	values, interpolated, err := readFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed reading %v config: %v", name, err.Error())
+	}
+
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		if seen[abs] {
+			return false, fmt.Errorf("failed reading %v config: %v includes itself", name, path)
+		}
+		seen[abs] = true
+		defer delete(seen, abs)
+	}
+
+	includes, err := includePaths(values[IncludeKey])
+	if err != nil {
+		return false, fmt.Errorf("failed reading %v config: %v: %v", name, path, err.Error())
+	}
+	delete(values, IncludeKey)
+
+	profileFound := false
+	for _, include := range includes {
+		includeDir := ""
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if dir != "" {
+			includeDir = filepath.Dir(include)
+		}
+
+		found, err := c.load(fmt.Sprintf("%v include", name), include, true, includeDir, seen)
+		if err != nil {
+			return false, err
+		}
+		profileFound = profileFound || found
+	}
+
+	profiles, _ := values[ProfilesKey].(map[string]interface{})
+	delete(values, ProfilesKey)
+
+	trusted := name == "system" || name == "user"
+	resolvePaths(values, Schema, dir)
+	c.add(&Layer{Name: name, Path: path, Values: values, Profiles: profiles, Dir: dir, Interpolated: interpolated, Trusted: trusted})
+
+	if c.Profile == "" {
+		return profileFound, nil
+	}
+
+	if profile, ok := profiles[strings.ToLower(c.Profile)]; ok {
+		values, ok := profile.(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("profile %v in %v config is not a map", c.Profile, name)
+		}
+
+		resolvePaths(values, Schema, dir)
+		c.add(&Layer{Name: fmt.Sprintf("%v profile %v", name, c.Profile), Path: path, Values: values, Dir: dir, Interpolated: profileKeys(interpolated, c.Profile), Trusted: trusted})
+		profileFound = true
+	}
+
+	return profileFound, nil
+}
+
+// includePaths returns the paths an include value names, which may be a
+// single path or a list of them
+func includePaths(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		paths := []string{}
+		for _, p := range t {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v must be a list of paths", IncludeKey)
+			}
+			paths = append(paths, s)
+		}
+		return paths, nil
+	}
+
+	return nil, fmt.Errorf("%v must be a path or a list of paths", IncludeKey)
+}
+
+// Untrusted returns the layer the value of the key came from when it is not
+// a trusted one, nil when it is or the key is not set by any layer
+func (c *Config) Untrusted(key string) *Layer {
+	if l, ok := c.Sources[key]; ok && !l.Trusted {
+		return l
+	}
+
+	return nil
+}
+
+// Files returns the paths of every file a layer was read from
+func (c *Config) Files() []string {
+	files := []string{}
+	seen := map[string]bool{}
+	for _, l := range c.Layers {
+		if l.Path != "" && !seen[l.Path] {
+			seen[l.Path] = true
+			files = append(files, l.Path)
+		}
+	}
+
+	return files
+}
+
+// Keys returns the dotted keys of every merged value in sorted order
+func (c *Config) Keys() []string {
+	keys := []string{}
+	for k := range c.Sources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// YAML returns the merged values as a yaml document
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c.Values)
+}
+
+func (c *Config) add(l *Layer) {
+	c.Layers = append(c.Layers, l)
+	merge(c.Values, l.Values, "", l, c.Sources)
+}
+
+// merge copies the values from src into dst, descending into maps held by
+// both, and records the layer as the source of each leaf value copied
+func merge(dst, src map[string]interface{}, prefix string, l *Layer, sources map[string]*Layer) {
+	for k, v := range src {
+		key := prefix + k
+
+		if sm, ok := v.(map[string]interface{}); ok {
+			dm, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dm = map[string]interface{}{}
+				dst[k] = dm
+				clearSources(key, sources)
+			}
+			merge(dm, sm, key+".", l, sources)
+			continue
+		}
+
+		clearSources(key, sources)
+		dst[k] = v
+		sources[key] = l
+	}
+}
+
+func clearSources(key string, sources map[string]*Layer) {
+	for k := range sources {
+		if k == key || strings.HasPrefix(k, key+".") {
+			delete(sources, k)
+		}
+	}
+}
+
+// resolvePaths makes the relative file paths in the values relative to the
+// dir instead.  Files in a map holding a Base value are relative to that
+// directory, which is itself relative to the dir.
+func resolvePaths(values map[string]interface{}, fields map[string]*Field, dir string) {
+	for k, f := range fields {
+		if f.Base {
+			if base, ok := values[k].(string); ok {
+				values[k] = resolvePath(base, dir)
+				dir = values[k].(string)
+			}
+		}
+	}
+
+	for k, v := range values {
+		f, ok := fields[k]
+		if !ok || f.Base {
+			continue
+		}
+
+		switch {
+		case f.Kind == Map:
+			if m, ok := v.(map[string]interface{}); ok {
+				resolvePaths(m, f.Fields, dir)
+			}
+		case f.Kind == List && f.Items != nil && f.Items.Kind == Map:
+			if l, ok := v.([]interface{}); ok {
+				for _, item := range l {
+					if m, ok := item.(map[string]interface{}); ok {
+						resolvePaths(m, f.Items.Fields, dir)
+					}
+				}
+			}
+		case f.File || f.Glob:
+			switch t := v.(type) {
+			case string:
+				values[k] = resolvePath(t, dir)
+			case []interface{}:
+				for i := range t {
+					if s, ok := t[i].(string); ok {
+						t[i] = resolvePath(s, dir)
+					}
+				}
+			}
+		}
+	}
+}
+
+func resolvePath(path, dir string) string {
+	if dir == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// readFile reads the yaml file and interpolates the environment variables it
+// references, returning the dotted keys of the values that did
+func readFile(path string) (map[string]interface{}, map[string]bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var raw map[interface{}]interface{}
+	err = yaml.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", path, err.Error())
+	}
+
+	interpolated := map[string]bool{}
+	values, err := interpolate(normalize(raw), "", interpolated)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", path, err.Error())
+	}
+
+	return values.(map[string]interface{}), interpolated, nil
+}
+
+// profileKeys returns the interpolated keys within the profile, relative to
+// it
+func profileKeys(interpolated map[string]bool, profile string) map[string]bool {
+	prefix := ProfilesKey + "." + strings.ToLower(profile) + "."
+
+	keys := map[string]bool{}
+	for k := range interpolated {
+		if strings.HasPrefix(k, prefix) {
+			keys[strings.TrimPrefix(k, prefix)] = true
+		}
+	}
+
+	return keys
+}
+
+// normalize converts the maps yaml produces into string keyed maps with the
+// lower case keys viper expects
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, v := range t {
+			m[strings.ToLower(fmt.Sprintf("%v", k))] = normalize(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = normalize(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
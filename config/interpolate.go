@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate replaces the ${VAR} and ${VAR:-default} references in every
+// string the value holds, descending into maps and lists, and records the
+// dotted key of each value replaced in.  A string that is only a single
+// reference takes the type its replacement has in yaml, so
+// `retries: ${RETRIES:-3}` is still an int.
+func interpolate(v interface{}, key string, interpolated map[string]bool) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		prefix := ""
+		if key != "" {
+			prefix = key + "."
+		}
+
+		for k := range t {
+			value, err := interpolate(t[k], prefix+k, interpolated)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", k, err.Error())
+			}
+			t[k] = value
+		}
+		return t, nil
+
+	case []interface{}:
+		for i := range t {
+			value, err := interpolate(t[i], key, interpolated)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = value
+		}
+		return t, nil
+
+	case string:
+		s, err := expand(t, os.LookupEnv)
+		if err != nil || s == t {
+			return s, err
+		}
+		interpolated[key] = true
+
+		end := strings.Index(t, "}")
+		if !strings.HasPrefix(t, "${") || end != len(t)-1 {
+			return s, nil
+		}
+
+		var typed interface{}
+		if yaml.Unmarshal([]byte(s), &typed) == nil {
+			switch typed.(type) {
+			case bool, int, float64:
+				return typed, nil
+			}
+		}
+		return s, nil
+	}
+
+	return v, nil
+}
+
+// expand replaces the ${VAR} and ${VAR:-default} references in the string
+// with the values lookup finds.  The default is used when the variable is
+// unset or empty, an unset variable without one is replaced with nothing, and
+// $$ is a literal $.
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	b := strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case '{':
+		default:
+			b.WriteByte(s[i])
+			continue
+		}
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed variable reference in %q", s)
+		}
+
+		ref := s[i+2 : i+end]
+		name, def, hasDefault := ref, "", false
+		if n := strings.Index(ref, ":-"); n >= 0 {
+			name, def, hasDefault = ref[:n], ref[n+2:], true
+		}
+
+		if !variableName.MatchString(name) {
+			return "", fmt.Errorf("invalid variable reference ${%v}", ref)
+		}
+
+		value, _ := lookup(name)
+		if value == "" && hasDefault {
+			value = def
+		}
+		b.WriteString(value)
+
+		i += end
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"sort"
+)
+
+// Kind is the type of value a config key holds
+type Kind int
+
+const (
+	// String values are plain strings
+	String Kind = iota
+	// Bool values are true or false
+	Bool
+	// Int values are whole numbers
+	Int
+	// Float values are any number
+	Float
+	// Duration values are strings such as 15m or 2h
+	Duration
+	// StringList values are a string or a list of strings
+	StringList
+	// StringMap values are a map of strings to strings
+	StringMap
+	// Map values are a map with the keys described by Fields
+	Map
+	// List values are a list of the item described by Items
+	List
+)
+
+var kindNames = map[Kind]string{
+	String:     "a string",
+	Bool:       "true or false",
+	Int:        "a whole number",
+	Float:      "a number",
+	Duration:   "a duration such as 15m or 2h",
+	StringList: "a string or a list of strings",
+	StringMap:  "a map of strings",
+	Map:        "a map",
+	List:       "a list",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Field describes the value a config key may hold
+type Field struct {
+	Kind Kind
+
+	// UUID values must be formatted as a UUID
+	UUID bool
+	// File values must name files that exist
+	File bool
+	// Glob values are path patterns, relative like files but not required to
+	// match anything
+	Glob bool
+	// Values lists the values allowed, any value is allowed when empty
+	Values []string
+	// Secret values are credentials that are redacted when printed
+	Secret bool
+	// Base values are directories the other files in the same map are
+	// relative to
+	Base bool
+
+	// Fields describes the keys of a Map
+	Fields map[string]*Field
+	// Items describes the items of a List
+	Items *Field
+}
+
+// Schema describes every key supported in the config files
+var Schema = map[string]*Field{
+	"key":             {Kind: String, Secret: true},
+	"key_file":        {Kind: String, File: true},
+	"key_command":     {Kind: String},
+	"api":             {Kind: String},
+	"bucket":          {Kind: String},
+	"team":            {Kind: String, UUID: true},
+	"project":         {Kind: String, UUID: true},
+	"ruleset":         {Kind: String, UUID: true},
+	"coverage":        {Kind: String, File: true},
+	"vulnerabilities": {Kind: StringList, File: true},
+	"fortify":         {Kind: String, File: true},
+	"projects": {Kind: List, Items: &Field{Kind: Map, Fields: map[string]*Field{
+		"name":            {Kind: String},
+		"team":            {Kind: String, UUID: true},
+		"project":         {Kind: String, UUID: true},
+		"path":            {Kind: String, File: true, Base: true},
+		"paths":           {Kind: StringList, Glob: true},
+		"coverage":        {Kind: String, File: true},
+		"vulnerabilities": {Kind: StringList, File: true},
+		"fortify":         {Kind: String, File: true},
+	}}},
+	"policy": {Kind: Map, Fields: map[string]*Field{
+		"fail_fast": {Kind: Bool},
+		"rules": {Kind: List, Items: &Field{Kind: Map, Fields: map[string]*Field{
+			"name":  {Kind: String},
+			"expr":  {Kind: String},
+			"value": {Kind: String},
+			"min":   {Kind: Float},
+			"max":   {Kind: Float},
+		}}},
+	}},
+	"coverage_trend": {Kind: Map, Fields: map[string]*Field{
+		"enabled":     {Kind: Bool},
+		"base_branch": {Kind: String},
+		"max_drop":    {Kind: Float},
+		"cache":       {Kind: String},
+	}},
+	"proxy": {Kind: Map, Fields: map[string]*Field{
+		"http_proxy":  {Kind: String},
+		"https_proxy": {Kind: String},
+		"no_proxy":    {Kind: String},
+	}},
+	"tls": {Kind: Map, Fields: map[string]*Field{
+		"ca_files":             {Kind: StringList, File: true},
+		"client_cert":          {Kind: String, File: true},
+		"client_key":           {Kind: String, File: true},
+		"insecure_skip_verify": {Kind: Bool},
+	}},
+	"retry": {Kind: Map, Fields: map[string]*Field{
+		"attempts":    {Kind: Int},
+		"backoff":     {Kind: Duration},
+		"max_backoff": {Kind: Duration},
+	}},
+	"dropbox": {Kind: Map, Fields: map[string]*Field{
+		"backend":           {Kind: String, Values: []string{"s3", "http", "file"}},
+		"presign_ttl":       {Kind: Duration},
+		"retries":           {Kind: Int},
+		"bucket":            {Kind: String},
+		"endpoint":          {Kind: String},
+		"region":            {Kind: String},
+		"path_style":        {Kind: Bool},
+		"access_key_id":     {Kind: String, Secret: true},
+		"secret_access_key": {Kind: String, Secret: true},
+		"part_size_mb":      {Kind: Int},
+		"concurrency":       {Kind: Int},
+		"encryption":        {Kind: String, Values: []string{"AES256", "aws:kms"}},
+		"kms_key_id":        {Kind: String},
+		"url":               {Kind: String},
+		"token":             {Kind: String, Secret: true},
+		"dir":               {Kind: String},
+		"rehost":            {Kind: Bool},
+		"fetch": {Kind: Map, Fields: map[string]*Field{
+			"username":  {Kind: String},
+			"password":  {Kind: String, Secret: true},
+			"token":     {Kind: String, Secret: true},
+			"s3_region": {Kind: String},
+		}},
+		"retention": {Kind: Duration},
+		"tags":      {Kind: StringMap},
+	}},
+}
+
+// Secrets returns the dotted keys of every secret value in the schema
+func Secrets() []string {
+	return secrets(Schema, "")
+}
+
+func secrets(fields map[string]*Field, prefix string) []string {
+	keys := []string{}
+	for k, f := range fields {
+		if f.Secret {
+			keys = append(keys, prefix+k)
+		}
+		if f.Kind == Map {
+			keys = append(keys, secrets(f.Fields, prefix+k+".")...)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Required lists the keys that must be set for an analysis, unless projects
+// lists the projects to analyze instead
+var Required = []string{"team", "project"}
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Problem is an issue found while validating the config
+type Problem struct {
+	Key     string
+	Source  string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Source == "" {
+		return fmt.Sprintf("%v: %v", p.Key, p.Message)
+	}
+
+	return fmt.Sprintf("%v: %v: %v", p.Source, p.Key, p.Message)
+}
+
+// Validate checks every layer against the schema, including the profiles
+// each defines that were not applied, and checks that the merged values hold
+// the required keys. The problems found are returned in the order of the
+// layers.
+func (c *Config) Validate() []Problem {
+	problems := []Problem{}
+
+	for _, l := range c.Layers {
+		problems = append(problems, validateMap(l.Values, Schema, "", l.String())...)
+		problems = append(problems, validateTrust(l.Values, l.Trusted, l.String())...)
+
+		for _, name := range sortedKeys(l.Profiles) {
+			if strings.EqualFold(name, c.Profile) {
+				continue
+			}
+
+			source := fmt.Sprintf("%v profile %v", l.Name, name)
+			if l.Path != "" {
+				source = fmt.Sprintf("%v (%v)", source, l.Path)
+			}
+
+			values, ok := l.Profiles[name].(map[string]interface{})
+			if !ok {
+				problems = append(problems, Problem{Key: ProfilesKey + "." + name, Source: l.String(), Message: "must be a map"})
+				continue
+			}
+
+			problems = append(problems, validateMap(values, Schema, "", source)...)
+			problems = append(problems, validateTrust(values, l.Trusted, source)...)
+		}
+	}
+
+	if projects, ok := c.Values[ProjectsKey].([]interface{}); ok && len(projects) > 0 {
+		return append(problems, validateProjects(projects, isSet(c.Values, "team"))...)
+	}
+
+	for _, k := range Required {
+		if !isSet(c.Values, k) {
+			problems = append(problems, Problem{Key: k, Message: "is required but not set"})
+		}
+	}
+
+	return problems
+}
+
+// validateTrust checks that a layer that is not trusted does not set any of
+// the keys only read from trusted layers
+func validateTrust(values map[string]interface{}, trusted bool, source string) []Problem {
+	problems := []Problem{}
+	if trusted {
+		return problems
+	}
+
+	for _, k := range TrustedKeys {
+		if isSet(values, k) {
+			problems = append(problems, Problem{Key: k, Source: source, Message: "is only read from the system and user configs"})
+		}
+	}
+
+	return problems
+}
+
+// validateProjects checks that each project listed has a unique name, a
+// project id, and a team when there is not one for every project
+func validateProjects(projects []interface{}, team bool) []Problem {
+	problems := []Problem{}
+	names := map[string]bool{}
+
+	for i, p := range projects {
+		key := fmt.Sprintf("%v[%v]", ProjectsKey, i)
+
+		m, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if name, _ := m["name"].(string); name == "" {
+			problems = append(problems, Problem{Key: key + ".name", Message: "is required but not set"})
+		} else if names[name] {
+			problems = append(problems, Problem{Key: key + ".name", Message: fmt.Sprintf("%v is used by another project", name)})
+		} else {
+			names[name] = true
+		}
+
+		if !isSet(m, "project") {
+			problems = append(problems, Problem{Key: key + ".project", Message: "is required but not set"})
+		}
+
+		if !team && !isSet(m, "team") {
+			problems = append(problems, Problem{Key: key + ".team", Message: "is required when team is not set"})
+		}
+	}
+
+	return problems
+}
+
+func isSet(values map[string]interface{}, k string) bool {
+	v, ok := values[k]
+	return ok && v != nil && v != ""
+}
+
+func validateMap(values map[string]interface{}, fields map[string]*Field, prefix, source string) []Problem {
+	problems := []Problem{}
+
+	for _, k := range sortedKeys(values) {
+		key := prefix + k
+
+		field, ok := fields[k]
+		if !ok {
+			message := "is not a known key"
+			if s := suggest(k, fields); s != "" {
+				message = fmt.Sprintf("%v, did you mean %v?", message, prefix+s)
+			}
+			problems = append(problems, Problem{Key: key, Source: source, Message: message})
+			continue
+		}
+
+		problems = append(problems, validateValue(values[k], field, key, source)...)
+	}
+
+	return problems
+}
+
+func validateValue(v interface{}, field *Field, key, source string) []Problem {
+	problem := func(format string, a ...interface{}) []Problem {
+		return []Problem{{Key: key, Source: source, Message: fmt.Sprintf(format, a...)}}
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	switch field.Kind {
+	case String:
+		s, ok := v.(string)
+		if !ok {
+			return problem("must be %v", field.Kind)
+		}
+		return validateString(s, field, key, source)
+
+	case Bool:
+		if _, ok := v.(bool); !ok {
+			return problem("must be %v", field.Kind)
+		}
+
+	case Int:
+		if _, ok := v.(int); !ok {
+			return problem("must be %v", field.Kind)
+		}
+
+	case Float:
+		switch v.(type) {
+		case int, float64:
+		default:
+			return problem("must be %v", field.Kind)
+		}
+
+	case Duration:
+		s, ok := v.(string)
+		if !ok {
+			return problem("must be %v", field.Kind)
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			return problem("must be %v", field.Kind)
+		}
+
+	case StringList:
+		switch t := v.(type) {
+		case string:
+			return validateString(t, field, key, source)
+		case []interface{}:
+			problems := []Problem{}
+			for i, item := range t {
+				s, ok := item.(string)
+				if !ok {
+					return problem("must be %v", field.Kind)
+				}
+				problems = append(problems, validateString(s, field, fmt.Sprintf("%v[%v]", key, i), source)...)
+			}
+			return problems
+		default:
+			return problem("must be %v", field.Kind)
+		}
+
+	case StringMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return problem("must be %v", field.Kind)
+		}
+		for _, k := range sortedKeys(m) {
+			if _, ok := m[k].(string); !ok {
+				return problem("must be %v", field.Kind)
+			}
+		}
+
+	case Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return problem("must be %v", field.Kind)
+		}
+		return validateMap(m, field.Fields, key+".", source)
+
+	case List:
+		l, ok := v.([]interface{})
+		if !ok {
+			return problem("must be %v", field.Kind)
+		}
+		problems := []Problem{}
+		for i, item := range l {
+			problems = append(problems, validateValue(item, field.Items, fmt.Sprintf("%v[%v]", key, i), source)...)
+		}
+		return problems
+	}
+
+	return nil
+}
+
+func validateString(s string, field *Field, key, source string) []Problem {
+	problem := func(format string, a ...interface{}) []Problem {
+		return []Problem{{Key: key, Source: source, Message: fmt.Sprintf(format, a...)}}
+	}
+
+	if len(field.Values) > 0 {
+		allowed := false
+		for _, a := range field.Values {
+			allowed = allowed || a == s
+		}
+		if !allowed {
+			return problem("must be one of %v", strings.Join(field.Values, ", "))
+		}
+	}
+
+	if field.UUID && !uuidPattern.MatchString(s) {
+		return problem("%q is not a valid UUID", s)
+	}
+
+	if field.File {
+		if _, err := os.Stat(s); err != nil {
+			return problem("file %v does not exist", s)
+		}
+	}
+
+	if field.Glob {
+		for _, segment := range strings.Split(filepath.ToSlash(s), "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return problem("%q is not a valid pattern", s)
+			}
+		}
+	}
+
+	return nil
+}
+
+// suggest returns the known key closest to the unknown one, or nothing when
+// none are close, allowing longer keys more edits
+func suggest(unknown string, fields map[string]*Field) string {
+	best := ""
+	bestDistance := 2 + len(unknown)/5
+	for k := range fields {
+		d := distance(unknown, k)
+		if d < bestDistance || (d == bestDistance && (best == "" || k < best)) {
+			best = k
+			bestDistance = d
+		}
+	}
+
+	return best
+}
+
+// distance is the number of single character edits between two strings
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
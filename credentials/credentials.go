@@ -0,0 +1,253 @@
+// Package credentials stores the keys and tokens ionize authenticates with in
+// a file in the user config dir, and reads keys from the other sources ionize
+// supports such as secret mounts and credential helpers.  The file is only
+// protected at rest when it is encrypted with a passphrase, as the key it is
+// otherwise encrypted with is kept beside it.
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ion-channel/ionize/config"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// PassphraseEnv is the environment variable holding the passphrase the
+	// credentials file is encrypted with, a generated key kept beside the file
+	// is used when it is not set
+	PassphraseEnv = "IONIZE_CREDENTIALS_PASSPHRASE"
+
+	kdfKeyFile = "keyfile"
+	kdfPBKDF2  = "pbkdf2-sha256"
+
+	iterations = 100000
+	keyLength  = 32
+)
+
+// Entry holds the credentials for a single API
+type Entry struct {
+	Key      string    `json:"key,omitempty"`
+	Token    string    `json:"token,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+// Credentials holds the entries for each API, keyed by its url
+type Credentials struct {
+	Entries map[string]*Entry `json:"entries"`
+}
+
+// Get returns the entry for the API, or nil when there is none
+func (c *Credentials) Get(api string) *Entry {
+	return c.Entries[normalizeAPI(api)]
+}
+
+// Set replaces the entry for the API
+func (c *Credentials) Set(api string, e *Entry) {
+	c.Entries[normalizeAPI(api)] = e
+}
+
+// Delete removes the entry for the API
+func (c *Credentials) Delete(api string) {
+	delete(c.Entries, normalizeAPI(api))
+}
+
+func normalizeAPI(api string) string {
+	return strings.TrimRight(api, "/")
+}
+
+// File is an encrypted credentials file.  Without a passphrase it is encrypted
+// with a generated key kept in KeyPath, which keeps it from being read at a
+// glance but not from anyone able to read the user's files.
+type File struct {
+	Path string
+	// KeyPath is where the generated encryption key is kept when there is no
+	// passphrase
+	KeyPath string
+	// Passphrase the file is encrypted with instead of the generated key
+	Passphrase string
+}
+
+// DefaultFile returns the credentials file in the user config dir, using the
+// passphrase from the environment if there is one
+func DefaultFile() *File {
+	dir := config.UserDir()
+	return &File{
+		Path:       filepath.Join(dir, "credentials"),
+		KeyPath:    filepath.Join(dir, "credentials.key"),
+		Passphrase: os.Getenv(PassphraseEnv),
+	}
+}
+
+// Protected reports whether the file is encrypted with a passphrase, rather
+// than a key anyone able to read the file can also read
+func (f *File) Protected() bool {
+	return f.Passphrase != ""
+}
+
+// envelope is the encrypted form written to the file
+type envelope struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Load reads and decrypts the credentials, returning empty credentials when
+// the file does not exist
+func (f *File) Load() (*Credentials, error) {
+	c := &Credentials{Entries: map[string]*Entry{}}
+
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read credentials: %v", err.Error())
+	}
+
+	var env envelope
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials %v: %v", f.Path, err.Error())
+	}
+
+	key, err := f.key(&env, false)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := gcm.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials %v, check %v", f.Path, PassphraseEnv)
+	}
+
+	err = json.Unmarshal(plain, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials %v: %v", f.Path, err.Error())
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]*Entry{}
+	}
+
+	return c, nil
+}
+
+// Save encrypts and writes the credentials, readable only by the user
+func (f *File) Save(c *Credentials) error {
+	plain, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %v", err.Error())
+	}
+
+	env := &envelope{Version: 1, KDF: kdfKeyFile}
+	if f.Passphrase != "" {
+		env.KDF = kdfPBKDF2
+		env.Salt = make([]byte, 16)
+		_, err = io.ReadFull(rand.Reader, env.Salt)
+		if err != nil {
+			return fmt.Errorf("failed to generate salt: %v", err.Error())
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(f.Path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create credentials dir: %v", err.Error())
+	}
+
+	key, err := f.key(env, true)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	env.Nonce = make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, env.Nonce)
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err.Error())
+	}
+	env.Data = gcm.Seal(nil, env.Nonce, plain, nil)
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %v", err.Error())
+	}
+
+	err = ioutil.WriteFile(f.Path, b, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write credentials: %v", err.Error())
+	}
+
+	return nil
+}
+
+// key returns the encryption key for the envelope, generating the key file
+// when asked to and it does not exist
+func (f *File) key(env *envelope, generate bool) ([]byte, error) {
+	switch env.KDF {
+	case kdfPBKDF2:
+		if f.Passphrase == "" {
+			return nil, fmt.Errorf("credentials %v are protected by a passphrase, set %v", f.Path, PassphraseEnv)
+		}
+		return pbkdf2.Key([]byte(f.Passphrase), env.Salt, iterations, keyLength, sha256.New), nil
+
+	case kdfKeyFile:
+		key, err := ioutil.ReadFile(f.KeyPath)
+		if err == nil && len(key) == keyLength {
+			return key, nil
+		}
+		if !generate {
+			return nil, fmt.Errorf("failed to read credentials key %v, run ionize login again", f.KeyPath)
+		}
+
+		key = make([]byte, keyLength)
+		_, err = io.ReadFull(rand.Reader, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate credentials key: %v", err.Error())
+		}
+
+		err = ioutil.WriteFile(f.KeyPath, key, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write credentials key: %v", err.Error())
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported credentials encryption: %v", env.KDF)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err.Error())
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err.Error())
+	}
+
+	return gcm, nil
+}
@@ -0,0 +1,152 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestCredentials(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Credentials File", func() {
+		var dir string
+		var f *File
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-credentials")
+			f = &File{
+				Path:    filepath.Join(dir, "ionize", "credentials"),
+				KeyPath: filepath.Join(dir, "ionize", "credentials.key"),
+			}
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should load empty credentials when there is no file", func() {
+			c, err := f.Load()
+			Expect(err).To(BeNil())
+			Expect(c.Get("https://api.ionchannel.io")).To(BeNil())
+		})
+
+		g.It("should encrypt the credentials with a generated key", func() {
+			c, _ := f.Load()
+			expires := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			c.Set("https://api.ionchannel.io/", &Entry{Key: "supersecretapikey", Expires: expires})
+			Expect(f.Save(c)).To(BeNil())
+
+			b, _ := ioutil.ReadFile(f.Path)
+			Expect(string(b)).NotTo(ContainSubstring("supersecretapikey"))
+
+			info, _ := os.Stat(f.Path)
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			info, _ = os.Stat(f.KeyPath)
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			c, err := f.Load()
+			Expect(err).To(BeNil())
+			Expect(c.Get("https://api.ionchannel.io").Key).To(Equal("supersecretapikey"))
+			Expect(c.Get("https://api.ionchannel.io").Expires.Equal(expires)).To(BeTrue())
+
+			c.Delete("https://api.ionchannel.io")
+			Expect(c.Get("https://api.ionchannel.io")).To(BeNil())
+		})
+
+		g.It("should fail without the generated key", func() {
+			c, _ := f.Load()
+			c.Set("https://api.ionchannel.io", &Entry{Key: "supersecretapikey"})
+			f.Save(c)
+			os.Remove(f.KeyPath)
+
+			_, err := f.Load()
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should encrypt the credentials with a passphrase", func() {
+			f.Passphrase = "correct horse"
+			c, _ := f.Load()
+			c.Set("https://api.ionchannel.io", &Entry{Key: "supersecretapikey"})
+			Expect(f.Save(c)).To(BeNil())
+
+			_, err := os.Stat(f.KeyPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			c, err = f.Load()
+			Expect(err).To(BeNil())
+			Expect(c.Get("https://api.ionchannel.io").Key).To(Equal("supersecretapikey"))
+
+			f.Passphrase = "battery staple"
+			_, err = f.Load()
+			Expect(err).NotTo(BeNil())
+
+			f.Passphrase = ""
+			_, err = f.Load()
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring(PassphraseEnv))
+		})
+	})
+
+	g.Describe("Key Sources", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-credentials")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should read keys from files", func() {
+			path := filepath.Join(dir, "key")
+			ioutil.WriteFile(path, []byte("supersecretapikey\n"), 0600)
+
+			k, err := ReadKeyFile(path)
+			Expect(err).To(BeNil())
+			Expect(k).To(Equal("supersecretapikey"))
+
+			ioutil.WriteFile(path, []byte("\n"), 0600)
+			_, err = ReadKeyFile(path)
+			Expect(err).NotTo(BeNil())
+
+			_, err = ReadKeyFile(filepath.Join(dir, "missing"))
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should read keys from commands", func() {
+			k, err := RunKeyCommand("echo ' supersecretapikey '")
+			Expect(err).To(BeNil())
+			Expect(k).To(Equal("supersecretapikey"))
+
+			_, err = RunKeyCommand("exit 3")
+			Expect(err).NotTo(BeNil())
+
+			_, err = RunKeyCommand("true")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	g.Describe("Session Tokens", func() {
+		g.It("should read the expiry from the token", func() {
+			claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"someone","exp":1577934245}`))
+			Expect(TokenExpiry("eyJhbGciOiJIUzI1NiJ9." + claims + ".sig")).To(Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+			Expect(TokenExpiry("not a jwt").IsZero()).To(BeTrue())
+			Expect(TokenExpiry("a.!!!.c").IsZero()).To(BeTrue())
+		})
+
+		g.It("should prefer the key to the session token", func() {
+			Expect((&Entry{Key: "key", Token: "token"}).Secret()).To(Equal("key"))
+			Expect((&Entry{Token: "token"}).Secret()).To(Equal("token"))
+		})
+	})
+}
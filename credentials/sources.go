@@ -0,0 +1,56 @@
+package credentials
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ReadKeyFile reads a key from a file, such as a Docker or Kubernetes secret
+// mount, ignoring surrounding whitespace
+func ReadKeyFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %v", err.Error())
+	}
+
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", fmt.Errorf("key file %v is empty", path)
+	}
+
+	return key, nil
+}
+
+// RunKeyCommand runs a credential helper through the shell and returns what
+// it prints to stdout, ignoring surrounding whitespace.  What the helper
+// prints to stderr is passed through so it can prompt the user.
+func RunKeyCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("key command failed: %v", err.Error())
+	}
+
+	key := strings.TrimSpace(stdout.String())
+	if key == "" {
+		return "", fmt.Errorf("key command printed nothing")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,42 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Secret returns what to authenticate with, the key when there is one and
+// otherwise the session token
+func (e *Entry) Secret() string {
+	if e.Key != "" {
+		return e.Key
+	}
+
+	return e.Token
+}
+
+// TokenExpiry returns when a session token expires, read from the exp claim
+// of the JWT without verifying it, or the zero time when it can not be read
+func TokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(b, &claims)
+	if err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0).UTC()
+}
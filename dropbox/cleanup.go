@@ -0,0 +1,94 @@
+package dropbox
+
+import (
+	"fmt"
+	"time"
+)
+
+//CleanupOptions controls which uploaded objects Cleanup removes
+type CleanupOptions struct {
+	//Retention removes objects last modified longer ago than the retention,
+	//regardless of their tags.  Zero disables the check.
+	Retention time.Duration
+	//Finished reports whether the analysis an object was tagged with has
+	//finished.  Objects of finished analyses are removed, and an analysis
+	//that no longer exists should be reported as finished.  Nil disables the
+	//check.
+	Finished func(tags map[string]string) (bool, error)
+	//Logf is told about each object skipped because its tags or analysis
+	//could not be read, nothing is logged when it is nil
+	Logf func(format string, v ...interface{})
+	//DryRun reports the objects that would be removed without removing them
+	DryRun bool
+	//Now is the time expiry and retention are compared against
+	Now time.Time
+}
+
+//Cleanup removes the objects ionize uploaded that have expired, are older
+//than the retention, or belong to a finished analysis.  Objects whose tags or
+//analysis can not be read are logged and left for a later run, so one of
+//them does not hold up the rest.  It returns the keys of the objects removed.
+func Cleanup(store Lifecycle, opts CleanupOptions) ([]string, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	objects, err := store.List(Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	removed := []string{}
+	for _, o := range objects {
+		remove, err := shouldRemove(store, o, opts)
+		if err != nil {
+			if opts.Logf != nil {
+				opts.Logf("Skipping %v: %v", o.Key, err.Error())
+			}
+			continue
+		}
+
+		if !remove {
+			continue
+		}
+
+		if !opts.DryRun {
+			err = store.Delete(o.Key)
+			if err != nil {
+				return removed, fmt.Errorf("failed to delete %v: %w", o.Key, err)
+			}
+		}
+
+		removed = append(removed, o.Key)
+	}
+
+	return removed, nil
+}
+
+func shouldRemove(store Lifecycle, o ObjectInfo, opts CleanupOptions) (bool, error) {
+	if opts.Retention > 0 && o.LastModified.Add(opts.Retention).Before(opts.Now) {
+		return true, nil
+	}
+
+	tags, err := store.Tags(o.Key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read tags of %v: %w", o.Key, err)
+	}
+
+	if expires, ok := tags[ExpiresTag]; ok {
+		t, err := time.Parse(time.RFC3339, expires)
+		if err == nil && t.Before(opts.Now) {
+			return true, nil
+		}
+	}
+
+	if opts.Finished != nil && tags[AnalysisTag] != "" {
+		finished, err := opts.Finished(tags)
+		if err != nil {
+			return false, fmt.Errorf("failed to check analysis of %v: %w", o.Key, err)
+		}
+		return finished, nil
+	}
+
+	return false, nil
+}
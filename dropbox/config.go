@@ -0,0 +1,122 @@
+package dropbox
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	//BackendS3 stores artifacts in AWS S3 or any S3 compatible service
+	BackendS3 = "s3"
+	//BackendHTTP stores artifacts with a PUT to urls presigned by a server
+	BackendHTTP = "http"
+	//BackendFile stores artifacts in a directory on the local filesystem
+	BackendFile = "file"
+)
+
+const (
+	//FetchUsernameEnv names the environment variable holding the username
+	//used when fetching artifacts for re-hosting
+	FetchUsernameEnv = "IONIZE_FETCH_USERNAME"
+	//FetchPasswordEnv names the environment variable holding the password
+	//used when fetching artifacts for re-hosting
+	FetchPasswordEnv = "IONIZE_FETCH_PASSWORD"
+	//FetchTokenEnv names the environment variable holding the bearer token
+	//used when fetching artifacts for re-hosting
+	FetchTokenEnv = "IONIZE_FETCH_TOKEN"
+)
+
+//Config contains the settings for selecting and connecting to a storage
+//backend
+type Config struct {
+	Backend    string        `mapstructure:"backend"`
+	PresignTTL time.Duration `mapstructure:"presign_ttl"`
+	Retries    int           `mapstructure:"retries"`
+
+	// re-hosting of remote artifacts
+	Rehost bool        `mapstructure:"rehost"`
+	Fetch  FetchConfig `mapstructure:"fetch"`
+
+	// lifecycle of uploaded objects
+	Retention time.Duration     `mapstructure:"retention"`
+	Tags      map[string]string `mapstructure:"tags"`
+
+	// s3 backend
+	Bucket          string `mapstructure:"bucket"`
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	PathStyle       bool   `mapstructure:"path_style"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	PartSizeMB      int64  `mapstructure:"part_size_mb"`
+	Concurrency     int    `mapstructure:"concurrency"`
+	Encryption      string `mapstructure:"encryption"`
+	KMSKeyID        string `mapstructure:"kms_key_id"`
+
+	// http backend
+	URL   string `mapstructure:"url"`
+	Token string `mapstructure:"token"`
+
+	// file backend
+	Dir string `mapstructure:"dir"`
+
+	//HTTPClient makes the requests to the store and for fetching artifacts,
+	//http.DefaultClient is used when it is nil
+	HTTPClient *http.Client `mapstructure:"-"`
+}
+
+//LoadConfig reads the dropbox section of the loaded configs.  The top level
+//bucket setting is used when the section does not name a bucket.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{}
+	if viper.IsSet("dropbox") {
+		err := viper.UnmarshalKey("dropbox", cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dropbox config: %v", err.Error())
+		}
+	}
+
+	if cfg.Backend == "" {
+		cfg.Backend = BackendS3
+	}
+
+	if cfg.Bucket == "" {
+		cfg.Bucket = viper.GetString("bucket")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.Fetch.Username == "" {
+		cfg.Fetch.Username = os.Getenv(FetchUsernameEnv)
+	}
+
+	if cfg.Fetch.Password == "" {
+		cfg.Fetch.Password = os.Getenv(FetchPasswordEnv)
+	}
+
+	if cfg.Fetch.Token == "" {
+		cfg.Fetch.Token = os.Getenv(FetchTokenEnv)
+	}
+
+	return cfg, nil
+}
+
+//NewStore creates the store for the backend named in the config
+func NewStore(cfg *Config) (Store, error) {
+	switch cfg.Backend {
+	case BackendS3, "":
+		return NewS3Store(cfg)
+	case BackendHTTP:
+		return NewHTTPStore(cfg)
+	case BackendFile:
+		return NewFileStore(cfg.Dir)
+	default:
+		return nil, fmt.Errorf("unknown dropbox backend: %v", cfg.Backend)
+	}
+}
@@ -2,24 +2,77 @@ package dropbox
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"io"
-	"net/url"
-	"os"
-	"strings"
+	"path"
+	"path/filepath"
 	"time"
+)
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-
-	"github.com/spf13/viper"
+const (
+	//DigestMetadataKey is the metadata key the sha256 digest of an uploaded
+	//artifact is stored under
+	DigestMetadataKey = "sha256"
+
+	//TeamTag is the tag holding the team an upload belongs to
+	TeamTag = "ionize-team"
+	//ProjectTag is the tag holding the project an upload belongs to
+	ProjectTag = "ionize-project"
+	//AnalysisTag is the tag holding the analysis an upload was made for
+	AnalysisTag = "ionize-analysis-id"
+	//ExpiresTag is the tag holding the RFC 3339 time after which an upload
+	//may be deleted
+	ExpiresTag = "ionize-expires"
+
+	//Prefix is the prefix of every key ionize uploads under
+	Prefix = "ionize/"
 )
 
-//Uploader s3 uploader global for mocking
-var Uploader *s3manager.Uploader
+//PutOptions holds the metadata and tags an object is stored with
+type PutOptions struct {
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+//ObjectInfo describes an object held by a store
+type ObjectInfo struct {
+	Key          string
+	LastModified time.Time
+}
+
+//Store is a storage backend artifacts are uploaded to so they can be fetched
+//by the analyzer
+type Store interface {
+	//Put writes the contents of body to the store under key, along with the
+	//metadata and tags provided
+	Put(key string, body io.Reader, opts *PutOptions) error
+	//Exists reports whether an object is already stored under key
+	Exists(key string) (bool, error)
+	//URL returns a url the analyzer can use to download the object at key,
+	//valid for at least the given ttl
+	URL(key string, ttl time.Duration) (string, error)
+}
+
+//Lifecycle is implemented by stores that can list, tag, and delete the
+//objects ionize has uploaded
+type Lifecycle interface {
+	//List returns the objects with keys beginning with prefix
+	List(prefix string) ([]ObjectInfo, error)
+	//Tags returns the tags of the object at key
+	Tags(key string) (map[string]string, error)
+	//SetTags replaces the tags of the object at key
+	SetTags(key string, tags map[string]string) error
+	//Delete removes the object at key
+	Delete(key string) error
+}
+
+//Artifact describes a file that has been made available to the analyzer
+type Artifact struct {
+	URL    string
+	Key    string
+	Digest string
+}
 
 //Randomizer generate a random UUID for dropbox entries
 func Randomizer() (string, error) {
@@ -34,56 +87,19 @@ func Randomizer() (string, error) {
 	return hex.EncodeToString(uuid[:]), nil
 }
 
-//ParseURL takes a potential url.  Based on scheme will either upload to a
-//bucket and return a http url or just return the url
-func ParseURL(input, randomizer string) (string, error) {
-	url, err := url.Parse(input)
+//Digest streams the reader and returns the hex encoded sha256 of its contents
+func Digest(r io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse url: %v", err.Error())
-	}
-
-	if randomizer != "" {
-		randomizer = strings.Join([]string{randomizer, "/"}, "")
+		return "", err
 	}
 
-	// It is a local file.  upload to a bucket
-	// and create a timed url for downloading after analysis
-	if url.Scheme == "" || url.Scheme == "file" {
-
-		f, err := os.Open(url.Hostname() + url.EscapedPath())
-		if err != nil {
-			return "", fmt.Errorf("failed to read file for url (%s): %v", url.String(), err.Error())
-		}
-
-		sess, _ := session.NewSession(&aws.Config{
-			Region: aws.String("us-east-1"),
-		})
-
-		if Uploader == nil {
-			Uploader = s3manager.NewUploader(sess)
-		}
-
-		_, err = Uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(viper.GetString("bucket")),
-			Key:    aws.String("ionize/" + randomizer + f.Name()),
-			Body:   f,
-		})
-		if err != nil {
-			return "", fmt.Errorf("failed to write file to Ion Channel: %v", err.Error())
-		}
-
-		svc := s3.New(sess)
-		req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
-			Bucket: aws.String(viper.GetString("bucket")),
-			Key:    aws.String("ionize/" + randomizer + f.Name()),
-		})
-		erl, err := req.Presign(15 * time.Minute)
-		if err != nil {
-			return "", fmt.Errorf("Failed to sign request: %v", err)
-		}
-
-		return erl, nil
-	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	return url.String(), nil
+//Key returns the content addressed key an artifact with the given digest and
+//file name is stored under
+func Key(digest, name string) string {
+	return path.Join(Prefix, "sha256", digest, filepath.Base(name))
 }
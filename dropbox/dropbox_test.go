@@ -1,12 +1,45 @@
 package dropbox
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/franela/goblin"
+	"github.com/gomicro/penname"
 	. "github.com/onsi/gomega"
 )
 
+type countingStore struct {
+	*FileStore
+	puts     int
+	failures []error
+}
+
+func (s *countingStore) Put(key string, body io.Reader, opts *PutOptions) error {
+	s.puts++
+	if len(s.failures) > 0 {
+		err := s.failures[0]
+		s.failures = s.failures[1:]
+		return err
+	}
+	return s.FileStore.Put(key, body, opts)
+}
+
 func TestDropbox(t *testing.T) {
 	g := goblin.Goblin(t)
 	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
@@ -18,4 +51,521 @@ func TestDropbox(t *testing.T) {
 			Expect(len(rando)).To(Equal(32))
 		})
 	})
+
+	g.Describe("Stores", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-dropbox")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should create the store for the configured backend", func() {
+			s, err := NewStore(&Config{Backend: BackendFile, Dir: dir})
+			Expect(err).To(BeNil())
+			Expect(s).To(BeAssignableToTypeOf(&FileStore{}))
+
+			s, err = NewStore(&Config{Backend: BackendHTTP, URL: "http://localhost"})
+			Expect(err).To(BeNil())
+			Expect(s).To(BeAssignableToTypeOf(&HTTPStore{}))
+
+			s, err = NewStore(&Config{Backend: BackendS3, Region: "us-east-1", Endpoint: "http://localhost:9000", PathStyle: true})
+			Expect(err).To(BeNil())
+			Expect(s).To(BeAssignableToTypeOf(&S3Store{}))
+
+			_, err = NewStore(&Config{Backend: "ftp"})
+			Expect(err).NotTo(BeNil())
+
+			_, err = NewStore(&Config{Backend: BackendS3, Region: "us-east-1", Encryption: "rot13"})
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should write files to a local directory", func() {
+			s, _ := NewFileStore(dir)
+
+			exists, err := s.Exists("ionize/abc/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeFalse())
+
+			err = s.Put("ionize/abc/artifact.txt", strings.NewReader("contents"), &PutOptions{Metadata: map[string]string{"sha256": "abc"}})
+			Expect(err).To(BeNil())
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, "ionize", "abc", "artifact.txt"))
+			Expect(err).To(BeNil())
+			Expect(string(b)).To(Equal("contents"))
+
+			exists, err = s.Exists("ionize/abc/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+
+			metadata, err := s.Metadata("ionize/abc/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(metadata).To(Equal(map[string]string{"sha256": "abc"}))
+
+			erl, err := s.URL("ionize/abc/artifact.txt", time.Minute)
+			Expect(err).To(BeNil())
+			Expect(erl).To(Equal("file://" + filepath.ToSlash(filepath.Join(dir, "ionize", "abc", "artifact.txt"))))
+		})
+
+		g.It("should tag, list, and delete files in a local directory", func() {
+			s, _ := NewFileStore(dir)
+			s.Put("ionize/abc/artifact.txt", strings.NewReader("contents"), &PutOptions{Tags: map[string]string{TeamTag: "someteam"}})
+			s.Put("other/artifact.txt", strings.NewReader("contents"), nil)
+
+			objects, err := s.List(Prefix)
+			Expect(err).To(BeNil())
+			Expect(len(objects)).To(Equal(1))
+			Expect(objects[0].Key).To(Equal("ionize/abc/artifact.txt"))
+
+			err = s.SetTags("ionize/abc/artifact.txt", map[string]string{AnalysisTag: "someanalysis"})
+			Expect(err).To(BeNil())
+
+			tags, err := s.Tags("ionize/abc/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(tags).To(Equal(map[string]string{AnalysisTag: "someanalysis"}))
+
+			err = s.Delete("ionize/abc/artifact.txt")
+			Expect(err).To(BeNil())
+
+			objects, _ = s.List(Prefix)
+			Expect(len(objects)).To(Equal(0))
+		})
+
+		g.It("should not write files outside of the local directory", func() {
+			s, _ := NewFileStore(dir)
+
+			err := s.Put("../escape.txt", strings.NewReader("contents"), nil)
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should put to a url presigned by a server", func() {
+			var uploaded, digest string
+			var presigned []presignRequest
+
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodPost:
+					Expect(r.Header.Get("Authorization")).To(Equal("Bearer sometoken"))
+					var pr presignRequest
+					json.NewDecoder(r.Body).Decode(&pr)
+					presigned = append(presigned, pr)
+					headers := map[string]string{}
+					for k, v := range pr.Metadata {
+						headers["X-Amz-Meta-"+k] = v
+					}
+					json.NewEncoder(w).Encode(presignResponse{URL: server.URL + "/objects/" + pr.Key + "?sig=abc", Headers: headers})
+				case http.MethodHead:
+					if uploaded == "" {
+						w.WriteHeader(http.StatusNotFound)
+					}
+				case http.MethodPut:
+					Expect(r.ContentLength).To(Equal(int64(8)))
+					b, _ := ioutil.ReadAll(r.Body)
+					uploaded = string(b)
+					digest = r.Header.Get("X-Amz-Meta-sha256")
+				}
+			}))
+			defer server.Close()
+
+			s, _ := NewHTTPStore(&Config{URL: server.URL + "/presign", Token: "sometoken"})
+
+			exists, err := s.Exists("ionize/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeFalse())
+
+			err = s.Put("ionize/artifact.txt", strings.NewReader("contents"), &PutOptions{Metadata: map[string]string{"sha256": "abc"}})
+			Expect(err).To(BeNil())
+			Expect(uploaded).To(Equal("contents"))
+			Expect(digest).To(Equal("abc"))
+
+			exists, err = s.Exists("ionize/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+
+			erl, err := s.URL("ionize/artifact.txt", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(erl).To(Equal(server.URL + "/objects/ionize/artifact.txt?sig=abc"))
+
+			Expect(len(presigned)).To(Equal(4))
+			Expect(presigned[1].Method).To(Equal(http.MethodPut))
+			Expect(presigned[1].Metadata).To(Equal(map[string]string{"sha256": "abc"}))
+			Expect(presigned[3].Method).To(Equal(http.MethodGet))
+			Expect(presigned[3].ExpiresIn).To(Equal(int64(3600)))
+		})
+
+		g.It("should upload to an s3 compatible endpoint", func() {
+			var path, digest, tagging, encryption, kmsKey string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodHead:
+					if path == "" {
+						w.WriteHeader(http.StatusNotFound)
+					}
+				case http.MethodPut:
+					path = r.URL.Path
+					digest = r.Header.Get("X-Amz-Meta-Sha256")
+					tagging = r.Header.Get("X-Amz-Tagging")
+					encryption = r.Header.Get("X-Amz-Server-Side-Encryption")
+					kmsKey = r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+				}
+			}))
+			defer server.Close()
+
+			s, err := NewS3Store(&Config{
+				Bucket:          "somebucket",
+				Endpoint:        server.URL,
+				Region:          "us-west-2",
+				PathStyle:       true,
+				AccessKeyID:     "id",
+				SecretAccessKey: "secret",
+				KMSKeyID:        "somekey",
+			})
+			Expect(err).To(BeNil())
+
+			exists, err := s.Exists("ionize/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeFalse())
+
+			err = s.Put("ionize/artifact.txt", strings.NewReader("contents"), &PutOptions{
+				Metadata: map[string]string{"sha256": "abc"},
+				Tags:     map[string]string{TeamTag: "someteam"},
+			})
+			Expect(err).To(BeNil())
+			Expect(path).To(Equal("/somebucket/ionize/artifact.txt"))
+			Expect(digest).To(Equal("abc"))
+			Expect(tagging).To(Equal("ionize-team=someteam"))
+			Expect(encryption).To(Equal("aws:kms"))
+			Expect(kmsKey).To(Equal("somekey"))
+
+			exists, err = s.Exists("ionize/artifact.txt")
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+
+			erl, err := s.URL("ionize/artifact.txt", time.Minute)
+			Expect(err).To(BeNil())
+			Expect(erl).To(HavePrefix(server.URL + "/somebucket/ionize/artifact.txt?"))
+		})
+	})
+
+	g.Describe("Parse URL", func() {
+		// sha256 of "contents"
+		digest := "d1b2a59fbea7e20077af9f91b27e95e865061b270be03ff539ab3b73587882e8"
+
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-dropbox")
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should upload local files to the store by digest", func() {
+			path := filepath.Join(dir, "artifact.txt")
+			ioutil.WriteFile(path, []byte("contents"), 0644)
+
+			s, _ := NewFileStore(filepath.Join(dir, "store"))
+			artifact, err := NewUploaderWithStore(s).ParseURL(path)
+			Expect(err).To(BeNil())
+			Expect(artifact.Digest).To(Equal(digest))
+			Expect(artifact.Key).To(Equal("ionize/sha256/" + digest + "/artifact.txt"))
+			Expect(artifact.URL).To(HavePrefix("file://"))
+			Expect(artifact.URL).To(HaveSuffix("/ionize/sha256/" + digest + "/artifact.txt"))
+
+			metadata, err := s.Metadata(artifact.Key)
+			Expect(err).To(BeNil())
+			Expect(metadata[DigestMetadataKey]).To(Equal(digest))
+		})
+
+		g.It("should skip uploads of content already in the store", func() {
+			path := filepath.Join(dir, "artifact.txt")
+			ioutil.WriteFile(path, []byte("contents"), 0644)
+
+			s := &countingStore{}
+			s.FileStore, _ = NewFileStore(filepath.Join(dir, "store"))
+			u := NewUploaderWithStore(s)
+
+			first, err := u.ParseURL(path)
+			Expect(err).To(BeNil())
+
+			second, err := u.ParseURL(path)
+			Expect(err).To(BeNil())
+			Expect(second).To(Equal(first))
+			Expect(s.puts).To(Equal(1))
+		})
+
+		g.It("should pass remote urls through", func() {
+			s, _ := NewFileStore(dir)
+			artifact, err := NewUploaderWithStore(s).ParseURL("https://example.com/artifact.tgz")
+			Expect(err).To(BeNil())
+			Expect(artifact.URL).To(Equal("https://example.com/artifact.tgz"))
+			Expect(artifact.Digest).To(Equal(""))
+		})
+	})
+	g.Describe("Uploader", func() {
+		var dir, path string
+		var s *countingStore
+		var u *Uploader
+		var sleeps []time.Duration
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-dropbox")
+			path = filepath.Join(dir, "artifact.txt")
+			ioutil.WriteFile(path, []byte("contents"), 0644)
+
+			s = &countingStore{}
+			s.FileStore, _ = NewFileStore(filepath.Join(dir, "store"))
+
+			sleeps = nil
+			u = NewUploaderWithStore(s)
+			u.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should retry transient failures with backoff", func() {
+			s.failures = []error{
+				&StatusError{Op: "upload", StatusCode: 503, Status: "503 Service Unavailable"},
+				&StatusError{Op: "upload", StatusCode: 500, Status: "500 Internal Server Error"},
+			}
+
+			artifact, err := u.ParseURL(path)
+			Expect(err).To(BeNil())
+			Expect(artifact.Digest).NotTo(Equal(""))
+			Expect(s.puts).To(Equal(3))
+			Expect(sleeps).To(Equal([]time.Duration{time.Second, 2 * time.Second}))
+		})
+
+		g.It("should give up after the configured retries", func() {
+			u.Retries = 1
+			s.failures = []error{
+				&StatusError{Op: "upload", StatusCode: 503, Status: "503 Service Unavailable"},
+				&StatusError{Op: "upload", StatusCode: 503, Status: "503 Service Unavailable"},
+			}
+
+			_, err := u.ParseURL(path)
+			Expect(errors.Is(err, ErrNetwork)).To(BeTrue())
+			Expect(s.puts).To(Equal(2))
+		})
+
+		g.It("should not retry credential or bucket failures", func() {
+			s.failures = []error{
+				&StatusError{Op: "upload", StatusCode: 403, Status: "403 Forbidden"},
+			}
+
+			_, err := u.ParseURL(path)
+			Expect(errors.Is(err, ErrCredentials)).To(BeTrue())
+			Expect(s.puts).To(Equal(1))
+
+			s.failures = []error{
+				awserr.NewRequestFailure(awserr.New("NoSuchBucket", "The specified bucket does not exist", nil), 404, "id"),
+			}
+
+			_, err = u.ParseURL(path)
+			Expect(errors.Is(err, ErrBucket)).To(BeTrue())
+			Expect(s.puts).To(Equal(2))
+		})
+
+		g.It("should report upload progress", func() {
+			mw := penname.New()
+			u.Progress = mw
+
+			_, err := u.ParseURL(path)
+			Expect(err).To(BeNil())
+			Expect(string(mw.Written())).To(Equal("Uploading artifact.txt: 100% (8 of 8 bytes)\n"))
+
+			_, err = u.ParseURL(path)
+			Expect(err).To(BeNil())
+			Expect(string(mw.Written())).To(HaveSuffix("artifact.txt already uploaded, skipping\n"))
+		})
+
+		g.It("should tag uploads and refresh the tags of existing uploads", func() {
+			u.Tags[TeamTag] = "someteam"
+			u.Tags[AnalysisTag] = "first"
+			u.Retention = time.Hour
+
+			artifact, err := u.ParseURL(path)
+			Expect(err).To(BeNil())
+
+			tags, _ := s.Tags(artifact.Key)
+			Expect(tags[TeamTag]).To(Equal("someteam"))
+			Expect(tags[AnalysisTag]).To(Equal("first"))
+			expires, err := time.Parse(time.RFC3339, tags[ExpiresTag])
+			Expect(err).To(BeNil())
+			Expect(expires).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+			u.Tags[AnalysisTag] = "second"
+			_, err = u.ParseURL(path)
+			Expect(err).To(BeNil())
+			Expect(s.puts).To(Equal(1))
+
+			tags, _ = s.Tags(artifact.Key)
+			Expect(tags[AnalysisTag]).To(Equal("second"))
+
+			err = u.AddTags(artifact.Key, map[string]string{ProjectTag: "someproject"})
+			Expect(err).To(BeNil())
+
+			tags, _ = s.Tags(artifact.Key)
+			Expect(tags[ProjectTag]).To(Equal("someproject"))
+			Expect(tags[TeamTag]).To(Equal("someteam"))
+		})
+
+		g.It("should upload artifacts read from stdin", func() {
+			u.Stdin = strings.NewReader("contents")
+
+			artifact, err := u.ParseURL("-")
+			Expect(err).To(BeNil())
+			Expect(artifact.Key).To(HaveSuffix("/stdin"))
+			Expect(artifact.Digest).To(Equal("d1b2a59fbea7e20077af9f91b27e95e865061b270be03ff539ab3b73587882e8"))
+			Expect(s.puts).To(Equal(1))
+		})
+
+		g.It("should re-host http artifacts when asked to", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user, pass, ok := r.BasicAuth()
+				if !ok || user != "someuser" || pass != "somepass" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte("contents"))
+			}))
+			defer server.Close()
+
+			artifact, err := u.ParseURL(server.URL + "/repo/artifact.jar")
+			Expect(err).To(BeNil())
+			Expect(artifact.URL).To(Equal(server.URL + "/repo/artifact.jar"))
+			Expect(s.puts).To(Equal(0))
+
+			u.Rehost = true
+			_, err = u.ParseURL(server.URL + "/repo/artifact.jar")
+			Expect(err).NotTo(BeNil())
+
+			u.Fetcher.Username = "someuser"
+			u.Fetcher.Password = "somepass"
+			u.Fetcher.Token = "sometoken"
+			artifact, err = u.ParseURL(server.URL + "/repo/artifact.jar")
+			Expect(err).To(BeNil())
+			Expect(artifact.Key).To(HaveSuffix("/artifact.jar"))
+			Expect(artifact.URL).To(HavePrefix("file://"))
+			Expect(s.puts).To(Equal(1))
+		})
+
+		g.It("should re-host gs and s3 artifacts", func() {
+			var requested []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, r.URL.Path)
+				if strings.HasPrefix(r.URL.Path, "/somebucket/") {
+					Expect(r.Header.Get("Authorization")).To(Equal("Bearer sometoken"))
+				}
+				w.Header().Set("Content-Length", "8")
+				w.Write([]byte("contents"))
+			}))
+			defer server.Close()
+
+			u.Rehost = true
+			u.Fetcher.Token = "sometoken"
+			u.Fetcher.gsBaseURL = server.URL
+
+			artifact, err := u.ParseURL("gs://somebucket/path/artifact.tgz")
+			Expect(err).To(BeNil())
+			Expect(artifact.Key).To(HaveSuffix("/artifact.tgz"))
+
+			sess, _ := session.NewSession(&aws.Config{
+				Region:           aws.String("us-east-1"),
+				Endpoint:         aws.String(server.URL),
+				S3ForcePathStyle: aws.Bool(true),
+				Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+			})
+			u.Fetcher.Token = ""
+			u.Fetcher.S3 = s3.New(sess)
+
+			artifact, err = u.ParseURL("s3://otherbucket/path/artifact.zip")
+			Expect(err).To(BeNil())
+			Expect(artifact.Key).To(HaveSuffix("/artifact.zip"))
+			Expect(requested).To(Equal([]string{"/somebucket/path/artifact.tgz", "/otherbucket/path/artifact.zip"}))
+		})
+
+		g.It("should use the configured presign ttl", func() {
+			cfg := &Config{Backend: BackendFile, Dir: dir, PresignTTL: 2 * time.Hour, Retries: 5}
+			u, err := NewUploader(cfg)
+			Expect(err).To(BeNil())
+			Expect(u.PresignTTL).To(Equal(2 * time.Hour))
+			Expect(u.Retries).To(Equal(5))
+			Expect(u.Progress).To(BeNil())
+		})
+	})
+	g.Describe("Cleanup", func() {
+		var dir string
+		var s *FileStore
+		now := time.Now()
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-dropbox")
+			s, _ = NewFileStore(dir)
+
+			s.Put("ionize/expired", strings.NewReader("a"), &PutOptions{Tags: map[string]string{ExpiresTag: now.Add(-time.Hour).Format(time.RFC3339)}})
+			s.Put("ionize/current", strings.NewReader("b"), &PutOptions{Tags: map[string]string{ExpiresTag: now.Add(time.Hour).Format(time.RFC3339)}})
+			s.Put("ionize/finished", strings.NewReader("c"), &PutOptions{Tags: map[string]string{AnalysisTag: "done"}})
+			s.Put("ionize/running", strings.NewReader("d"), &PutOptions{Tags: map[string]string{AnalysisTag: "running"}})
+			s.Put("other/expired", strings.NewReader("e"), &PutOptions{Tags: map[string]string{ExpiresTag: now.Add(-time.Hour).Format(time.RFC3339)}})
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		finished := func(tags map[string]string) (bool, error) {
+			return tags[AnalysisTag] == "done", nil
+		}
+
+		g.It("should remove expired uploads and those of finished analyses", func() {
+			removed, err := Cleanup(s, CleanupOptions{Now: now, Finished: finished})
+			Expect(err).To(BeNil())
+			Expect(removed).To(ConsistOf("ionize/expired", "ionize/finished"))
+
+			objects, _ := s.List("")
+			Expect(len(objects)).To(Equal(3))
+		})
+
+		g.It("should remove uploads older than the retention", func() {
+			removed, err := Cleanup(s, CleanupOptions{Now: now.Add(48 * time.Hour), Retention: 24 * time.Hour})
+			Expect(err).To(BeNil())
+			Expect(removed).To(ConsistOf("ionize/expired", "ionize/current", "ionize/finished", "ionize/running"))
+		})
+
+		g.It("should skip uploads whose analysis can not be checked", func() {
+			logged := []string{}
+			removed, err := Cleanup(s, CleanupOptions{
+				Now: now,
+				Finished: func(tags map[string]string) (bool, error) {
+					if tags[AnalysisTag] == "running" {
+						return false, errors.New("api unavailable")
+					}
+					return finished(tags)
+				},
+				Logf: func(format string, v ...interface{}) {
+					logged = append(logged, fmt.Sprintf(format, v...))
+				},
+			})
+			Expect(err).To(BeNil())
+			Expect(removed).To(ConsistOf("ionize/expired", "ionize/finished"))
+			Expect(logged).To(Equal([]string{"Skipping ionize/running: failed to check analysis of ionize/running: api unavailable"}))
+		})
+
+		g.It("should not remove anything on a dry run", func() {
+			removed, err := Cleanup(s, CleanupOptions{Now: now, Finished: finished, DryRun: true})
+			Expect(err).To(BeNil())
+			Expect(removed).To(ConsistOf("ionize/expired", "ionize/finished"))
+
+			objects, _ := s.List("")
+			Expect(len(objects)).To(Equal(5))
+		})
+	})
 }
@@ -0,0 +1,132 @@
+package dropbox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var (
+	//ErrCredentials is returned when the dropbox credentials are missing or
+	//are rejected
+	ErrCredentials = errors.New("dropbox credentials are missing or were rejected")
+	//ErrBucket is returned when the dropbox bucket does not exist or can not
+	//be accessed
+	ErrBucket = errors.New("dropbox bucket does not exist or is not accessible")
+	//ErrNetwork is returned when the dropbox could not be reached, or it
+	//failed in a way that may succeed on retry
+	ErrNetwork = errors.New("dropbox could not be reached")
+)
+
+//StatusError is returned by stores when a request is answered with an
+//unexpected HTTP status
+type StatusError struct {
+	Op         string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v rejected with status %v", e.Op, e.Status)
+}
+
+var (
+	credentialCodes = map[string]bool{
+		"NoCredentialProviders": true,
+		"InvalidAccessKeyId":    true,
+		"SignatureDoesNotMatch": true,
+		"ExpiredToken":          true,
+		"InvalidToken":          true,
+		"AccessDenied":          true,
+	}
+	bucketCodes = map[string]bool{
+		"NoSuchBucket":                 true,
+		"AllAccessDisabled":            true,
+		"PermanentRedirect":            true,
+		"AuthorizationHeaderMalformed": true,
+	}
+	networkCodes = map[string]bool{
+		"RequestError":       true,
+		"RequestTimeout":     true,
+		"SlowDown":           true,
+		"InternalError":      true,
+		"ServiceUnavailable": true,
+	}
+)
+
+//classify wraps the error with ErrCredentials, ErrBucket, or ErrNetwork when
+//its cause can be determined
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	kind := kindOf(err)
+	if kind == nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %v", kind, err.Error())
+}
+
+func kindOf(err error) error {
+	for err != nil {
+		switch e := err.(type) {
+		case *StatusError:
+			return kindOfStatus(e.StatusCode)
+		case awserr.RequestFailure:
+			if kind := kindOfCode(e.Code()); kind != nil {
+				return kind
+			}
+			if kind := kindOfStatus(e.StatusCode()); kind != nil {
+				return kind
+			}
+			err = e.OrigErr()
+		case awserr.Error:
+			if kind := kindOfCode(e.Code()); kind != nil {
+				return kind
+			}
+			err = e.OrigErr()
+		case net.Error:
+			return ErrNetwork
+		default:
+			err = errors.Unwrap(err)
+		}
+	}
+
+	return nil
+}
+
+func kindOfCode(code string) error {
+	switch {
+	case credentialCodes[code]:
+		return ErrCredentials
+	case bucketCodes[code]:
+		return ErrBucket
+	case networkCodes[code]:
+		return ErrNetwork
+	}
+
+	return nil
+}
+
+func kindOfStatus(status int) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrCredentials
+	case status == http.StatusNotFound:
+		return ErrBucket
+	case status == http.StatusTooManyRequests || status >= 500:
+		return ErrNetwork
+	}
+
+	return nil
+}
+
+//transient reports whether the error may succeed if the request is retried
+func transient(err error) bool {
+	return errors.Is(err, ErrNetwork)
+}
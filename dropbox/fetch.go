@@ -0,0 +1,151 @@
+package dropbox
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	gsBaseURL = "https://storage.googleapis.com"
+)
+
+//FetchConfig contains the credentials used to fetch remote artifacts so they
+//can be re-hosted in the dropbox
+type FetchConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Token    string `mapstructure:"token"`
+	S3Region string `mapstructure:"s3_region"`
+}
+
+//Fetcher downloads artifacts from http(s), s3, and gs urls
+type Fetcher struct {
+	//Username and Password are sent as basic auth with http(s) requests
+	Username string
+	Password string
+	//Token is sent as a bearer token with gs requests, and with http(s)
+	//requests when there is no Username, since only one Authorization header
+	//can be sent
+	Token string
+	//S3Region is the region looked in first when locating s3 buckets
+	S3Region string
+
+	//Client is used for http(s) and gs requests, and s3 requests when S3 is
+	//nil
+	Client *http.Client
+	//S3 is used for s3 requests, a client for the bucket's region using the
+	//default credential chain is created when it is nil
+	S3 s3iface.S3API
+
+	gsBaseURL string
+}
+
+//NewFetcher creates a fetcher with the credentials in the config
+func NewFetcher(cfg *FetchConfig) *Fetcher {
+	return &Fetcher{
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Token:     cfg.Token,
+		S3Region:  cfg.S3Region,
+		Client:    http.DefaultClient,
+		gsBaseURL: gsBaseURL,
+	}
+}
+
+//Supports reports whether the fetcher can download from the url's scheme
+func (f *Fetcher) Supports(u *url.URL) bool {
+	switch u.Scheme {
+	case "http", "https", "s3", "gs":
+		return true
+	}
+
+	return false
+}
+
+//Fetch downloads the artifact at the url into the file
+func (f *Fetcher) Fetch(u *url.URL, w *os.File) error {
+	switch u.Scheme {
+	case "http", "https":
+		return f.fetchHTTP(u.String(), w, true)
+	case "gs":
+		erl := strings.Join([]string{f.gsBaseURL, u.Host, strings.TrimPrefix(u.EscapedPath(), "/")}, "/")
+		return f.fetchHTTP(erl, w, false)
+	case "s3":
+		return f.fetchS3(u.Host, strings.TrimPrefix(u.Path, "/"), w)
+	}
+
+	return fmt.Errorf("unsupported scheme for fetching: %v", u.Scheme)
+}
+
+func (f *Fetcher) fetchHTTP(erl string, w io.Writer, basic bool) error {
+	req, err := http.NewRequest(http.MethodGet, erl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err.Error())
+	}
+
+	switch {
+	case basic && f.Username != "":
+		req.SetBasicAuth(f.Username, f.Password)
+	case f.Token != "":
+		req.Header.Set("Authorization", "Bearer "+f.Token)
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch artifact: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch artifact: %w", &StatusError{Op: "download", StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read artifact: %w", err)
+	}
+
+	return nil
+}
+
+func (f *Fetcher) fetchS3(bucket, key string, w io.WriterAt) error {
+	svc := f.S3
+	if svc == nil {
+		sess, err := session.NewSession(aws.NewConfig().WithHTTPClient(f.Client))
+		if err != nil {
+			return fmt.Errorf("failed to create s3 session: %v", err.Error())
+		}
+
+		region := f.S3Region
+		if region == "" {
+			region = "us-east-1"
+		}
+
+		region, err = s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucket, region)
+		if err != nil {
+			return fmt.Errorf("failed to locate bucket %v: %w", bucket, classify(err))
+		}
+
+		svc = s3.New(sess, aws.NewConfig().WithRegion(region))
+	}
+
+	_, err := s3manager.NewDownloaderWithClient(svc).Download(w, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch artifact: %w", classify(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package dropbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	metadataSuffix = ".meta.json"
+)
+
+//FileStore writes artifacts to a directory on the local filesystem.  It is
+//intended for tests and air-gapped setups where the analyzer shares the
+//filesystem.
+type FileStore struct {
+	dir string
+}
+
+//NewFileStore creates a store rooted at the given directory
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("a directory is required for the file dropbox backend")
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve dropbox directory: %v", err.Error())
+	}
+
+	return &FileStore{dir: abs}, nil
+}
+
+//Put copies the body into the directory under the key.  Metadata and tags
+//are written alongside it in a json file with the same name and a .meta.json
+//suffix.
+func (s *FileStore) Put(key string, body io.Reader, opts *PutOptions) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, body)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+
+	return s.writeOptions(path, opts)
+}
+
+//Exists checks for the object in the directory
+func (s *FileStore) Exists(key string) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//Metadata returns the metadata stored with the object at key
+func (s *FileStore) Metadata(key string) (map[string]string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := s.readOptions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return opts.Metadata, nil
+}
+
+//List returns the objects in the directory with keys beginning with prefix
+func (s *FileStore) List(prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || strings.HasSuffix(path, metadataSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, LastModified: info.ModTime()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+//Tags returns the tags of the object at key
+func (s *FileStore) Tags(key string) (map[string]string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := s.readOptions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return opts.Tags, nil
+}
+
+//SetTags replaces the tags of the object at key
+func (s *FileStore) SetTags(key string, tags map[string]string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	opts, err := s.readOptions(path)
+	if err != nil {
+		return err
+	}
+
+	opts.Tags = tags
+	return s.writeOptions(path, opts)
+}
+
+//Delete removes the object at key and its metadata
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path + metadataSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) readOptions(path string) (*PutOptions, error) {
+	opts := &PutOptions{}
+	b, err := ioutil.ReadFile(path + metadataSuffix)
+	if os.IsNotExist(err) {
+		return opts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+func (s *FileStore) writeOptions(path string, opts *PutOptions) error {
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path+metadataSuffix, b, 0644)
+}
+
+//URL returns a file url for the object at key.  The ttl is ignored.
+func (s *FileStore) URL(key string, ttl time.Duration) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	return "file://" + filepath.ToSlash(path), nil
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, s.dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s: illegal key", key)
+	}
+
+	return path, nil
+}
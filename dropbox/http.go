@@ -0,0 +1,199 @@
+package dropbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+//HTTPStore writes artifacts with a plain HTTP(S) PUT.  The upload and
+//download urls are presigned by a server the store asks for each request.
+type HTTPStore struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+type presignRequest struct {
+	Key       string            `json:"key"`
+	Method    string            `json:"method"`
+	ExpiresIn int64             `json:"expires_in"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+type presignResponse struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+//NewHTTPStore creates a store that requests presigned urls from the url in
+//the config
+func NewHTTPStore(cfg *Config) (*HTTPStore, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("a url is required for the http dropbox backend")
+	}
+
+	client := http.DefaultClient
+	if cfg.HTTPClient != nil {
+		client = cfg.HTTPClient
+	}
+
+	return &HTTPStore{
+		url:    cfg.URL,
+		token:  cfg.Token,
+		client: client,
+	}, nil
+}
+
+//Put uploads the body to a url presigned for the key.  The metadata and tags
+//are sent to the server when presigning, and any headers it requires are sent
+//with the upload.
+func (s *HTTPStore) Put(key string, body io.Reader, opts *PutOptions) error {
+	presign := &presignRequest{
+		Key:       key,
+		Method:    http.MethodPut,
+		ExpiresIn: int64(15 * time.Minute / time.Second),
+	}
+	if opts != nil {
+		presign.Metadata = opts.Metadata
+		presign.Tags = opts.Tags
+	}
+
+	pr, err := s.presign(presign)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, pr.URL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %v", err.Error())
+	}
+
+	for k, v := range pr.Headers {
+		req.Header.Set(k, v)
+	}
+
+	// presigned urls generally reject chunked uploads, so give the request a
+	// length when the body can tell us one
+	if seeker, ok := body.(io.Seeker); ok {
+		size, err := seeker.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to determine upload size: %v", err.Error())
+		}
+		req.ContentLength = size
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Op: "upload", StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+//Exists checks for the object with a HEAD request to a url presigned for the
+//key
+func (s *HTTPStore) Exists(key string) (bool, error) {
+	pr, err := s.presign(&presignRequest{
+		Key:       key,
+		Method:    http.MethodHead,
+		ExpiresIn: int64(time.Minute / time.Second),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodHead, pr.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create head request: %v", err.Error())
+	}
+
+	for k, v := range pr.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for object: %w", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return true, nil
+	default:
+		return false, &StatusError{Op: "head request", StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+}
+
+//URL returns a url presigned by the server for downloading the key
+func (s *HTTPStore) URL(key string, ttl time.Duration) (string, error) {
+	pr, err := s.presign(&presignRequest{
+		Key:       key,
+		Method:    http.MethodGet,
+		ExpiresIn: int64(ttl / time.Second),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return pr.URL, nil
+}
+
+func (s *HTTPStore) presign(presign *presignRequest) (*presignResponse, error) {
+	b, err := json.Marshal(presign)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal presign request: %v", err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create presign request: %v", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request presigned url: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presign response: %v", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", &StatusError{Op: "presign request", StatusCode: resp.StatusCode, Status: resp.Status}, body)
+	}
+
+	var pr presignResponse
+	err = json.Unmarshal(body, &pr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse presign response: %v", err.Error())
+	}
+
+	if pr.URL == "" {
+		return nil, fmt.Errorf("presign response did not contain a url")
+	}
+
+	return &pr, nil
+}
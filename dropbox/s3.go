@@ -0,0 +1,222 @@
+package dropbox
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+//S3Store writes artifacts to a bucket in AWS S3 or an S3 compatible service
+//such as MinIO or Ceph
+type S3Store struct {
+	bucket   string
+	client   s3iface.S3API
+	uploader *s3manager.Uploader
+
+	//Encryption is the server side encryption uploads are stored with,
+	//either AES256 or aws:kms
+	Encryption string
+	//KMSKeyID is the KMS key used when encrypting with aws:kms, the
+	//bucket's default key is used when it is empty
+	KMSKeyID string
+}
+
+//NewS3Store creates a store for the bucket, endpoint, and region in the config
+func NewS3Store(cfg *Config) (*S3Store, error) {
+	awsCfg := &aws.Config{
+		Region: aws.String(cfg.Region),
+	}
+
+	if cfg.Endpoint != "" {
+		awsCfg.Endpoint = aws.String(cfg.Endpoint)
+	}
+
+	if cfg.PathStyle {
+		awsCfg.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	if cfg.HTTPClient != nil {
+		awsCfg.HTTPClient = cfg.HTTPClient
+	}
+
+	if cfg.AccessKeyID != "" {
+		awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 session: %v", err.Error())
+	}
+
+	store := NewS3StoreWithClient(cfg.Bucket, s3.New(sess), func(u *s3manager.Uploader) {
+		if cfg.PartSizeMB > 0 {
+			u.PartSize = cfg.PartSizeMB * 1024 * 1024
+		}
+		if cfg.Concurrency > 0 {
+			u.Concurrency = cfg.Concurrency
+		}
+	})
+
+	switch cfg.Encryption {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+		store.Encryption = cfg.Encryption
+	default:
+		return nil, fmt.Errorf("unsupported dropbox encryption: %v", cfg.Encryption)
+	}
+	store.KMSKeyID = cfg.KMSKeyID
+
+	if store.KMSKeyID != "" && store.Encryption == "" {
+		store.Encryption = s3.ServerSideEncryptionAwsKms
+	}
+
+	return store, nil
+}
+
+//NewS3StoreWithClient creates a store for the bucket using an existing client.
+//The options are applied to the multipart uploader.
+func NewS3StoreWithClient(bucket string, client s3iface.S3API, options ...func(*s3manager.Uploader)) *S3Store {
+	return &S3Store{
+		bucket:   bucket,
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client, options...),
+	}
+}
+
+//Put uploads the body to the bucket under the key, storing the metadata and
+//tags with the object and encrypting it when configured to
+func (s *S3Store) Put(key string, body io.Reader, opts *PutOptions) error {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+
+	if opts != nil {
+		input.Metadata = aws.StringMap(opts.Metadata)
+		if len(opts.Tags) > 0 {
+			input.Tagging = aws.String(encodeTags(opts.Tags))
+		}
+	}
+
+	if s.Encryption != "" {
+		input.ServerSideEncryption = aws.String(s.Encryption)
+	}
+
+	if s.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.KMSKeyID)
+	}
+
+	_, err := s.uploader.Upload(input)
+	return err
+}
+
+//Exists checks for the object with a HEAD request
+func (s *S3Store) Exists(key string) (bool, error) {
+	_, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if rf, ok := err.(awserr.RequestFailure); ok && rf.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+//URL returns a presigned url for downloading the object at key
+func (s *S3Store) URL(key string, ttl time.Duration) (string, error) {
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return req.Presign(ttl)
+}
+
+//List returns the objects in the bucket with keys beginning with prefix
+func (s *S3Store) List(prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.StringValue(o.Key),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	return objects, nil
+}
+
+//Tags returns the tags of the object at key
+func (s *S3Store) Tags(key string) (map[string]string, error) {
+	out, err := s.client.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	tags := map[string]string{}
+	for _, t := range out.TagSet {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+
+	return tags, nil
+}
+
+//SetTags replaces the tags of the object at key
+func (s *S3Store) SetTags(key string, tags map[string]string) error {
+	set := []*s3.Tag{}
+	for k, v := range tags {
+		set = append(set, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err := s.client.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: set},
+	})
+
+	return classify(err)
+}
+
+//Delete removes the object at key from the bucket
+func (s *S3Store) Delete(key string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return classify(err)
+}
+
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+
+	return values.Encode()
+}
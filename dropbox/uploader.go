@@ -0,0 +1,339 @@
+package dropbox
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultPresignTTL = 15 * time.Minute
+	defaultRetries    = 3
+	defaultBackoff    = time.Second
+)
+
+//Uploader makes local files available to the analyzer by uploading them to a
+//store
+type Uploader struct {
+	Store      Store
+	PresignTTL time.Duration
+	Retries    int
+	Backoff    time.Duration
+
+	//Tags are stored with each upload, along with an expiry tag when a
+	//retention is set
+	Tags      map[string]string
+	Retention time.Duration
+
+	//Rehost fetches http(s), s3, and gs artifacts with the fetcher and
+	//uploads them to the store, rather than passing their urls through to
+	//the analyzer
+	Rehost  bool
+	Fetcher *Fetcher
+
+	//Stdin is read for the artifact when the input is -
+	Stdin io.Reader
+
+	//Progress receives upload progress messages, nothing is reported when it
+	//is nil
+	Progress io.Writer
+
+	sleep func(time.Duration)
+}
+
+//NewUploader creates an uploader for the store and settings in the config
+func NewUploader(cfg *Config) (*Uploader, error) {
+	store, err := NewStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	u := NewUploaderWithStore(store)
+	if cfg.PresignTTL > 0 {
+		u.PresignTTL = cfg.PresignTTL
+	}
+	if cfg.Retries > 0 {
+		u.Retries = cfg.Retries
+	}
+	for k, v := range cfg.Tags {
+		u.Tags[k] = v
+	}
+	u.Retention = cfg.Retention
+	u.Rehost = cfg.Rehost
+	u.Fetcher = NewFetcher(&cfg.Fetch)
+	if cfg.HTTPClient != nil {
+		u.Fetcher.Client = cfg.HTTPClient
+	}
+
+	return u, nil
+}
+
+//NewUploaderWithStore creates an uploader for an existing store using the
+//default settings
+func NewUploaderWithStore(store Store) *Uploader {
+	return &Uploader{
+		Store:      store,
+		PresignTTL: defaultPresignTTL,
+		Retries:    defaultRetries,
+		Backoff:    defaultBackoff,
+		Tags:       map[string]string{},
+		Fetcher:    NewFetcher(&FetchConfig{}),
+		Stdin:      os.Stdin,
+		sleep:      time.Sleep,
+	}
+}
+
+//ParseURL takes a potential url.  Based on scheme will either upload to the
+//store and return the uploaded artifact or just return the url.  An input of
+//- uploads the artifact read from stdin, and remote urls are fetched and
+//uploaded when re-hosting.
+func (u *Uploader) ParseURL(input string) (*Artifact, error) {
+	if input == "-" {
+		return u.uploadFrom("stdin", func(f *os.File) error {
+			_, err := io.Copy(f, u.Stdin)
+			return err
+		})
+	}
+
+	url, err := url.Parse(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url: %v", err.Error())
+	}
+
+	// It is a local file.  upload to the store
+	// and create a timed url for downloading after analysis
+	if url.Scheme == "" || url.Scheme == "file" {
+
+		f, err := os.Open(url.Hostname() + url.EscapedPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file for url (%s): %v", url.String(), err.Error())
+		}
+		defer f.Close()
+
+		return u.Upload(f)
+	}
+
+	if u.Rehost && u.Fetcher != nil && u.Fetcher.Supports(url) {
+		name := path.Base(url.Path)
+		if name == "/" || name == "." {
+			name = url.Host
+		}
+
+		u.progressf("Fetching %s for re-hosting\n", url.Redacted())
+		return u.uploadFrom(name, func(f *os.File) error {
+			return u.Fetcher.Fetch(url, f)
+		})
+	}
+
+	return &Artifact{URL: url.String()}, nil
+}
+
+//uploadFrom writes the artifact to a temporary file with the given name so it
+//can be digested and uploaded
+func (u *Uploader) uploadFrom(name string, write func(f *os.File) error) (*Artifact, error) {
+	dir, err := ioutil.TempDir("", "ionize")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, filepath.Base(name)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err.Error())
+	}
+	defer f.Close()
+
+	err = write(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact (%s): %w", name, err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind artifact (%s): %v", name, err.Error())
+	}
+
+	return u.Upload(f)
+}
+
+//Upload stores the file under its content address, skipping the upload when
+//the store already holds the same content, and returns the uploaded artifact
+func (u *Uploader) Upload(f *os.File) (*Artifact, error) {
+	digest, err := Digest(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to digest file (%s): %v", f.Name(), err.Error())
+	}
+
+	key := Key(digest, f.Name())
+
+	var exists bool
+	err = u.retry("checking for "+key, func() error {
+		var err error
+		exists, err = u.Store.Exists(key)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check Ion Channel for file: %w", err)
+	}
+
+	if exists {
+		u.progressf("%s already uploaded, skipping\n", filepath.Base(f.Name()))
+
+		// the object may be shared with earlier analyses, so bring its tags
+		// up to date for this one
+		err = u.AddTags(key, u.tags())
+		if err != nil {
+			return nil, fmt.Errorf("failed to tag file in Ion Channel: %w", err)
+		}
+	} else {
+		err = u.retry("uploading "+key, func() error {
+			_, err := f.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+
+			body, err := u.progressReader(f)
+			if err != nil {
+				return err
+			}
+
+			return u.Store.Put(key, body, &PutOptions{
+				Metadata: map[string]string{DigestMetadataKey: digest},
+				Tags:     u.tags(),
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write file to Ion Channel: %w", err)
+		}
+	}
+
+	erl, err := u.Store.URL(key, u.PresignTTL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign request: %w", classify(err))
+	}
+
+	return &Artifact{
+		URL:    erl,
+		Key:    key,
+		Digest: digest,
+	}, nil
+}
+
+//AddTags merges the tags into those of the object at key.  Nothing is done
+//when the store does not support tagging.
+func (u *Uploader) AddTags(key string, tags map[string]string) error {
+	lc, ok := u.Store.(Lifecycle)
+	if !ok || len(tags) == 0 {
+		return nil
+	}
+
+	return u.retry("tagging "+key, func() error {
+		existing, err := lc.Tags(key)
+		if err != nil {
+			return err
+		}
+
+		merged := map[string]string{}
+		for k, v := range existing {
+			merged[k] = v
+		}
+		for k, v := range tags {
+			merged[k] = v
+		}
+
+		return lc.SetTags(key, merged)
+	})
+}
+
+func (u *Uploader) tags() map[string]string {
+	tags := map[string]string{}
+	for k, v := range u.Tags {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+
+	if u.Retention > 0 {
+		tags[ExpiresTag] = time.Now().Add(u.Retention).UTC().Format(time.RFC3339)
+	}
+
+	return tags
+}
+
+//retry calls the function until it succeeds, it fails with an error that is
+//not transient, or the retries are used up.  The wait between attempts
+//doubles after each one.
+func (u *Uploader) retry(op string, fn func() error) error {
+	backoff := u.Backoff
+	for attempt := 0; ; attempt++ {
+		err := classify(fn())
+		if err == nil || !transient(err) || attempt >= u.Retries {
+			return err
+		}
+
+		u.progressf("%s failed, retrying in %v: %v\n", op, backoff, err.Error())
+		if u.sleep != nil {
+			u.sleep(backoff)
+		}
+		backoff *= 2
+	}
+}
+
+func (u *Uploader) progressf(format string, a ...interface{}) {
+	if u.Progress != nil {
+		fmt.Fprintf(u.Progress, format, a...)
+	}
+}
+
+func (u *Uploader) progressReader(f *os.File) (io.Reader, error) {
+	if u.Progress == nil {
+		return f, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &progressReader{
+		r:     f,
+		name:  filepath.Base(f.Name()),
+		total: info.Size(),
+		w:     u.Progress,
+	}, nil
+}
+
+//progressReader reports each additional tenth of the total that is read
+type progressReader struct {
+	r        io.ReadSeeker
+	name     string
+	total    int64
+	read     int64
+	reported int64
+	w        io.Writer
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	if p.total > 0 {
+		tenths := p.read * 10 / p.total
+		if tenths > p.reported {
+			p.reported = tenths
+			fmt.Fprintf(p.w, "Uploading %s: %d%% (%d of %d bytes)\n", p.name, tenths*10, p.read, p.total)
+		}
+	}
+
+	return n, err
+}
+
+//Seek lets stores determine the length of the upload
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	return p.r.Seek(offset, whence)
+}
@@ -0,0 +1,161 @@
+// Package git answers the questions ionize asks about the repository it is
+// run in by running the git command.
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var scpLike = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// run runs git in the dir and returns what it prints, trimmed
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %v failed: %v", strings.Join(args, " "), msg)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Root returns the top level directory of the repository the dir is in
+func Root(dir string) (string, error) {
+	return run(dir, "rev-parse", "--show-toplevel")
+}
+
+// RemoteURL returns the url of the named remote
+func RemoteURL(dir, remote string) (string, error) {
+	return run(dir, "config", "--get", "remote."+remote+".url")
+}
+
+// DefaultBranch returns the branch the remote's HEAD points to, falling back
+// to the branch checked out and then master
+func DefaultBranch(dir, remote string) string {
+	ref, err := run(dir, "symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD")
+	if err == nil && ref != "" {
+		return strings.TrimPrefix(ref, remote+"/")
+	}
+
+	branch, err := run(dir, "symbolic-ref", "--short", "HEAD")
+	if err == nil && branch != "" {
+		return branch
+	}
+
+	return "master"
+}
+
+// Tracked reports whether git tracks the file, which is false when git is
+// not installed or the file is not in a repository
+func Tracked(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	_, err = run(filepath.Dir(abs), "ls-files", "--error-unmatch", filepath.Base(abs))
+	return err == nil
+}
+
+// ChangedFiles returns the paths, relative to the root of the repository,
+// of the files changed on HEAD since it diverged from the ref, listing both
+// the old and new paths of renamed files
+func ChangedFiles(dir, ref string) ([]string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %v", ref)
+	}
+
+	out, err := run(dir, "diff", "--name-only", "--no-renames", ref+"...HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, f := range strings.Split(out, "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+
+	return files, nil
+}
+
+// Match reports whether the slash separated name matches the pattern, where
+// ** matches any number of directories and a pattern naming a directory
+// matches everything in it
+func Match(pattern, name string) bool {
+	return match(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if match(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return true
+}
+
+// HTTPSURL converts an scp like ssh url such as git@github.com:org/repo.git
+// into its https equivalent, returning other urls as they are.  The port of an
+// ssh url is dropped, since it is the port of the ssh server.
+func HTTPSURL(remote string) string {
+	if strings.Contains(remote, "://") {
+		if strings.HasPrefix(remote, "ssh://") {
+			u, err := url.Parse(remote)
+			if err != nil {
+				return remote
+			}
+			host := u.Hostname()
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			return "https://" + host + u.EscapedPath()
+		}
+		return remote
+	}
+
+	m := scpLike.FindStringSubmatch(remote)
+	if m == nil {
+		return remote
+	}
+
+	return fmt.Sprintf("https://%v/%v", m[1], m[2])
+}
@@ -0,0 +1,156 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/franela/goblin"
+	. "github.com/onsi/gomega"
+)
+
+func TestGit(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Repository", func() {
+		var dir string
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-git")
+			dir, _ = filepath.EvalSymlinks(dir)
+			exec.Command("git", "-C", dir, "init", "-q").Run()
+			exec.Command("git", "-C", dir, "checkout", "-q", "-b", "develop").Run()
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should find the root, remote, and branch", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+
+			sub := filepath.Join(dir, "sub")
+			os.Mkdir(sub, 0755)
+			exec.Command("git", "-C", dir, "remote", "add", "origin", "git@github.com:ion-channel/ionize.git").Run()
+
+			root, err := Root(sub)
+			Expect(err).To(BeNil())
+			Expect(root).To(Equal(dir))
+
+			remote, err := RemoteURL(sub, "origin")
+			Expect(err).To(BeNil())
+			Expect(remote).To(Equal("git@github.com:ion-channel/ionize.git"))
+
+			_, err = RemoteURL(sub, "upstream")
+			Expect(err).NotTo(BeNil())
+
+			Expect(DefaultBranch(sub, "origin")).To(Equal("develop"))
+		})
+
+		g.It("should know which files git tracks", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+
+			tracked := filepath.Join(dir, ".ionize.yaml")
+			ioutil.WriteFile(tracked, []byte("key: supersecretapikey\n"), 0644)
+			exec.Command("git", "-C", dir, "add", ".ionize.yaml").Run()
+			untracked := filepath.Join(dir, "other.yaml")
+			ioutil.WriteFile(untracked, []byte("key: supersecretapikey\n"), 0644)
+
+			Expect(Tracked(tracked)).To(BeTrue())
+			Expect(Tracked(untracked)).To(BeFalse())
+			Expect(Tracked(filepath.Join(os.TempDir(), "missing.yaml"))).To(BeFalse())
+		})
+	})
+
+	g.Describe("Changes", func() {
+		var dir string
+
+		commit := func(name, contents string) {
+			p := filepath.Join(dir, name)
+			os.MkdirAll(filepath.Dir(p), 0755)
+			ioutil.WriteFile(p, []byte(contents), 0644)
+			exec.Command("git", "-C", dir, "add", name).Run()
+			exec.Command("git", "-C", dir, "-c", "user.name=ionize", "-c", "user.email=ionize@example.com", "commit", "-q", "-m", name).Run()
+		}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-git")
+			exec.Command("git", "-C", dir, "init", "-q").Run()
+		})
+
+		g.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		g.It("should list the files changed since the ref", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+
+			commit("README.md", "readme")
+			exec.Command("git", "-C", dir, "tag", "base").Run()
+			commit("services/api/main.go", "package main")
+			commit("web/index.html", "<html>")
+
+			files, err := ChangedFiles(filepath.Join(dir, "services"), "base")
+			Expect(err).To(BeNil())
+			Expect(files).To(Equal([]string{"services/api/main.go", "web/index.html"}))
+
+			_, err = ChangedFiles(dir, "missing")
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should list both paths of renamed files", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+
+			commit("services/api/main.go", "package main")
+			exec.Command("git", "-C", dir, "tag", "base").Run()
+			exec.Command("git", "-C", dir, "mv", "services/api", "services/web").Run()
+			exec.Command("git", "-C", dir, "-c", "user.name=ionize", "-c", "user.email=ionize@example.com", "commit", "-q", "-m", "move").Run()
+
+			files, err := ChangedFiles(dir, "base")
+			Expect(err).To(BeNil())
+			Expect(files).To(Equal([]string{"services/api/main.go", "services/web/main.go"}))
+		})
+
+		g.It("should refuse refs that git would read as options", func() {
+			commit("README.md", "readme")
+
+			_, err := ChangedFiles(dir, "--output=/tmp/ionize-diff")
+			Expect(err).To(MatchError("invalid ref --output=/tmp/ionize-diff"))
+		})
+
+		g.It("should match paths against patterns", func() {
+			Expect(Match("services/api", "services/api/main.go")).To(BeTrue())
+			Expect(Match("services/api/**", "services/api/cmd/main.go")).To(BeTrue())
+			Expect(Match("services/*/go.mod", "services/api/go.mod")).To(BeTrue())
+			Expect(Match("**/*.proto", "proto/v1/api.proto")).To(BeTrue())
+			Expect(Match("/abs/lib/**/*.go", "/abs/lib/a/b/c.go")).To(BeTrue())
+
+			Expect(Match("services/api", "services/apiv2/main.go")).To(BeFalse())
+			Expect(Match("services/*/go.mod", "services/api/go.sum")).To(BeFalse())
+			Expect(Match("**/*.proto", "proto/v1/api.go")).To(BeFalse())
+			Expect(Match("services/api/main.go", "services/api")).To(BeFalse())
+			Expect(Match("[", "x")).To(BeFalse())
+		})
+	})
+
+	g.Describe("URLs", func() {
+		g.It("should convert ssh urls to https", func() {
+			Expect(HTTPSURL("git@github.com:ion-channel/ionize.git")).To(Equal("https://github.com/ion-channel/ionize.git"))
+			Expect(HTTPSURL("ssh://git@github.com/ion-channel/ionize.git")).To(Equal("https://github.com/ion-channel/ionize.git"))
+			Expect(HTTPSURL("ssh://git@git.example.com:2222/ion-channel/ionize.git")).To(Equal("https://git.example.com/ion-channel/ionize.git"))
+			Expect(HTTPSURL("https://github.com/ion-channel/ionize.git")).To(Equal("https://github.com/ion-channel/ionize.git"))
+			Expect(HTTPSURL("/srv/git/ionize.git")).To(Equal("/srv/git/ionize.git"))
+		})
+	})
+}
@@ -0,0 +1,249 @@
+// Package logging writes ionize's diagnostics, leveled so they can be made
+// louder or quieter, as text for people or as JSON lines for log collectors.
+// Each message can carry fields, such as the team, project, and analysis it is
+// about, and secrets are redacted from messages and fields alike.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ion-channel/ionize/redact"
+)
+
+// Level is how important a message is
+type Level int
+
+const (
+	// LevelDebug messages are details for tracking down problems
+	LevelDebug Level = iota
+	// LevelInfo messages report progress
+	LevelInfo
+	// LevelWarn messages are problems that were worked around
+	LevelWarn
+	// LevelError messages are why something failed
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named debug, info, warn, or error
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) || strings.EqualFold(name, "warning") && l == LevelWarn {
+			return l, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn, or error", name)
+}
+
+const (
+	// FormatText writes each message as a line of text
+	FormatText = "text"
+	// FormatJSON writes each message as a JSON object on its own line
+	FormatJSON = "json"
+)
+
+// The fields ionize attaches to messages
+const (
+	Team       = "team"
+	Project    = "project"
+	AnalysisID = "analysis_id"
+	Phase      = "phase"
+)
+
+// Fields are the values attached to messages, by name
+type Fields map[string]interface{}
+
+// Logger writes messages at or above its level.  A nil Logger discards
+// everything, so it can be left unset where logging is optional.
+type Logger struct {
+	out    io.Writer
+	level  Level
+	json   bool
+	fields Fields
+	mu     *sync.Mutex
+	now    func() time.Time
+}
+
+// New creates a logger writing messages at or above the level to out in the
+// format, FormatText or FormatJSON
+func New(out io.Writer, level Level, format string) (*Logger, error) {
+	l := &Logger{out: out, level: level, mu: &sync.Mutex{}, now: time.Now}
+
+	switch strings.ToLower(format) {
+	case "", FormatText:
+	case FormatJSON:
+		l.json = true
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	return l, nil
+}
+
+// With returns a logger attaching the fields to every message, along with
+// those the logger already attaches
+func (l *Logger) With(fields Fields) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	c := *l
+	c.fields = Fields{}
+	for k, v := range l.fields {
+		c.fields[k] = v
+	}
+	for k, v := range fields {
+		c.fields[k] = v
+	}
+
+	return &c
+}
+
+// Enabled reports whether messages at the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Debugf writes a debug message
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.logf(LevelDebug, format, v...)
+}
+
+// Infof writes an info message
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.logf(LevelInfo, format, v...)
+}
+
+// Warnf writes a warning
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.logf(LevelWarn, format, v...)
+}
+
+// Errorf writes an error
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.logf(LevelError, format, v...)
+}
+
+func (l *Logger) logf(level Level, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	msg := redact.String(strings.TrimRight(fmt.Sprintf(format, v...), "\n"))
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	if l.json {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, l.now().UTC().Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for _, k := range keys {
+			b.WriteString(",")
+			writeJSON(&b, k)
+			b.WriteString(":")
+			writeJSON(&b, redactValue(l.fields[k]))
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%v %-5v %v", l.now().Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %v=%v", k, quote(fmt.Sprint(redactValue(l.fields[k]))))
+		}
+		b.WriteString("\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	e, err := json.Marshal(v)
+	if err != nil {
+		e, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(e)
+}
+
+func redactValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return redact.String(s)
+	}
+
+	return v
+}
+
+// quote quotes text field values that would otherwise be hard to tell apart
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// Writer returns a writer that logs each line written to it at the level,
+// for passing the logger to code that reports progress to a writer
+func (l *Logger) Writer(level Level) io.Writer {
+	if !l.Enabled(level) {
+		return ioutil.Discard
+	}
+
+	return &lineWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if line != "" {
+			w.l.logf(w.level, "%s", line)
+		}
+	}
+
+	return len(p), nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/ion-channel/ionize/cmd"
+	"github.com/ion-channel/ionize/redact"
 )
 
 var (
@@ -19,7 +20,7 @@ func main() {
 
 	err := cmd.RootCmd.Execute()
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, redact.String(err.Error()))
 		os.Exit(1)
 	}
 }
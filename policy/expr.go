@@ -0,0 +1,469 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// errUnavailable is returned evaluating a value the scan data does not have,
+// such as coverage when no coverage report was read
+var errUnavailable = errors.New("unavailable")
+
+// severities are the counts of each report, in the order they are listed
+var severities = []string{"critical", "high", "medium", "low"}
+
+// variables returns the names that can be used in expressions
+func variables() []string {
+	names := []string{"coverage", "coverage.previous", "coverage.delta"}
+	for _, prefix := range []string{"", "vulnerability.", "fortify."} {
+		for _, s := range severities {
+			names = append(names, prefix+s)
+		}
+	}
+
+	return names
+}
+
+// categoryVariable is indexed by the name of a Fortify category
+const categoryVariable = "fortify.category"
+
+type kind int
+
+const (
+	number kind = iota
+	boolean
+)
+
+func (k kind) String() string {
+	if k == boolean {
+		return "a comparison"
+	}
+
+	return "a number"
+}
+
+// node is a compiled part of an expression, evaluating to a float64 for
+// numbers and a bool for comparisons
+type node interface {
+	kind() kind
+	eval(d *Data) (interface{}, error)
+}
+
+type literal float64
+
+func (l literal) kind() kind                        { return number }
+func (l literal) eval(d *Data) (interface{}, error) { return float64(l), nil }
+
+type variable struct {
+	name     string
+	category string
+}
+
+func (v *variable) kind() kind { return number }
+
+func (v *variable) eval(d *Data) (interface{}, error) {
+	value, err := d.value(v.name, v.category)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (v *variable) String() string {
+	if v.name == categoryVariable {
+		return fmt.Sprintf("%v[%q]", v.name, v.category)
+	}
+
+	return v.name
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (u *unary) kind() kind { return u.operand.kind() }
+
+func (u *unary) eval(d *Data) (interface{}, error) {
+	v, err := u.operand.eval(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.op == "!" {
+		return !v.(bool), nil
+	}
+
+	return -v.(float64), nil
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (b *binary) kind() kind {
+	switch b.op {
+	case "+", "-", "*", "/":
+		return number
+	}
+
+	return boolean
+}
+
+func (b *binary) eval(d *Data) (interface{}, error) {
+	l, err := b.left.eval(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || only evaluate their right side when it decides the result,
+	// so a rule can guard a value that may be unavailable
+	switch b.op {
+	case "&&":
+		if !l.(bool) {
+			return false, nil
+		}
+		return b.right.eval(d)
+	case "||":
+		if l.(bool) {
+			return true, nil
+		}
+		return b.right.eval(d)
+	}
+
+	r, err := b.right.eval(d)
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := l.(float64), r.(float64)
+	switch b.op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		return x / y, nil
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	}
+
+	return x >= y, nil
+}
+
+// expr is a compiled expression and the variables it uses
+type expr struct {
+	src  string
+	root node
+	vars []*variable
+}
+
+// compile parses the expression, which must be a comparison, such as
+// coverage >= 80 or critical + high <= 5
+func compile(src string) (*expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %v", p.toks[p.pos].text)
+	}
+	if root.kind() != boolean {
+		return nil, fmt.Errorf("%q is %v, not a comparison", src, root.kind())
+	}
+
+	return &expr{src: src, root: root, vars: p.vars}, nil
+}
+
+// values describes the values of the variables the expression uses
+func (e *expr) values(d *Data) string {
+	seen := map[string]bool{}
+	values := []string{}
+	for _, v := range e.vars {
+		name := v.String()
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		value, err := v.eval(d)
+		if err != nil {
+			values = append(values, fmt.Sprintf("%v unavailable", name))
+			continue
+		}
+		values = append(values, fmt.Sprintf("%v=%v", name, strconv.FormatFloat(math.Round(value.(float64)*1e6)/1e6, 'f', -1, 64)))
+	}
+	sort.Strings(values)
+
+	return strings.Join(values, ", ")
+}
+
+type token struct {
+	text string
+	// str is set for quoted strings, which are only used as category names
+	str bool
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")", "[", "]"}
+
+func lex(src string) ([]token, error) {
+	toks := []token{}
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string in %q", src)
+			}
+			s, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %v in %q", src[i:end+1], src)
+			}
+			toks = append(toks, token{text: s, str: true})
+			i = end + 1
+
+		case unicode.IsDigit(c) || c == '.':
+			end := i
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.') {
+				end++
+			}
+			toks = append(toks, token{text: src[i:end]})
+			i = end
+
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_' || src[end] == '.') {
+				end++
+			}
+			toks = append(toks, token{text: src[i:end]})
+			i = end
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q in %q", c, src)
+			}
+			toks = append(toks, token{text: op})
+			i += len(op)
+		}
+	}
+
+	return toks, nil
+}
+
+// parser builds the nodes of an expression, from the lowest precedence
+// operators to the highest
+type parser struct {
+	toks []token
+	pos  int
+	vars []*variable
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.toks) || p.toks[p.pos].str {
+		return ""
+	}
+
+	return p.toks[p.pos].text
+}
+
+func (p *parser) binary(next func() (node, error), want kind, ops ...string) (node, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if !contains(ops, op) {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != want || right.kind() != want {
+			return nil, fmt.Errorf("%v needs %v on each side", op, want)
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) or() (node, error) {
+	return p.binary(p.and, boolean, "||")
+}
+
+func (p *parser) and() (node, error) {
+	return p.binary(p.not, boolean, "&&")
+}
+
+func (p *parser) not() (node, error) {
+	if p.peek() != "!" {
+		return p.comparison()
+	}
+	p.pos++
+
+	operand, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	if operand.kind() != boolean {
+		return nil, fmt.Errorf("! needs %v", boolean)
+	}
+
+	return &unary{op: "!", operand: operand}, nil
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	if !contains([]string{"==", "!=", "<", "<=", ">", ">="}, op) {
+		return left, nil
+	}
+	p.pos++
+
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if left.kind() != number || right.kind() != number {
+		return nil, fmt.Errorf("%v needs %v on each side", op, number)
+	}
+
+	return &binary{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) sum() (node, error) {
+	return p.binary(p.product, number, "+", "-")
+}
+
+func (p *parser) product() (node, error) {
+	return p.binary(p.negation, number, "*", "/")
+}
+
+func (p *parser) negation() (node, error) {
+	if p.peek() != "-" {
+		return p.primary()
+	}
+	p.pos++
+
+	operand, err := p.negation()
+	if err != nil {
+		return nil, err
+	}
+	if operand.kind() != number {
+		return nil, fmt.Errorf("- needs %v", number)
+	}
+
+	return &unary{op: "-", operand: operand}, nil
+}
+
+func (p *parser) primary() (node, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	tok := p.toks[p.pos]
+	p.pos++
+	if tok.str {
+		return nil, fmt.Errorf("unexpected string %q, strings only name categories", tok.text)
+	}
+
+	switch c := rune(tok.text[0]); {
+	case tok.text == "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return n, nil
+
+	case unicode.IsDigit(c) || c == '.':
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %v", tok.text)
+		}
+		return literal(f), nil
+
+	case unicode.IsLetter(c) || c == '_':
+		return p.variable(tok.text)
+	}
+
+	return nil, fmt.Errorf("unexpected %v", tok.text)
+}
+
+func (p *parser) variable(name string) (node, error) {
+	v := &variable{name: name}
+
+	if name == categoryVariable {
+		if p.peek() != "[" || p.pos+2 >= len(p.toks) || !p.toks[p.pos+1].str || p.toks[p.pos+2].text != "]" {
+			return nil, fmt.Errorf(`%v must be followed by the name of a category, such as %v["SQL Injection"]`, name, name)
+		}
+		v.category = p.toks[p.pos+1].text
+		p.pos += 3
+	} else if !contains(variables(), name) {
+		return nil, fmt.Errorf("unknown value %v, use %v, or %v[\"<category>\"]", name, strings.Join(variables(), ", "), categoryVariable)
+	}
+
+	p.vars = append(p.vars, v)
+	return v, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
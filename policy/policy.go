@@ -0,0 +1,288 @@
+// Package policy checks the external scan data read from reports against
+// local rules, such as a minimum coverage or no critical Fortify findings, so
+// those gates are known right after the reports are read rather than once the
+// remote analysis finishes.  Rules are thresholds on a value or expressions
+// such as critical + high <= 5, and their results can be merged with the
+// evaluation of the remote ruleset.
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionic/scans"
+)
+
+// RuleType is the rule type local results are reported with when merged with
+// a remote evaluation
+const RuleType = "local policy"
+
+// Rule is a check of the external scan data, either an expression, or a
+// value with the min and max it must be within
+type Rule struct {
+	Name  string   `mapstructure:"name"`
+	Expr  string   `mapstructure:"expr"`
+	Value string   `mapstructure:"value"`
+	Min   *float64 `mapstructure:"min"`
+	Max   *float64 `mapstructure:"max"`
+}
+
+// Policy is the rules the external scan data is checked against
+type Policy struct {
+	// FailFast is whether an analysis should not be requested when a rule
+	// fails
+	FailFast bool
+
+	rules []*rule
+}
+
+type rule struct {
+	name string
+	expr *expr
+}
+
+// New compiles the rules into a policy, failing on the first rule that is
+// malformed
+func New(rules []Rule, failFast bool) (*Policy, error) {
+	p := &Policy{FailFast: failFast}
+
+	for i, r := range rules {
+		src, err := r.source()
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %v: %v", i+1, err.Error())
+		}
+
+		e, err := compile(src)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %v: %v", i+1, err.Error())
+		}
+
+		name := r.Name
+		if name == "" {
+			name = src
+		}
+		p.rules = append(p.rules, &rule{name: name, expr: e})
+	}
+
+	return p, nil
+}
+
+// source returns the expression of the rule, written out from its value and
+// limits when it is a threshold
+func (r *Rule) source() (string, error) {
+	switch {
+	case r.Expr != "" && r.Value != "":
+		return "", fmt.Errorf("set expr or value, not both")
+
+	case r.Expr != "":
+		return r.Expr, nil
+
+	case r.Value == "":
+		return "", fmt.Errorf("set expr, or value with min or max")
+
+	case r.Min == nil && r.Max == nil:
+		return "", fmt.Errorf("set min or max for %v", r.Value)
+	}
+
+	limits := []string{}
+	if r.Min != nil {
+		limits = append(limits, fmt.Sprintf("%v >= %v", r.Value, *r.Min))
+	}
+	if r.Max != nil {
+		limits = append(limits, fmt.Sprintf("%v <= %v", r.Value, *r.Max))
+	}
+
+	return strings.Join(limits, " && "), nil
+}
+
+// Len returns how many rules the policy has
+func (p *Policy) Len() int {
+	if p == nil {
+		return 0
+	}
+
+	return len(p.rules)
+}
+
+// Data is the external scan data rules are checked against, gathered from
+// every report of a project
+type Data struct {
+	// Coverage is the coverage value, nil when no coverage report was read
+	Coverage *float64
+
+	// PreviousCoverage is the coverage of the analysis compared with, nil
+	// when there is none
+	PreviousCoverage *float64
+
+	// Vulnerability is the sum of the counts of the vulnerability reports,
+	// and Fortify the counts of the FPR file
+	Vulnerability scanner.ExternalVulnerability
+	Fortify       scanner.ExternalVulnerability
+
+	// Categories is the number of Fortify findings of each category
+	Categories map[string]int
+}
+
+// Add adds the counts of a vulnerability report to the data
+func (d *Data) Add(v *scanner.ExternalVulnerability) {
+	if v == nil {
+		return
+	}
+
+	d.Vulnerability.Critcal += v.Critcal
+	d.Vulnerability.High += v.High
+	d.Vulnerability.Medium += v.Medium
+	d.Vulnerability.Low += v.Low
+}
+
+func (d *Data) value(name, category string) (float64, error) {
+	switch name {
+	case "coverage":
+		if d.Coverage == nil {
+			return 0, errUnavailable
+		}
+		return *d.Coverage, nil
+
+	case "coverage.previous":
+		if d.PreviousCoverage == nil {
+			return 0, errUnavailable
+		}
+		return *d.PreviousCoverage, nil
+
+	case "coverage.delta":
+		if d.Coverage == nil || d.PreviousCoverage == nil {
+			return 0, errUnavailable
+		}
+		return *d.Coverage - *d.PreviousCoverage, nil
+
+	case categoryVariable:
+		return float64(d.Categories[category]), nil
+	}
+
+	var counts []scanner.ExternalVulnerability
+	switch {
+	case strings.HasPrefix(name, "vulnerability."):
+		counts = []scanner.ExternalVulnerability{d.Vulnerability}
+	case strings.HasPrefix(name, "fortify."):
+		counts = []scanner.ExternalVulnerability{d.Fortify}
+	default:
+		counts = []scanner.ExternalVulnerability{d.Vulnerability, d.Fortify}
+	}
+
+	total := 0
+	severity := name[strings.LastIndex(name, ".")+1:]
+	for _, c := range counts {
+		total += map[string]int{"critical": c.Critcal, "high": c.High, "medium": c.Medium, "low": c.Low}[severity]
+	}
+
+	return float64(total), nil
+}
+
+// Result is the outcome of a rule
+type Result struct {
+	Rule   string
+	Passed bool
+
+	// Skipped is set when the rule uses a value the data does not have, which
+	// passes it
+	Skipped bool
+
+	// Values describes the values the rule checked, such as coverage=72.5
+	Values string
+}
+
+// String describes the result for reports
+func (r *Result) String() string {
+	if r.Skipped {
+		return fmt.Sprintf("%v (skipped, %v)", r.Rule, r.Values)
+	}
+
+	return fmt.Sprintf("%v (%v)", r.Rule, r.Values)
+}
+
+// Evaluation is how the external scan data fared against the policy
+type Evaluation struct {
+	Passed  bool
+	Results []*Result
+}
+
+// Evaluate checks the data against every rule of the policy
+func (p *Policy) Evaluate(d *Data) *Evaluation {
+	eval := &Evaluation{Passed: true}
+
+	for _, r := range p.rules {
+		res := &Result{Rule: r.name, Values: r.expr.values(d)}
+
+		v, err := r.expr.root.eval(d)
+		switch {
+		case err == errUnavailable:
+			res.Passed = true
+			res.Skipped = true
+		case err != nil:
+			res.Values = err.Error()
+		default:
+			res.Passed = v.(bool)
+		}
+
+		eval.Passed = eval.Passed && res.Passed
+		eval.Results = append(eval.Results, res)
+	}
+
+	return eval
+}
+
+// Failed returns the results of the rules that failed
+func (e *Evaluation) Failed() []*Result {
+	failed := []*Result{}
+	for _, r := range e.Results {
+		if !r.Passed {
+			failed = append(failed, r)
+		}
+	}
+
+	return failed
+}
+
+// Merge adds the results to the evaluation of the remote ruleset, which fails
+// when any of them did
+func (e *Evaluation) Merge(summary *rulesets.AppliedRulesetSummary) {
+	if summary.RuleEvaluationSummary == nil {
+		summary.RuleEvaluationSummary = &rulesets.RuleEvaluationSummary{Passed: true, Summary: "pass"}
+	}
+	s := summary.RuleEvaluationSummary
+
+	for _, r := range e.Results {
+		result := scans.NewEval()
+		result.ProjectID = summary.ProjectID
+		result.TeamID = summary.TeamID
+		result.AnalysisID = summary.AnalysisID
+		result.Summary = r.String()
+		result.Name = r.Rule
+		result.Type = RuleType
+		result.Passed = r.Passed
+		result.Risk = "low"
+		if !r.Passed {
+			result.Risk = "high"
+		}
+		s.Ruleresults = append(s.Ruleresults, *result)
+	}
+
+	if !e.Passed {
+		s.Passed = false
+		s.Summary = "fail"
+		s.Risk = "high"
+	}
+}
+
+// Summary returns the results as an evaluation of their own, for when there
+// is no remote evaluation to merge them with
+func (e *Evaluation) Summary() *rulesets.AppliedRulesetSummary {
+	summary := &rulesets.AppliedRulesetSummary{
+		RuleEvaluationSummary: &rulesets.RuleEvaluationSummary{RulesetName: RuleType, Passed: true, Summary: "pass", Risk: "low"},
+	}
+	e.Merge(summary)
+
+	return summary
+}
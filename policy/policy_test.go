@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/scanner"
+	. "github.com/onsi/gomega"
+)
+
+func TestPolicy(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Policy", func() {
+		coverage := 72.5
+		data := &Data{
+			Coverage:      &coverage,
+			Vulnerability: scanner.ExternalVulnerability{Critcal: 1, High: 2},
+			Fortify:       scanner.ExternalVulnerability{High: 3, Low: 4},
+			Categories:    map[string]int{"SQL Injection": 2},
+		}
+		min, max := 80.0, 0.0
+
+		evaluate := func(rules ...Rule) *Evaluation {
+			p, err := New(rules, false)
+			Expect(err).To(BeNil())
+			return p.Evaluate(data)
+		}
+
+		g.It("should check values against thresholds", func() {
+			eval := evaluate(
+				Rule{Name: "Coverage of at least 80%", Value: "coverage", Min: &min},
+				Rule{Value: "fortify.critical", Max: &max},
+			)
+			Expect(eval.Passed).To(BeFalse())
+			Expect(eval.Results[0].String()).To(Equal("Coverage of at least 80% (coverage=72.5)"))
+			Expect(eval.Results[1].String()).To(Equal("fortify.critical <= 0 (fortify.critical=0)"))
+			Expect(eval.Failed()).To(HaveLen(1))
+		})
+
+		g.It("should evaluate expressions", func() {
+			for expr, passed := range map[string]bool{
+				"critical + high <= 5":                               false,
+				"critical + high == 6 && low > 3":                    true,
+				"vulnerability.high * 2 - -1 >= fortify.high + 2":    true,
+				"!(coverage < 70) || fortify.low / 0 > 1":            true,
+				`fortify.category["SQL Injection"] == 0`:             false,
+				`fortify.category["Memory Leak"] + medium == 0`:      true,
+				"(vulnerability.critical > 0) && (coverage >= 72.5)": true,
+			} {
+				Expect(evaluate(Rule{Expr: expr}).Passed).To(Equal(passed), expr)
+			}
+		})
+
+		g.It("should skip rules using values that were not read", func() {
+			p, _ := New([]Rule{{Expr: "coverage >= 80"}, {Expr: "critical == 0 && coverage >= 80"}}, false)
+			eval := p.Evaluate(&Data{})
+			Expect(eval.Passed).To(BeTrue())
+			Expect(eval.Results[0].Skipped).To(BeTrue())
+			Expect(eval.Results[0].String()).To(Equal("coverage >= 80 (skipped, coverage unavailable)"))
+			Expect(eval.Results[1].Skipped).To(BeTrue())
+		})
+
+		g.It("should compare the coverage with the previous coverage", func() {
+			previous := 75.25
+			trend := &Data{Coverage: &coverage, PreviousCoverage: &previous}
+			p, _ := New([]Rule{{Expr: "coverage.delta >= -2"}, {Value: "coverage.previous", Min: &min}}, false)
+
+			eval := p.Evaluate(trend)
+			Expect(eval.Passed).To(BeFalse())
+			Expect(eval.Results[0].String()).To(Equal("coverage.delta >= -2 (coverage.delta=-2.75)"))
+			Expect(eval.Results[1].Passed).To(BeFalse())
+
+			eval = p.Evaluate(data)
+			Expect(eval.Passed).To(BeTrue())
+			Expect(eval.Results[0].String()).To(Equal("coverage.delta >= -2 (skipped, coverage.delta unavailable)"))
+		})
+
+		g.It("should reject malformed rules", func() {
+			for expr, msg := range map[string]string{
+				"coverage":                      `"coverage" is a number, not a comparison`,
+				"coverage >= ":                  "unexpected end of expression",
+				"critcal == 0":                  "unknown value critcal",
+				"fortify.category == 0":         "must be followed by the name of a category",
+				"coverage >= 80 && 1":           "&& needs a comparison on each side",
+				"(coverage >= 80":               "missing )",
+				"coverage >= 80 % 2":            `unexpected '%'`,
+				`coverage >= "80"`:              "strings only name categories",
+				"coverage >= 80 coverage":       "unexpected coverage",
+				"1 == 1 == 1":                   "unexpected ==",
+				`fortify.category["SQL`:         "unterminated string",
+				"-(coverage >= 80) == 1":        "- needs a number",
+				"!coverage":                     "! needs a comparison",
+				"coverage + (critical > 0) > 1": "+ needs a number on each side",
+			} {
+				_, err := New([]Rule{{Expr: expr}}, false)
+				Expect(err).NotTo(BeNil(), expr)
+				Expect(err.Error()).To(ContainSubstring(msg), expr)
+			}
+
+			_, err := New([]Rule{{Expr: "coverage >= 80", Value: "coverage"}}, false)
+			Expect(err.Error()).To(Equal("policy rule 1: set expr or value, not both"))
+			_, err = New([]Rule{{Name: "coverage"}}, false)
+			Expect(err.Error()).To(Equal("policy rule 1: set expr, or value with min or max"))
+			_, err = New([]Rule{{Value: "coverage"}}, false)
+			Expect(err.Error()).To(Equal("policy rule 1: set min or max for coverage"))
+		})
+
+		g.It("should merge its results with the remote evaluation", func() {
+			remote := &rulesets.AppliedRulesetSummary{
+				AnalysisID:            "analysis-1",
+				RuleEvaluationSummary: &rulesets.RuleEvaluationSummary{Passed: true, Summary: "pass", Risk: "low"},
+			}
+			evaluate(Rule{Expr: "critical == 1"}).Merge(remote)
+			Expect(remote.RuleEvaluationSummary.Passed).To(BeTrue())
+			Expect(remote.RuleEvaluationSummary.Ruleresults).To(HaveLen(1))
+			Expect(remote.RuleEvaluationSummary.Ruleresults[0].Type).To(Equal(RuleType))
+			Expect(remote.RuleEvaluationSummary.Ruleresults[0].AnalysisID).To(Equal("analysis-1"))
+
+			evaluate(Rule{Expr: "coverage >= 80"}).Merge(remote)
+			Expect(remote.RuleEvaluationSummary.Passed).To(BeFalse())
+			Expect(remote.RuleEvaluationSummary.Summary).To(Equal("fail"))
+			Expect(remote.RuleEvaluationSummary.Ruleresults[1].Risk).To(Equal("high"))
+
+			summary := evaluate(Rule{Expr: "coverage >= 80"}).Summary()
+			Expect(summary.RuleEvaluationSummary.RulesetName).To(Equal(RuleType))
+			Expect(summary.RuleEvaluationSummary.Passed).To(BeFalse())
+		})
+	})
+}
@@ -0,0 +1,165 @@
+// Package redact removes secrets from text before ionize prints or logs it.
+// Secrets known ahead of time, such as the API key, are registered with Add
+// and replaced wherever they appear, while signatures and credentials in urls
+// and authorization headers are recognized by their shape.
+package redact
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask replaces each secret removed
+	Mask = "********"
+
+	// minLength is the shortest secret registered, shorter values would
+	// mask ordinary words
+	minLength = 4
+)
+
+var (
+	mu      sync.RWMutex
+	secrets = map[string]bool{}
+
+	patterns = []struct {
+		re          *regexp.Regexp
+		replacement string
+	}{
+		// signatures, credentials, and tokens in url query strings
+		{regexp.MustCompile(`(?i)([?&](?:x-amz-signature|x-amz-credential|x-amz-security-token|x-goog-signature|x-goog-credential|signature|sig|token|access_token|api_key|apikey)=)[^&\s"'<>]+`), "${1}" + Mask},
+		// credentials in authorization headers
+		{regexp.MustCompile(`(?i)(authorization:\s*(?:bearer\s+|basic\s+|token\s+)?)[^\s"']+`), "${1}" + Mask},
+		{regexp.MustCompile(`(?i)(\bbearer\s+)[a-z0-9._~+/-]+=*`), "${1}" + Mask},
+		// passwords in urls
+		{regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`), "${1}" + Mask + "@"},
+		// passwords, tokens, and keys in json
+		{regexp.MustCompile(`(?i)("(?:password|jwt|token|access_token|secret_access_key|api_key)"\s*:\s*")[^"]*"`), "${1}" + Mask + `"`},
+	}
+
+	// sensitiveHeaders are masked entirely by Header
+	sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Amz-Security-Token"}
+)
+
+// Add registers values to be removed wherever they appear, ignoring any too
+// short to be a secret
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) >= minLength {
+			secrets[v] = true
+		}
+	}
+}
+
+// Reset forgets every value registered with Add
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	secrets = map[string]bool{}
+}
+
+// String removes the registered secrets and any recognized credentials from
+// the text
+func String(s string) string {
+	mu.RLock()
+	known := make([]string, 0, len(secrets))
+	for v := range secrets {
+		known = append(known, v)
+	}
+	mu.RUnlock()
+
+	// replace longer secrets first so a secret containing another is
+	// removed whole
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+	for _, v := range known {
+		s = strings.Replace(s, v, Mask, -1)
+	}
+
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+
+	return s
+}
+
+// Value masks a secret for display, keeping the last few characters of long
+// values so they can be told apart
+func Value(v string) string {
+	if v == "" {
+		return ""
+	}
+
+	if len(v) >= 16 {
+		return Mask + v[len(v)-4:]
+	}
+
+	return Mask
+}
+
+// Header returns a copy of the header with the credentials masked
+func Header(h http.Header) http.Header {
+	c := http.Header{}
+	for k, v := range h {
+		c[k] = append([]string{}, v...)
+	}
+
+	for _, k := range sensitiveHeaders {
+		if c.Get(k) != "" {
+			c.Set(k, Mask)
+		}
+	}
+
+	return c
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Error returns an error with the secrets removed from its message that
+// still unwraps to the original
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &redactedError{msg: String(err.Error()), err: err}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// Writer returns a writer that removes secrets from each write before passing
+// it on.  Secrets split across writes are not recognized, so it suits writers
+// that are given whole lines, such as a log.Logger.
+func Writer(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.w, String(string(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,445 @@
+// Package replay records the HTTP requests ionize makes and their responses
+// to fixture files, and serves them back in place of the network, so a run
+// can be reproduced exactly where the services it talked to can not be
+// reached.  Secrets are redacted before anything is written.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ion-channel/ionize/redact"
+)
+
+// Interaction is a request and the response or error it got, as written to
+// a fixture file
+type Interaction struct {
+	Request  Request   `json:"request"`
+	Response *Response `json:"response,omitempty"`
+
+	// Error is why the request failed without a response
+	Error string `json:"error,omitempty"`
+}
+
+// Request is a recorded request
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body"`
+
+	// Omitted describes the body when it was too large to record
+	Omitted *Omitted `json:"omitted_body,omitempty"`
+}
+
+// Response is a recorded response
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body"`
+
+	// Omitted describes the body when it was too large to record
+	Omitted *Omitted `json:"omitted_body,omitempty"`
+}
+
+// Omitted is a body left out of a fixture for being larger than the
+// recorder's limit, recorded by its digest and size instead
+type Omitted struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Body is a recorded body, written as text when it is text and base64
+// otherwise
+type Body []byte
+
+// MarshalJSON writes the body as a string, or an object holding its base64
+// encoding when it is not text
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON reads a body written by MarshalJSON
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("failed to read body: %v", err.Error())
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return fmt.Errorf("failed to decode body: %v", err.Error())
+	}
+	*b = Body(decoded)
+
+	return nil
+}
+
+// key identifies requests that should get the same response, with the
+// secrets redacted so live requests match the recorded ones
+func key(method, url string) string {
+	return method + " " + redact.String(url)
+}
+
+// DefaultMaxBody is the largest body a recorder writes to a fixture when its
+// MaxBody is not set
+const DefaultMaxBody = 1 << 20
+
+// Recorder passes requests on to its transport and writes each one and its
+// response to a fixture file in its directory.  Bodies are streamed through
+// rather than read ahead, and those larger than MaxBody are recorded by their
+// digest and size alone, so uploading or downloading a large artifact does
+// not hold it in memory or copy it into the fixture.
+type Recorder struct {
+	Dir       string
+	Transport http.RoundTripper
+
+	// MaxBody is the largest body written to a fixture, DefaultMaxBody when
+	// it is zero
+	MaxBody int64
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewRecorder creates a recorder writing to the directory, creating it when
+// it does not exist, that passes requests on to the transport or
+// http.DefaultTransport when it is nil
+func NewRecorder(dir string, transport http.RoundTripper) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %v", err.Error())
+	}
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &Recorder{Dir: dir, Transport: transport}, nil
+}
+
+// RoundTrip makes the request and records it.  The fixture is written once
+// the response body has been read to the end or closed, and an error writing
+// it is returned by the body's Close.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	max := r.MaxBody
+	if max <= 0 {
+		max = DefaultMaxBody
+	}
+
+	r.mu.Lock()
+	r.n++
+	n := r.n
+	r.mu.Unlock()
+
+	i := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    redact.String(req.URL.String()),
+			Header: redactHeader(req.Header),
+		},
+	}
+
+	reqBody := &capture{max: max}
+	if req.Body != nil && req.Body != http.NoBody {
+		orig := req
+		req = req.Clone(req.Context())
+		req.Body = reqBody.wrap(orig.Body)
+		if orig.GetBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				b, err := orig.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				return reqBody.wrap(b), nil
+			}
+		}
+	}
+
+	resp, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		i.Request.Body, i.Request.Omitted = reqBody.recorded()
+		i.Error = redact.String(err.Error())
+		return nil, r.save(n, i, err)
+	}
+
+	i.Response = &Response{
+		StatusCode: resp.StatusCode,
+		Header:     redactHeader(resp.Header),
+	}
+
+	respBody := &capture{max: max}
+	respBody.done = func() error {
+		i.Request.Body, i.Request.Omitted = reqBody.recorded()
+		i.Response.Body, i.Response.Omitted = respBody.recorded()
+		return r.save(n, i, nil)
+	}
+	resp.Body = respBody.wrap(resp.Body)
+
+	return resp, nil
+}
+
+// capture passes a body through, keeping as much of it as can be recorded
+// along with its digest and size
+type capture struct {
+	max  int64
+	done func() error
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	hash hash.Hash
+	size int64
+
+	once sync.Once
+	err  error
+}
+
+// wrap starts capturing the body over again, for a request that is being
+// sent again, and returns the reader to send in its place
+func (c *capture) wrap(body io.ReadCloser) io.ReadCloser {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf.Reset()
+	c.hash = sha256.New()
+	c.size = 0
+
+	return &captured{ReadCloser: body, capture: c}
+}
+
+func (c *capture) write(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hash.Write(p)
+	c.size += int64(len(p))
+	if c.size <= c.max {
+		c.buf.Write(p)
+	} else {
+		c.buf.Reset()
+	}
+}
+
+// finish calls done the first time the body is read to the end or closed
+func (c *capture) finish() error {
+	c.once.Do(func() {
+		if c.done != nil {
+			c.err = c.done()
+		}
+	})
+
+	return c.err
+}
+
+// recorded returns the body as it is written to the fixture, redacted when
+// it is text, or what to record in its place when it is too large
+func (c *capture) recorded() (Body, *Omitted) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hash == nil {
+		return nil, nil
+	}
+
+	if c.size > c.max {
+		return nil, &Omitted{SHA256: hex.EncodeToString(c.hash.Sum(nil)), Size: c.size}
+	}
+
+	b := append([]byte{}, c.buf.Bytes()...)
+	if utf8.Valid(b) {
+		return Body(redact.String(string(b))), nil
+	}
+
+	return b, nil
+}
+
+// captured is a body being passed through a capture
+type captured struct {
+	io.ReadCloser
+	capture *capture
+}
+
+func (c *captured) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.capture.write(p[:n])
+	if err == io.EOF {
+		c.capture.finish()
+	}
+
+	return n, err
+}
+
+func (c *captured) Close() error {
+	err := c.ReadCloser.Close()
+	if ferr := c.capture.finish(); ferr != nil {
+		return ferr
+	}
+
+	return err
+}
+
+var unsafe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// save writes the interaction to the nth fixture file, returning the error
+// the request failed with, if it did
+func (r *Recorder) save(n int, i *Interaction, reqErr error) error {
+	b, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to record request: %v", err.Error())
+	}
+
+	path := strings.Trim(unsafe.ReplaceAllString(strings.SplitN(i.Request.URL, "?", 2)[0], "-"), "-")
+	name := fmt.Sprintf("%04d-%v-%v.json", n, strings.ToLower(i.Request.Method), path)
+	if len(name) > 120 {
+		name = name[:115] + ".json"
+	}
+
+	err = ioutil.WriteFile(filepath.Join(r.Dir, name), append(b, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to record request: %v", err.Error())
+	}
+
+	return reqErr
+}
+
+// Player serves the responses recorded in a directory.  Each recorded
+// response is served once, to the first request with the same method and
+// url, in the order they were recorded.
+type Player struct {
+	Dir string
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	served       []bool
+}
+
+// NewPlayer loads the fixture files recorded in the directory
+func NewPlayer(dir string) (*Player, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recorded requests: %v", err.Error())
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded requests found in %v", dir)
+	}
+	sort.Strings(files)
+
+	p := &Player{Dir: dir}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recorded request: %v", err.Error())
+		}
+
+		var i Interaction
+		err = json.Unmarshal(b, &i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recorded request %v: %v", filepath.Base(f), err.Error())
+		}
+
+		p.interactions = append(p.interactions, &i)
+		p.served = append(p.served, false)
+	}
+
+	return p, nil
+}
+
+// ErrNotRecorded is returned for requests that no recorded response is left
+// for
+var ErrNotRecorded = errors.New("no recorded response")
+
+// RoundTrip serves the recorded response to the request
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k := key(req.Method, req.URL.String())
+	for n, i := range p.interactions {
+		if p.served[n] || key(i.Request.Method, i.Request.URL) != k {
+			continue
+		}
+		p.served[n] = true
+
+		if i.Response == nil {
+			return nil, errors.New(i.Error)
+		}
+		if i.Response.Omitted != nil {
+			return nil, fmt.Errorf("the %v byte response to %v was too large to record", i.Response.Omitted.Size, redact.String(req.Method+" "+req.URL.String()))
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+			StatusCode:    i.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        i.Response.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(i.Response.Body)),
+			ContentLength: int64(len(i.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w for %v", ErrNotRecorded, redact.String(req.Method+" "+req.URL.String()))
+}
+
+// Unserved returns the method and url of the recorded requests that were not
+// made again, in the order they were recorded
+func (p *Player) Unserved() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	unserved := []string{}
+	for n, i := range p.interactions {
+		if !p.served[n] {
+			unserved = append(unserved, i.Request.Method+" "+i.Request.URL)
+		}
+	}
+
+	return unserved
+}
+
+func redactHeader(h http.Header) http.Header {
+	c := redact.Header(h)
+	for k, v := range c {
+		for n := range v {
+			v[n] = redact.String(v[n])
+		}
+		c[k] = v
+	}
+
+	return c
+}
@@ -0,0 +1,172 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionize/redact"
+	. "github.com/onsi/gomega"
+)
+
+func TestReplay(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Record and Replay", func() {
+		var dir string
+		var server *httptest.Server
+		binary := []byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe}
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-replay")
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/sessions/login":
+					fmt.Fprint(w, `{"data":{"jwt":"eyJhbGciOi.eyJzdWIi.sig","user":{"username":"someone"}}}`)
+				case "/artifacts/widget.tgz":
+					w.Write(binary)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprintf(w, `{"message":"no %v"}`, r.URL.Path)
+				}
+			}))
+			redact.Add("supersecretapikey")
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+			redact.Reset()
+		})
+
+		record := func() *http.Client {
+			r, err := NewRecorder(filepath.Join(dir, "fixtures"), nil)
+			Expect(err).To(BeNil())
+			return &http.Client{Transport: r}
+		}
+
+		replay := func() *http.Client {
+			p, err := NewPlayer(filepath.Join(dir, "fixtures"))
+			Expect(err).To(BeNil())
+			return &http.Client{Transport: p}
+		}
+
+		get := func(c *http.Client, path string) (int, []byte, error) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			req.Header.Set("Authorization", "Bearer supersecretapikey")
+			resp, err := c.Do(req)
+			if err != nil {
+				return 0, nil, err
+			}
+			defer resp.Body.Close()
+			b, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, b, nil
+		}
+
+		g.It("should record requests with the secrets redacted", func() {
+			c := record()
+			resp, err := c.Post(server.URL+"/v1/sessions/login?api_key=supersecretapikey", "application/json", strings.NewReader(`{"username":"someone","password":"hunter2"}`))
+			Expect(err).To(BeNil())
+			b, _ := ioutil.ReadAll(resp.Body)
+			Expect(string(b)).To(ContainSubstring("eyJhbGciOi.eyJzdWIi.sig"))
+
+			get(c, "/artifacts/widget.tgz")
+
+			files, _ := filepath.Glob(filepath.Join(dir, "fixtures", "*.json"))
+			Expect(files).To(HaveLen(2))
+			Expect(filepath.Base(files[0])).To(HavePrefix("0001-post-http-127-0-0-1-"))
+			Expect(filepath.Base(files[0])).To(HaveSuffix("-v1-sessions-login.json"))
+
+			recorded, _ := ioutil.ReadFile(files[0])
+			Expect(string(recorded)).NotTo(ContainSubstring("hunter2"))
+			Expect(string(recorded)).NotTo(ContainSubstring("supersecretapikey"))
+			Expect(string(recorded)).NotTo(ContainSubstring("eyJhbGciOi"))
+
+			recorded, _ = ioutil.ReadFile(files[1])
+			Expect(string(recorded)).To(ContainSubstring(`"Authorization": [` + "\n" + `        "********"`))
+			Expect(string(recorded)).To(ContainSubstring(`"base64": "H4sIAP/+"`))
+		})
+
+		g.It("should record bodies over the limit by their digest and size", func() {
+			r, err := NewRecorder(filepath.Join(dir, "fixtures"), nil)
+			Expect(err).To(BeNil())
+			r.MaxBody = 4
+			c := &http.Client{Transport: r}
+
+			resp, err := c.Post(server.URL+"/artifacts/widget.tgz", "application/octet-stream", bytes.NewReader(bytes.Repeat([]byte("a"), 10)))
+			Expect(err).To(BeNil())
+			b, _ := ioutil.ReadAll(resp.Body)
+			Expect(resp.Body.Close()).To(BeNil())
+			Expect(b).To(Equal(binary))
+
+			files, _ := filepath.Glob(filepath.Join(dir, "fixtures", "*.json"))
+			Expect(files).To(HaveLen(1))
+			recorded, _ := ioutil.ReadFile(files[0])
+			Expect(string(recorded)).To(ContainSubstring(`"omitted_body": {` + "\n" + `      "sha256": "bf2cb58a68f684d95a3b78ef8f661c9a4e5b09e82cc8f9cc88cce90528caeb27",` + "\n" + `      "size": 10`))
+			Expect(string(recorded)).To(ContainSubstring(`"size": 6`))
+			Expect(string(recorded)).NotTo(ContainSubstring("base64"))
+
+			_, err = replay().Post(server.URL+"/artifacts/widget.tgz", "application/octet-stream", bytes.NewReader(bytes.Repeat([]byte("a"), 10)))
+			Expect(err.Error()).To(ContainSubstring("the 6 byte response to POST " + server.URL + "/artifacts/widget.tgz was too large to record"))
+		})
+
+		g.It("should serve the recorded responses in order", func() {
+			c := record()
+			get(c, "/artifacts/widget.tgz")
+			get(c, "/missing")
+			get(c, "/artifacts/widget.tgz")
+			server.Close()
+
+			c = replay()
+			status, b, err := get(c, "/missing")
+			Expect(err).To(BeNil())
+			Expect(status).To(Equal(http.StatusNotFound))
+			Expect(string(b)).To(Equal(`{"message":"no /missing"}`))
+
+			for i := 0; i < 2; i++ {
+				status, b, err = get(c, "/artifacts/widget.tgz")
+				Expect(err).To(BeNil())
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(b).To(Equal(binary))
+			}
+
+			_, _, err = get(c, "/artifacts/widget.tgz")
+			Expect(errors.Is(err, ErrNotRecorded)).To(BeTrue())
+		})
+
+		g.It("should replay requests that failed", func() {
+			c := record()
+			server.Close()
+			_, _, err := get(c, "/artifacts/widget.tgz")
+			Expect(err).NotTo(BeNil())
+
+			p, err := NewPlayer(filepath.Join(dir, "fixtures"))
+			Expect(err).To(BeNil())
+			Expect(p.Unserved()).To(Equal([]string{"GET " + server.URL + "/artifacts/widget.tgz"}))
+
+			_, _, err = get(&http.Client{Transport: p}, "/artifacts/widget.tgz")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("connection refused"))
+			Expect(p.Unserved()).To(BeEmpty())
+		})
+
+		g.It("should fail without recordings", func() {
+			_, err := NewPlayer(dir)
+			Expect(err).NotTo(BeNil())
+
+			os.MkdirAll(filepath.Join(dir, "fixtures"), 0700)
+			ioutil.WriteFile(filepath.Join(dir, "fixtures", "0001.json"), bytes.Repeat([]byte("{"), 3), 0600)
+			_, err = NewPlayer(filepath.Join(dir, "fixtures"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+}
@@ -0,0 +1,403 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/cmd/external"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/policy"
+	"github.com/ion-channel/ionize/redact"
+)
+
+// AnalyzeOptions are the project to analyze and the external reports to add
+// to its analysis
+type AnalyzeOptions struct {
+	Team    string
+	Project string
+	Branch  string
+
+	Reports external.Reports
+
+	// Policy is checked against the reports once they are read, and its
+	// results merged with the evaluation of the analysis.  When it fails
+	// fast and a rule fails, the analysis is not requested.
+	Policy *policy.Policy
+
+	// Trend compares the coverage with an earlier analysis, before the
+	// policy is checked so its rules can use the change, nil for no
+	// comparison
+	Trend *TrendOptions
+
+	// Async returns once the analysis is requested and its reports added,
+	// without waiting for it to finish
+	Async bool
+}
+
+// Analyze reads the project's external reports, compares their coverage with
+// an earlier analysis and checks them against the policy, requests an
+// analysis of the project, adds the reports to it, and unless async waits for
+// it to finish and evaluates it.  An analysis that fails a rule, local or
+// remote, is not an error, check the result's Passed.
+func (r *Runner) Analyze(ctx context.Context, opts *AnalyzeOptions) (*Result, error) {
+	project := opts.Project
+	if project == "" || opts.Team == "" {
+		return nil, inputError(project, fmt.Errorf("a team and project are required"))
+	}
+
+	scans, err := r.ReadReports(project, &opts.Reports)
+	if err != nil {
+		return nil, err
+	}
+
+	coverage := coverageOf(scans)
+	var trend *Trend
+	if opts.Trend != nil && coverage != nil {
+		trend = r.trend(opts, *coverage)
+		if trend != nil {
+			fmt.Fprintln(r.out(), trend)
+		}
+	}
+
+	var local *policy.Evaluation
+	if opts.Policy.Len() > 0 {
+		local = r.checkPolicy(project, opts.Policy, scans, trend)
+		if !local.Passed && opts.Policy.FailFast {
+			r.Log.With(logging.Fields{logging.Project: project, logging.Phase: "policy"}).Warnf("Not requesting an analysis of %s as the local policy failed", project)
+			PrintPolicy(r.out(), local)
+			return &Result{ProjectID: project, TeamID: opts.Team, Policy: local, Trend: trend}, nil
+		}
+	}
+
+	status, err := r.Client.AnalyzeProject(project, opts.Team, opts.Branch, r.Key)
+	if err != nil {
+		return nil, apiError(project, fmt.Errorf("Analysis request failed for %s: %v", project, err.Error()))
+	}
+
+	res := &Result{
+		AnalysisID: status.ID,
+		ProjectID:  project,
+		TeamID:     opts.Team,
+		Status:     status.Status,
+		Policy:     local,
+		Trend:      trend,
+	}
+
+	s, err := r.AddScans(ctx, res, scans)
+	if err != nil {
+		return res, err
+	}
+	if s != nil {
+		status = s
+		res.Status = s.Status
+	}
+
+	if opts.Async {
+		if local != nil {
+			PrintPolicy(r.out(), local)
+		}
+		return res, nil
+	}
+
+	err = r.wait(ctx, res, status)
+	if err != nil {
+		return res, err
+	}
+
+	err = r.evaluate(res)
+	if err != nil {
+		return res, err
+	}
+
+	if opts.Trend != nil && opts.Trend.Cache != "" && coverage != nil && res.Passed() {
+		r.cacheCoverage(opts, res, *coverage)
+	}
+
+	return res, nil
+}
+
+// Scan is an external scan read from a report, to be added to an analysis
+type Scan struct {
+	// Type is coverage, vulnerability, or fortify
+	Type string
+	File string
+
+	// Payload is the scan data sent to the API, and Problems what is wrong
+	// with it
+	Payload  *scanner.ExternalScan
+	Problems []string
+
+	// Accepted is whether the API accepted the scan, and Err why it was
+	// rejected when it was not, or why its report could not be read
+	Accepted bool
+	Err      error
+
+	report   external.Report
+	uploader *dropbox.Uploader
+}
+
+func newScan(ty, file string, report external.Report) *Scan {
+	payload := report.Scan()
+	return &Scan{Type: ty, File: file, Payload: &payload, Problems: report.Problems(), report: report}
+}
+
+// ScanRequest is the body of the request adding a scan to an analysis, as the
+// ionic client sends it.  The client drops the status it is given, so Status
+// is always empty.
+type ScanRequest struct {
+	TeamID    string               `json:"team_id"`
+	ProjectID string               `json:"project_id"`
+	ID        string               `json:"analysis_id"`
+	Status    string               `json:"status"`
+	Results   scanner.ExternalScan `json:"results"`
+	Type      string               `json:"scan_type"`
+}
+
+// Request returns the request adding the scan to the analysis would make,
+// found by saving the scan's report with a client that only records what it is
+// given
+func (s *Scan) Request(aID *external.AnalysisID) (*ScanRequest, error) {
+	if s.report == nil {
+		return nil, fmt.Errorf("the report of %v was not read", s.File)
+	}
+
+	rec := &scanRecorder{}
+	_, err := s.report.Save(aID, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return rec.request, nil
+}
+
+// scanRecorder records the scan added to an analysis instead of sending it
+type scanRecorder struct {
+	request *ScanRequest
+}
+
+func (r *scanRecorder) AddScanResult(scanResultID, teamID, projectID, status, scanType, token string, scanResults scanner.ExternalScan) (*scanner.AnalysisStatus, error) {
+	r.request = &ScanRequest{TeamID: teamID, ProjectID: projectID, ID: scanResultID, Results: scanResults, Type: scanType}
+	return &scanner.AnalysisStatus{ID: scanResultID, TeamID: teamID, ProjectID: projectID}, nil
+}
+
+// CheckReports reads every report without sending or uploading anything,
+// returning a scan for each with the payload that would be sent and the
+// problems found with it, or the error it could not be read with.  Fortify
+// FPR files are given a placeholder for the url they would be uploaded to.
+func CheckReports(reports *external.Reports) []*Scan {
+	scans := []*Scan{}
+
+	if reports.Coverage != "" {
+		coverage, err := external.ParseCoverage(reports.Coverage)
+		if err != nil {
+			scans = append(scans, &Scan{Type: "coverage", File: reports.Coverage, Err: err})
+		} else {
+			scans = append(scans, newScan("coverage", reports.Coverage, coverage))
+		}
+	}
+
+	for _, file := range reports.Vulnerabilities {
+		vulns, err := external.ParseVulnerabilities(file)
+		if err != nil {
+			scans = append(scans, &Scan{Type: "vulnerability", File: file, Err: err})
+		} else {
+			scans = append(scans, newScan("vulnerability", file, vulns))
+		}
+	}
+
+	if reports.Fortify != "" {
+		fortify, err := external.ReadFortify(reports.Fortify)
+		if err == nil {
+			err = fortify.Preview()
+		}
+		if err != nil {
+			scans = append(scans, &Scan{Type: "fortify", File: reports.Fortify, Err: err})
+		} else {
+			scans = append(scans, newScan("fortify", reports.Fortify, fortify))
+		}
+	}
+
+	return scans
+}
+
+// ReadReports reads every report before anything is sent, so a malformed
+// report fails the analysis before it is requested rather than leaving it
+// with some of its scans
+func (r *Runner) ReadReports(project string, reports *external.Reports) ([]*Scan, error) {
+	scans := []*Scan{}
+	log := r.Log.With(logging.Fields{logging.Project: project, logging.Phase: "read"})
+
+	if reports.Coverage != "" {
+		coverage, err := external.ParseCoverage(reports.Coverage)
+		if err != nil {
+			return nil, inputError(project, fmt.Errorf("Analysis request failed for %s: %v", project, err.Error()))
+		}
+		log.Infof("Found coverage %v in %v", coverage.Value.Value, reports.Coverage)
+		scans = append(scans, newScan("coverage", reports.Coverage, coverage))
+	}
+
+	for _, file := range reports.Vulnerabilities {
+		vulns, err := external.ParseVulnerabilities(file)
+		if err != nil {
+			return nil, inputError(project, fmt.Errorf("Analysis request failed for %s: %v", project, err.Error()))
+		}
+		scans = append(scans, newScan("vulnerability", file, vulns))
+	}
+
+	if reports.Fortify != "" {
+		fortify, err := external.ReadFortify(reports.Fortify)
+		if err != nil {
+			return nil, inputError(project, fmt.Errorf("Analysis request failed for %s: %v", project, err.Error()))
+		}
+
+		uploader, err := r.uploader()
+		if err != nil {
+			return nil, err
+		}
+		s := newScan("fortify", reports.Fortify, fortify)
+		s.uploader = uploader
+		scans = append(scans, s)
+	}
+
+	for _, s := range scans {
+		for _, p := range s.Problems {
+			log.Warnf("%v %v", s.File, p)
+		}
+	}
+
+	return scans, nil
+}
+
+// UploadReports reads the external reports and adds them to the analysis in
+// the result, see AddScans
+func (r *Runner) UploadReports(ctx context.Context, res *Result, reports *external.Reports) (*scanner.AnalysisStatus, error) {
+	scans, err := r.ReadReports(res.ProjectID, reports)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.AddScans(ctx, res, scans)
+}
+
+// AddScans adds the scans to the analysis in the result, at most
+// UploadParallel at once, uploading Fortify FPR files to the dropbox first.
+// Every scan is tried even when another is rejected, and a summary of which
+// were accepted is printed.  The status of the analysis after the scans were
+// added is returned, or nil when there were none.
+func (r *Runner) AddScans(ctx context.Context, res *Result, scans []*Scan) (*scanner.AnalysisStatus, error) {
+	if len(scans) == 0 {
+		return nil, nil
+	}
+
+	parallel := r.UploadParallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	aID := external.NewAnalysisID(res.AnalysisID, res.TeamID, res.ProjectID, r.Key)
+	log := r.log(res, "upload")
+	statuses := make([]*scanner.AnalysisStatus, len(scans))
+	errs := make([]error, len(scans))
+	sem := make(chan struct{}, parallel)
+	wg := &sync.WaitGroup{}
+
+	for i, s := range scans {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, s *Scan) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			statuses[i], errs[i] = r.addScan(ctx, res, aID, s)
+			s.Accepted = errs[i] == nil
+			s.Err = errs[i]
+			if s.Accepted {
+				log.Infof("Added external %v scan data from %v", s.Type, s.File)
+			} else {
+				log.Warnf("Failed to add external %v scan data from %v: %v", s.Type, s.File, s.Err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	res.Scans = append(res.Scans, scans...)
+	PrintScans(r.out(), scans)
+
+	var status *scanner.AnalysisStatus
+	var failed error
+	rejected := 0
+	for i := range scans {
+		if errs[i] != nil {
+			rejected++
+			if failed == nil {
+				failed = errs[i]
+			}
+			continue
+		}
+		status = statuses[i]
+	}
+
+	if failed != nil {
+		var e *Error
+		if errors.As(failed, &e) {
+			return status, &Error{Kind: e.Kind, Project: res.ProjectID, Err: fmt.Errorf("%v of %v scans were rejected for %s: %v", rejected, len(scans), res.ProjectID, e.Err.Error())}
+		}
+		return status, failed
+	}
+
+	return status, nil
+}
+
+// addScan uploads the scan's FPR file when it has one and adds the scan to
+// the analysis
+func (r *Runner) addScan(ctx context.Context, res *Result, aID *external.AnalysisID, s *Scan) (*scanner.AnalysisStatus, error) {
+	project := res.ProjectID
+	if err := ctx.Err(); err != nil {
+		return nil, &Error{Kind: ErrCanceled, Project: project, Err: err}
+	}
+
+	if fortify, ok := s.report.(*external.Fortify); ok {
+		s.uploader.Tags[dropbox.TeamTag] = res.TeamID
+		s.uploader.Tags[dropbox.ProjectTag] = project
+		s.uploader.Tags[dropbox.AnalysisTag] = res.AnalysisID
+
+		err := fortify.Upload(s.uploader)
+		if err != nil {
+			return nil, fileError(project, err, fmt.Errorf("Analysis request failed for %s: %v", project, err.Error()))
+		}
+
+		payload := fortify.Scan()
+		s.Payload = &payload
+	}
+
+	status, err := s.report.Save(aID, r.Client)
+	if err != nil {
+		return nil, apiError(project, fmt.Errorf("Analysis Report request failed for %s: %v", project, err.Error()))
+	}
+
+	return status, nil
+}
+
+// PrintScans prints whether each scan was accepted or rejected
+func PrintScans(w io.Writer, scans []*Scan) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCAN\tFILE\tRESULT")
+	for _, s := range scans {
+		result := "accepted"
+		if !s.Accepted {
+			result = "rejected"
+			if s.Err != nil {
+				result += ": " + redact.String(s.Err.Error())
+			}
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", s.Type, s.File, result)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,269 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/dependencies"
+	"github.com/ion-channel/ionize/logging"
+)
+
+// manifests are the file names the ecosystem of a manifest is detected from
+var manifests = []struct {
+	pattern   string
+	ecosystem string
+}{
+	{"go.mod", "golang"},
+	{"package.json", "npm"},
+	{"package-lock.json", "npm"},
+	{"requirements*.txt", "pypi"},
+	{"Gemfile.lock", ionic.RubyEcosystem},
+	{"pom.xml", "maven"},
+	{"Cargo.lock", "cargo"},
+}
+
+// Ecosystem returns the ecosystem the manifest's dependencies are resolved
+// in, detected from its file name
+func Ecosystem(file string) (string, error) {
+	base := filepath.Base(file)
+	for _, m := range manifests {
+		if ok, _ := filepath.Match(m.pattern, base); ok {
+			return m.ecosystem, nil
+		}
+	}
+
+	names := []string{}
+	for _, m := range manifests {
+		names = append(names, m.pattern)
+	}
+
+	return "", fmt.Errorf("can not tell the ecosystem of %v from its name, which is one of %v", base, strings.Join(names, ", "))
+}
+
+// ResolveOptions are the manifest to resolve the dependencies of
+type ResolveOptions struct {
+	File string
+
+	// Ecosystem the dependencies are resolved in, detected from the name of
+	// the file when empty
+	Ecosystem string
+}
+
+// Resolution is the dependency tree of a manifest
+type Resolution struct {
+	File         string                    `json:"file"`
+	Ecosystem    string                    `json:"ecosystem"`
+	Dependencies []dependencies.Dependency `json:"dependencies"`
+
+	// Meta counts the dependencies of the tree as the API gave them, or as it
+	// is after the latest versions missing from it were found when the API
+	// gave no counts
+	Meta dependencies.Meta `json:"meta"`
+}
+
+// ResolveDependencies resolves the dependency tree of the manifest, and finds
+// the latest version of each dependency the API did not give one for.  Those
+// that can not be found are logged and left without a latest version.
+func (r *Runner) ResolveDependencies(ctx context.Context, opts *ResolveOptions) (*Resolution, error) {
+	ecosystem := opts.Ecosystem
+	if ecosystem == "" {
+		var err error
+		ecosystem, err = Ecosystem(opts.File)
+		if err != nil {
+			return nil, inputError("", err)
+		}
+	}
+
+	_, err := os.Stat(opts.File)
+	if err != nil {
+		return nil, inputError("", fmt.Errorf("Failed to read the manifest: %v", err.Error()))
+	}
+
+	log := r.Log.With(logging.Fields{logging.Phase: "deps"})
+	log.Infof("Resolving the %v dependencies of %v", ecosystem, opts.File)
+
+	resp, err := r.Client.ResolveDependenciesInFile(dependencies.DependencyResolutionRequest{Ecosystem: ecosystem, File: opts.File}, r.Key)
+	if err != nil {
+		return nil, apiError("", fmt.Errorf("Failed to resolve the dependencies of %v: %v", opts.File, err.Error()))
+	}
+
+	res := &Resolution{File: opts.File, Ecosystem: ecosystem, Dependencies: resp.Dependencies}
+
+	latest := map[string]string{}
+	err = walkDependencies(res.Dependencies, 0, func(d *dependencies.Dependency, depth int) error {
+		if d.LatestVersion != "" || d.Name == "" {
+			return nil
+		}
+
+		v, ok := latest[d.Name]
+		if !ok {
+			if ctx.Err() != nil {
+				return &Error{Kind: ErrCanceled, Err: ctx.Err()}
+			}
+
+			dep, err := r.Client.GetLatestVersionForDependency(d.Name, ecosystem, r.Key)
+			if err != nil {
+				log.Warnf("Failed to find the latest version of %v: %v", d.Name, err.Error())
+			} else {
+				v = dep.Version
+			}
+			latest[d.Name] = v
+		}
+		d.LatestVersion = v
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res.Meta = resp.Meta
+	if res.Meta == (dependencies.Meta{}) {
+		res.Meta = countDependencies(res.Dependencies)
+	}
+	return res, nil
+}
+
+// walkDependencies calls fn with each dependency of the tree and its depth,
+// parents before their dependencies, stopping at the first error
+func walkDependencies(deps []dependencies.Dependency, depth int, fn func(d *dependencies.Dependency, depth int) error) error {
+	for i := range deps {
+		err := fn(&deps[i], depth)
+		if err != nil {
+			return err
+		}
+
+		err = walkDependencies(deps[i].Dependencies, depth+1, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// countDependencies counts the first degree dependencies of the tree, and the
+// unique ones, those without a version, and those with a newer version,
+// counting a dependency required more than once by the same version once
+func countDependencies(deps []dependencies.Dependency) dependencies.Meta {
+	meta := dependencies.Meta{FirstDegreeCount: len(deps)}
+
+	seen := map[string]bool{}
+	walkDependencies(deps, 0, func(d *dependencies.Dependency, depth int) error {
+		key := d.Org + "/" + d.Name + "@" + d.Version
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		meta.TotalUniqueCount++
+		switch {
+		case d.Version == "":
+			meta.NoVersionCount++
+		case updateAvailable(d):
+			meta.UpdateAvailableCount++
+		}
+
+		return nil
+	})
+
+	return meta
+}
+
+func updateAvailable(d *dependencies.Dependency) bool {
+	return d.Version != "" && d.LatestVersion != "" && d.LatestVersion != d.Version
+}
+
+// PrintDependencies prints the dependency tree as a table, each dependency
+// indented under the one requiring it, followed by its counts
+func PrintDependencies(w io.Writer, res *Resolution) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPENDENCY\tVERSION\tLATEST")
+	walkDependencies(res.Dependencies, 0, func(d *dependencies.Dependency, depth int) error {
+		latest := orNone(d.LatestVersion)
+		if updateAvailable(d) {
+			latest += " (update available)"
+		}
+		fmt.Fprintf(tw, "%v%v\t%v\t%v\n", strings.Repeat("  ", depth), d.Name, orNone(d.Version), latest)
+		return nil
+	})
+	tw.Flush()
+
+	m := res.Meta
+	fmt.Fprintf(w, "%v first degree and %v unique dependencies, %v with an update available", m.FirstDegreeCount, m.TotalUniqueCount, m.UpdateAvailableCount)
+	if m.NoVersionCount > 0 {
+		fmt.Fprintf(w, " and %v without a version", m.NoVersionCount)
+	}
+	fmt.Fprintln(w)
+}
+
+// Versions are the known versions of a package
+type Versions struct {
+	Name      string   `json:"name"`
+	Ecosystem string   `json:"ecosystem"`
+	Versions  []string `json:"versions"`
+	Latest    string   `json:"latest"`
+}
+
+// DependencyVersions finds the known versions of the package in the
+// ecosystem, and which is the latest
+func (r *Runner) DependencyVersions(name, ecosystem string) (*Versions, error) {
+	if name == "" || ecosystem == "" {
+		return nil, inputError("", fmt.Errorf("a package name and ecosystem are required"))
+	}
+
+	deps, err := r.Client.GetVersionsForDependency(name, ecosystem, r.Key)
+	if err != nil {
+		return nil, apiError("", fmt.Errorf("Failed to find the versions of %v: %v", name, err.Error()))
+	}
+
+	latest, err := r.Client.GetLatestVersionForDependency(name, ecosystem, r.Key)
+	if err != nil {
+		return nil, apiError("", fmt.Errorf("Failed to find the latest version of %v: %v", name, err.Error()))
+	}
+
+	v := &Versions{Name: name, Ecosystem: ecosystem, Versions: []string{}, Latest: latest.Version}
+	for _, d := range deps {
+		v.Versions = append(v.Versions, d.Version)
+	}
+
+	return v, nil
+}
+
+// PrintVersions prints the versions one per line, marking the latest
+func PrintVersions(w io.Writer, v *Versions) {
+	for _, version := range v.Versions {
+		if version == v.Latest {
+			fmt.Fprintf(w, "%v (latest)\n", version)
+			continue
+		}
+		fmt.Fprintln(w, version)
+	}
+}
+
+// PrintJSON prints the value as indented JSON, for resolutions and versions
+// read by other tools
+func PrintJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
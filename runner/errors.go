@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/ion-channel/ionize/dropbox"
+)
+
+var (
+	// ErrInput is the kind of error returned when the options or the files
+	// they name can not be used, such as a missing project or an unreadable
+	// report
+	ErrInput = errors.New("invalid input")
+
+	// ErrAPI is the kind of error returned when a request to the Ion Channel
+	// API fails
+	ErrAPI = errors.New("Ion Channel request failed")
+
+	// ErrUpload is the kind of error returned when an artifact or report can
+	// not be uploaded to the dropbox
+	ErrUpload = errors.New("dropbox upload failed")
+
+	// ErrAnalysis is the kind of error returned when the analysis itself
+	// errored
+	ErrAnalysis = errors.New("analysis errored")
+
+	// ErrCanceled is the kind of error returned when the context ended before
+	// the workflow finished
+	ErrCanceled = errors.New("canceled")
+)
+
+// Error is returned by the workflows, with the kind of failure it was and
+// the project it happened to when one is known
+type Error struct {
+	Kind    error
+	Project string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the kind, so errors.Is(err, ErrAPI)
+// holds for failed requests
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func inputError(project string, err error) error {
+	return &Error{Kind: ErrInput, Project: project, Err: err}
+}
+
+func apiError(project string, err error) error {
+	return &Error{Kind: ErrAPI, Project: project, Err: err}
+}
+
+// fileError is an input error, unless the cause was the dropbox rejecting or
+// failing an upload
+func fileError(project string, cause, err error) error {
+	if errors.Is(cause, dropbox.ErrCredentials) || errors.Is(cause, dropbox.ErrBucket) || errors.Is(cause, dropbox.ErrNetwork) {
+		return &Error{Kind: ErrUpload, Project: project, Err: err}
+	}
+
+	return inputError(project, err)
+}
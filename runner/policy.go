@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"github.com/ion-channel/ionize/cmd/external"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/policy"
+)
+
+// CheckPolicy checks the scans read from a project's reports against the
+// policy, skipping any whose report could not be read
+func CheckPolicy(p *policy.Policy, scans []*Scan) *policy.Evaluation {
+	return p.Evaluate(policyData(scans))
+}
+
+// policyData gathers the scan data of the scans
+func policyData(scans []*Scan) *policy.Data {
+	data := &policy.Data{Categories: map[string]int{}}
+
+	for _, s := range scans {
+		if s.Payload == nil {
+			continue
+		}
+
+		if s.Payload.Coverage != nil {
+			coverage := s.Payload.Coverage.Value
+			data.Coverage = &coverage
+		}
+
+		fortify, ok := s.report.(*external.Fortify)
+		if !ok {
+			data.Add(s.Payload.Vulnerability)
+			continue
+		}
+
+		if v := s.Payload.Vulnerability; v != nil {
+			data.Fortify = *v
+		}
+		for c, n := range fortify.FVDL.Categories() {
+			data.Categories[c] += n
+		}
+	}
+
+	return data
+}
+
+// checkPolicy checks the scans, and the coverage trend when there is one,
+// against the policy, logging each rule that failed or was skipped
+func (r *Runner) checkPolicy(project string, p *policy.Policy, scans []*Scan, trend *Trend) *policy.Evaluation {
+	data := policyData(scans)
+	if trend != nil {
+		data.PreviousCoverage = &trend.Previous
+	}
+	eval := p.Evaluate(data)
+
+	log := r.Log.With(logging.Fields{logging.Project: project, logging.Phase: "policy"})
+	for _, res := range eval.Results {
+		switch {
+		case res.Skipped:
+			log.Warnf("Skipped local policy rule %v", res)
+		case !res.Passed:
+			log.Warnf("Failed local policy rule %v", res)
+		default:
+			log.Debugf("Passed local policy rule %v", res)
+		}
+	}
+	if eval.Passed {
+		log.Infof("Passed local policy with %v rule(s)", len(eval.Results))
+	}
+
+	return eval
+}
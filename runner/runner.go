@@ -0,0 +1,232 @@
+// Package runner runs the ionize workflows, analyzing projects, scrutinizing
+// artifacts, and uploading external reports, so they can be used from other
+// Go programs as well as the ionize commands.  Reports are written to the
+// runner's writer and progress to its logger, and failures are returned as
+// errors that can be told apart with errors.Is.
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/ion-channel/ionic/rulesets"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/policy"
+)
+
+const (
+	defaultPollInterval   = 10 * time.Second
+	defaultUploadParallel = 4
+)
+
+// Runner runs workflows against the Ion Channel API with a client and key
+type Runner struct {
+	Client client.Client
+	Key    string
+
+	// Out receives reports, nothing is written when it is nil
+	Out io.Writer
+
+	// Log receives progress messages, with the team, project, analysis, and
+	// phase they are about, nothing is logged when it is nil
+	Log *logging.Logger
+
+	// PollInterval is how long to wait between checks of an analysis
+	PollInterval time.Duration
+
+	// UploadParallel is how many external scans are added to an analysis at
+	// once
+	UploadParallel int
+
+	// NewUploader creates the uploader artifacts and Fortify FPR files are
+	// made available to the analyzer with, they can not be used without it
+	NewUploader func() (*dropbox.Uploader, error)
+}
+
+// New creates a runner for the client and key writing to out
+func New(cli client.Client, key string, out io.Writer) *Runner {
+	return &Runner{
+		Client:         cli,
+		Key:            key,
+		Out:            out,
+		PollInterval:   defaultPollInterval,
+		UploadParallel: defaultUploadParallel,
+	}
+}
+
+// Result is the outcome of an analysis
+type Result struct {
+	AnalysisID string
+	ProjectID  string
+	TeamID     string
+
+	// Status is the last status of the analysis, which is still in progress
+	// when it was not waited for
+	Status string
+
+	// Evaluation is how the analysis fared against the project's ruleset,
+	// along with the local policy, nil when it was not waited for
+	Evaluation *rulesets.AppliedRulesetSummary
+
+	// Policy is how the external reports fared against the local policy, nil
+	// when there is none.  The analysis was not requested when it failed
+	// fast.
+	Policy *policy.Evaluation
+
+	// Trend is how the coverage compares with an earlier analysis, nil when
+	// it was not compared or there was none to compare with
+	Trend *Trend
+
+	// Artifact is what was scrutinized, nil for analyses of projects
+	Artifact *dropbox.Artifact
+
+	// Scans are the external scans added to the analysis, and whether each
+	// was accepted
+	Scans []*Scan
+}
+
+// Passed reports whether the analysis passed every rule of the ruleset and
+// the local policy
+func (r *Result) Passed() bool {
+	return r.Evaluation != nil && r.Evaluation.RuleEvaluationSummary != nil && r.Evaluation.RuleEvaluationSummary.Passed
+}
+
+func (r *Runner) out() io.Writer {
+	if r.Out == nil {
+		return ioutil.Discard
+	}
+
+	return r.Out
+}
+
+// log returns the logger for messages about the result's analysis in the
+// phase of the workflow
+func (r *Runner) log(res *Result, phase string) *logging.Logger {
+	fields := logging.Fields{logging.Phase: phase}
+	for k, v := range map[string]string{
+		logging.Team:       res.TeamID,
+		logging.Project:    res.ProjectID,
+		logging.AnalysisID: res.AnalysisID,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+
+	return r.Log.With(fields)
+}
+
+func (r *Runner) uploader() (*dropbox.Uploader, error) {
+	if r.NewUploader == nil {
+		return nil, inputError("", fmt.Errorf("no dropbox is configured to upload to"))
+	}
+
+	u, err := r.NewUploader()
+	if err != nil {
+		return nil, inputError("", err)
+	}
+	u.Progress = r.Log.With(logging.Fields{logging.Phase: "upload"}).Writer(logging.LevelInfo)
+
+	return u, nil
+}
+
+// wait polls the analysis until it is done or the context ends, recording
+// its final status in the result
+func (r *Runner) wait(ctx context.Context, res *Result, status *scanner.AnalysisStatus) error {
+	project := res.ProjectID
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	log := r.log(res, "wait")
+	log.Infof("Waiting for analysis %s to finish", res.AnalysisID)
+	for !status.Done() {
+		select {
+		case <-ctx.Done():
+			return &Error{Kind: ErrCanceled, Project: project, Err: ctx.Err()}
+		case <-time.After(interval):
+		}
+
+		s, err := r.Client.GetAnalysisStatus(res.AnalysisID, res.TeamID, res.ProjectID, r.Key)
+		if err != nil {
+			return apiError(project, fmt.Errorf("Analysis Status request failed for %s: %v", project, err.Error()))
+		}
+		status = s
+		log.Debugf("Analysis %s is %s", res.AnalysisID, status.Status)
+	}
+	log.Infof("Analysis %s %s", res.AnalysisID, status.Status)
+	res.Status = status.Status
+
+	if status.Status == scanner.AnalysisStatusErrored {
+		return &Error{Kind: ErrAnalysis, Project: project, Err: fmt.Errorf("Analysis error occurred. Final analysis status: %v", status.Message)}
+	}
+
+	return nil
+}
+
+// evaluate gets how the analysis fared against the ruleset and prints each
+// rule's result
+func (r *Runner) evaluate(res *Result) error {
+	r.log(res, "evaluate").Infof("Checking status of scans")
+	eval, err := r.Client.GetAppliedRuleSet(res.ProjectID, res.TeamID, res.AnalysisID, r.Key)
+	if err != nil {
+		return apiError(res.ProjectID, fmt.Errorf("Analysis evaluation request failed for %s (%s): %v", res.ProjectID, res.AnalysisID, err.Error()))
+	}
+	if eval.RuleEvaluationSummary == nil {
+		return apiError(res.ProjectID, fmt.Errorf("Analysis evaluation for %s (%s) has no rule results", res.ProjectID, res.AnalysisID))
+	}
+	if res.Policy != nil {
+		res.Policy.Merge(eval)
+	}
+	res.Evaluation = eval
+
+	PrintEvaluation(r.out(), eval)
+	return nil
+}
+
+// PrintEvaluation prints the result of each rule of the evaluation and
+// whether they all passed
+func PrintEvaluation(w io.Writer, eval *rulesets.AppliedRulesetSummary) {
+	printRules(w, eval)
+
+	if !eval.RuleEvaluationSummary.Passed {
+		fmt.Fprintln(w, "Analysis failed on a rule")
+		return
+	}
+
+	fmt.Fprintln(w, "Analysis passed all rules")
+}
+
+// PrintPolicy prints the result of each rule of the local policy and whether
+// they all passed
+func PrintPolicy(w io.Writer, eval *policy.Evaluation) {
+	printRules(w, eval.Summary())
+
+	if !eval.Passed {
+		fmt.Fprintln(w, "Local policy failed on a rule")
+		return
+	}
+
+	fmt.Fprintln(w, "Local policy passed all rules")
+}
+
+func printRules(w io.Writer, eval *rulesets.AppliedRulesetSummary) {
+	for _, scanSummary := range eval.RuleEvaluationSummary.Ruleresults {
+		fmt.Fprint(w, scanSummary.Summary, "...Rule Type: ")
+		fmt.Fprint(w, scanSummary.Type, "...")
+		if scanSummary.Passed {
+			fmt.Fprint(w, "passed")
+		} else {
+			fmt.Fprint(w, "not passed")
+		}
+
+		fmt.Fprintln(w, "...Risk: ", scanSummary.Risk)
+	}
+}
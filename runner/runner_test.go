@@ -0,0 +1,470 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franela/goblin"
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/dependencies"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionize/client/clienttest"
+	"github.com/ion-channel/ionize/cmd/external"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/logging"
+	"github.com/ion-channel/ionize/policy"
+	. "github.com/onsi/gomega"
+)
+
+func TestRunner(t *testing.T) {
+	g := goblin.Goblin(t)
+	RegisterFailHandler(func(m string, _ ...int) { g.Fail(m) })
+
+	g.Describe("Runner", func() {
+		var dir string
+		var server *clienttest.Server
+		var out *bytes.Buffer
+		var logs *bytes.Buffer
+		var r *Runner
+
+		g.BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "ionize-runner")
+			ioutil.WriteFile(filepath.Join(dir, "coverage.txt"), []byte("87.5\n"), 0644)
+			ioutil.WriteFile(filepath.Join(dir, "vulns.json"), []byte(`{"external_vulnerability":{"critical":1}}`), 0644)
+
+			server = clienttest.NewServer()
+			server.Message = "scanner crashed"
+
+			out = &bytes.Buffer{}
+			r = New(server.Client(), "some-key", out)
+			logs = &bytes.Buffer{}
+			r.Log, _ = logging.New(logs, logging.LevelDebug, logging.FormatJSON)
+			r.PollInterval = time.Millisecond
+			r.NewUploader = func() (*dropbox.Uploader, error) {
+				store, err := dropbox.NewFileStore(filepath.Join(dir, "dropbox"))
+				if err != nil {
+					return nil, err
+				}
+				return dropbox.NewUploaderWithStore(store), nil
+			}
+		})
+
+		g.AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		scans := func() []string {
+			types := []string{}
+			for _, s := range server.Scans() {
+				types = append(types, s.Type)
+			}
+			return types
+		}
+
+		polls := func() int {
+			n := 0
+			for _, req := range server.Requests() {
+				if req == "GET /"+scanner.ScannerGetAnalysisStatusEndpoint {
+					n++
+				}
+			}
+			return n
+		}
+
+		options := func() *AnalyzeOptions {
+			return &AnalyzeOptions{
+				Team:    "team-1",
+				Project: "project-1",
+				Reports: external.Reports{
+					Coverage:        filepath.Join(dir, "coverage.txt"),
+					Vulnerabilities: []string{filepath.Join(dir, "vulns.json")},
+				},
+			}
+		}
+
+		g.It("should analyze a project and add its reports", func() {
+			res, err := r.Analyze(context.Background(), options())
+			Expect(err).To(BeNil())
+			Expect(res.AnalysisID).To(Equal("analysis-1"))
+			Expect(res.Status).To(Equal("finished"))
+			Expect(res.Passed()).To(BeTrue())
+			Expect(scans()).To(ConsistOf("coverage", "vulnerability"))
+			Expect(polls()).To(Equal(1))
+			Expect(res.Scans).To(HaveLen(2))
+			Expect(res.Scans[0].Accepted).To(BeTrue())
+
+			coverage, vulns := filepath.Join(dir, "coverage.txt"), filepath.Join(dir, "vulns.json")
+			Expect(out.String()).To(Equal(fmt.Sprintf("SCAN           FILE%[1]v  RESULT\n"+
+				"coverage       %[2]v  accepted\n"+
+				"vulnerability  %[3]v    accepted\n"+
+				"Coverage...Rule Type: coverage...passed...Risk:  low\nAnalysis passed all rules\n", strings.Repeat(" ", len(coverage)-4), coverage, vulns)))
+			Expect(logs.String()).To(ContainSubstring(`"level":"info","msg":"Found coverage 87.5 in ` + coverage + `","phase":"read","project":"project-1"}`))
+			Expect(logs.String()).To(ContainSubstring(`"level":"info","msg":"Added external coverage scan data from ` + coverage + `","analysis_id":"analysis-1","phase":"upload"`))
+			Expect(logs.String()).To(ContainSubstring(`"level":"debug","msg":"Analysis analysis-1 is finished","analysis_id":"analysis-1","phase":"wait"`))
+			Expect(logs.String()).To(ContainSubstring(`"msg":"Analysis analysis-1 finished"`))
+		})
+
+		g.It("should not treat a failed rule as an error", func() {
+			server.Rules = append(server.Rules, clienttest.Rule{Summary: "Vulnerabilities", Type: "vulnerabilities", Risk: "high"})
+			res, err := r.Analyze(context.Background(), options())
+			Expect(err).To(BeNil())
+			Expect(res.Passed()).To(BeFalse())
+			Expect(out.String()).To(HaveSuffix("Analysis failed on a rule\n"))
+		})
+
+		g.It("should not wait when async", func() {
+			opts := options()
+			opts.Async = true
+			res, err := r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			Expect(res.Status).To(Equal("analyzing"))
+			Expect(res.Evaluation).To(BeNil())
+			Expect(polls()).To(Equal(0))
+		})
+
+		g.It("should return typed errors", func() {
+			server.Statuses = []string{"analyzing", "errored"}
+			res, err := r.Analyze(context.Background(), options())
+			Expect(errors.Is(err, ErrAnalysis)).To(BeTrue())
+			Expect(err.Error()).To(Equal("Analysis error occurred. Final analysis status: scanner crashed"))
+			Expect(res.AnalysisID).To(Equal("analysis-1"))
+
+			opts := options()
+			opts.Reports.Coverage = filepath.Join(dir, "missing.txt")
+			_, err = r.Analyze(context.Background(), opts)
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+			Expect(server.Analyses()).To(HaveLen(1))
+
+			var e *Error
+			Expect(errors.As(err, &e)).To(BeTrue())
+			Expect(e.Project).To(Equal("project-1"))
+
+			_, err = r.Analyze(context.Background(), &AnalyzeOptions{Team: "team-1"})
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+
+			server.Fail(scanner.ScannerAnalyzeProjectEndpoint, http.StatusInternalServerError)
+			_, err = r.Analyze(context.Background(), options())
+			Expect(errors.Is(err, ErrAPI)).To(BeTrue())
+			Expect(errors.Is(err, ErrInput)).To(BeFalse())
+		})
+
+		g.It("should read every report before requesting the analysis", func() {
+			opts := options()
+			opts.Reports.Vulnerabilities = append(opts.Reports.Vulnerabilities, filepath.Join(dir, "coverage.txt"))
+			_, err := r.Analyze(context.Background(), opts)
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+
+			opts = options()
+			opts.Reports.Fortify = filepath.Join(dir, "vulns.json")
+			_, err = r.Analyze(context.Background(), opts)
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+
+			Expect(server.Analyses()).To(BeEmpty())
+			Expect(server.Scans()).To(BeEmpty())
+		})
+
+		g.It("should add every scan and report the rejected ones", func() {
+			server.Fail(scanner.ScannerAddScanEndpoint, http.StatusBadRequest)
+			r.UploadParallel = 1
+
+			res, err := r.Analyze(context.Background(), options())
+			Expect(errors.Is(err, ErrAPI)).To(BeTrue())
+			Expect(err.Error()).To(HavePrefix("1 of 2 scans were rejected for project-1: "))
+			Expect(res.Scans[0].Accepted).To(BeFalse())
+			Expect(res.Scans[1].Accepted).To(BeTrue())
+			Expect(scans()).To(Equal([]string{"vulnerability"}))
+			Expect(polls()).To(Equal(0))
+
+			Expect(out.String()).To(MatchRegexp(`coverage\s+\S+coverage.txt\s+rejected: .*\(400\)`))
+			Expect(out.String()).To(MatchRegexp(`vulnerability\s+\S+vulns.json\s+accepted`))
+			Expect(logs.String()).To(ContainSubstring(`"level":"warn","msg":"Failed to add external coverage scan data from `))
+		})
+
+		g.It("should check the local policy before requesting the analysis", func() {
+			min := 90.0
+			opts := options()
+			opts.Policy, _ = policy.New([]policy.Rule{{Name: "Coverage of at least 90%", Value: "coverage", Min: &min}, {Expr: "critical <= 1"}}, false)
+
+			res, err := r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			Expect(res.Passed()).To(BeFalse())
+			Expect(res.Policy.Passed).To(BeFalse())
+			Expect(res.Evaluation.RuleEvaluationSummary.Ruleresults).To(HaveLen(3))
+			Expect(out.String()).To(HaveSuffix("Coverage...Rule Type: coverage...passed...Risk:  low\n" +
+				"Coverage of at least 90% (coverage=87.5)...Rule Type: local policy...not passed...Risk:  high\n" +
+				"critical <= 1 (critical=1)...Rule Type: local policy...passed...Risk:  low\n" +
+				"Analysis failed on a rule\n"))
+			Expect(logs.String()).To(ContainSubstring(`"level":"warn","msg":"Failed local policy rule Coverage of at least 90% (coverage=87.5)","phase":"policy","project":"project-1"}`))
+
+			out.Reset()
+			opts.Policy.FailFast = true
+			res, err = r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			Expect(res.Passed()).To(BeFalse())
+			Expect(res.AnalysisID).To(BeEmpty())
+			Expect(server.Analyses()).To(HaveLen(1))
+			Expect(out.String()).To(HaveSuffix("Local policy failed on a rule\n"))
+		})
+
+		g.It("should compare the coverage with the last finished analysis of the branch", func() {
+			server.Statuses = []string{"finished"}
+			opts := options()
+			opts.Branch = "main"
+			opts.Trend = &TrendOptions{}
+
+			res, err := r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			Expect(res.Trend).To(BeNil())
+			Expect(logs.String()).To(ContainSubstring("No finished analysis of project-1 on main has coverage to compare with"))
+
+			ioutil.WriteFile(filepath.Join(dir, "coverage.txt"), []byte("85\n"), 0644)
+			drop, _ := policy.New([]policy.Rule{{Expr: "coverage.delta >= -2"}}, true)
+			opts.Policy = drop
+			opts.Branch = "feature"
+			opts.Trend.BaseBranch = "main"
+
+			out.Reset()
+			res, err = r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			Expect(res.Trend).To(Equal(&Trend{Coverage: 85, Previous: 87.5, AnalysisID: "analysis-1", Branch: "main"}))
+			Expect(res.Passed()).To(BeFalse())
+			Expect(res.AnalysisID).To(BeEmpty())
+			Expect(out.String()).To(HavePrefix("Coverage 85 is down 2.5 from 87.5 in analysis analysis-1 on main\n" +
+				"coverage.delta >= -2 (coverage.delta=-2.5)...Rule Type: local policy...not passed"))
+
+			opts.Trend.BaseBranch = ""
+			res, _ = r.Analyze(context.Background(), opts)
+			Expect(res.Trend).To(BeNil())
+			Expect(res.Policy.Results[0].Skipped).To(BeTrue())
+			Expect(res.Passed()).To(BeTrue())
+		})
+
+		g.It("should cache the coverage of each branch", func() {
+			server.Statuses = []string{"finished"}
+			opts := options()
+			opts.Branch = "main"
+			opts.Trend = &TrendOptions{Cache: filepath.Join(dir, "cache", "coverage.json")}
+
+			_, err := r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			b, _ := ioutil.ReadFile(opts.Trend.Cache)
+			Expect(string(b)).To(ContainSubstring(`"team-1/project-1/main": {` + "\n" + `    "analysis_id": "analysis-1",` + "\n" + `    "coverage": 87.5,`))
+
+			ioutil.WriteFile(filepath.Join(dir, "coverage.txt"), []byte("90\n"), 0644)
+			res, err := r.Analyze(context.Background(), opts)
+			Expect(err).To(BeNil())
+			Expect(res.Trend.String()).To(Equal("Coverage 90 is up 2.5 from 87.5 in the cached analysis analysis-1 on main"))
+
+			server.Rules = append(server.Rules, clienttest.Rule{Summary: "Vulnerabilities", Type: "vulnerabilities", Risk: "high"})
+			res, _ = r.Analyze(context.Background(), opts)
+			Expect(res.Passed()).To(BeFalse())
+			Expect(res.Trend.AnalysisID).To(Equal("analysis-2"))
+			Expect(res.Trend.Delta()).To(BeZero())
+
+			res, _ = r.Analyze(context.Background(), opts)
+			Expect(res.Trend.AnalysisID).To(Equal("analysis-2"))
+			Expect(res.Trend.Cached).To(BeTrue())
+
+			// an analysis finished since the cache was written is compared with
+			ioutil.WriteFile(opts.Trend.Cache, []byte(`{"team-1/project-1/main": {"analysis_id": "analysis-0", "coverage": 80, "updated_at": "2020-10-31T00:00:00Z"}}`), 0644)
+			res, _ = r.Analyze(context.Background(), opts)
+			Expect(res.Trend.AnalysisID).To(Equal("analysis-4"))
+			Expect(res.Trend.Cached).To(BeFalse())
+
+			server.Fail("v1/animal/getAnalyses", http.StatusInternalServerError)
+			res, _ = r.Analyze(context.Background(), opts)
+			Expect(res.Trend.AnalysisID).To(Equal("analysis-0"))
+			Expect(res.Trend.Cached).To(BeTrue())
+
+			ioutil.WriteFile(opts.Trend.Cache, []byte("not json"), 0644)
+			res, _ = r.Analyze(context.Background(), opts)
+			Expect(res.Trend.AnalysisID).To(Equal("analysis-6"))
+			Expect(res.Trend.Cached).To(BeFalse())
+			Expect(logs.String()).To(ContainSubstring("Failed to read the coverage cache"))
+		})
+
+		g.It("should stop waiting when the context ends", func() {
+			server.Statuses = []string{"analyzing"}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			_, err := r.Analyze(ctx, options())
+			Expect(errors.Is(err, ErrCanceled)).To(BeTrue())
+		})
+
+		g.It("should scrutinize an artifact with the project created for it", func() {
+			url := server.URL + clienttest.ArtifactsPath + "widget.tgz"
+			other, team, version := "http://other/widget.tgz", "team-1", "1.0.0"
+			id1, id2 := "project-1", "project-2"
+			server.Projects = []projects.Project{
+				{ID: &id1, Source: &other, Branch: &version, TeamID: &team},
+				{ID: &id2, Source: &url, Branch: &version, TeamID: &team},
+			}
+
+			res, err := r.Scrutinize(context.Background(), &ScrutinizeOptions{
+				URL:     url,
+				Name:    "widget",
+				Version: "1.0.0",
+				Team:    "team-1",
+			})
+			Expect(err).To(BeNil())
+			Expect(res.ProjectID).To(Equal("project-2"))
+			Expect(res.Passed()).To(BeTrue())
+			Expect(res.Artifact.URL).To(Equal(url))
+			Expect(logs.String()).To(ContainSubstring(`"msg":"Created project: project-2 (team-1) for ` + url + `","phase":"project","project":"project-2","team":"team-1"}`))
+
+			_, err = r.Scrutinize(context.Background(), &ScrutinizeOptions{URL: "https://example.com/widget.tgz"})
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+
+			r.NewUploader = nil
+			_, err = r.Scrutinize(context.Background(), &ScrutinizeOptions{URL: "https://example.com/widget.tgz", Name: "widget", Version: "1.0.0", Team: "team-1"})
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+		})
+
+		g.It("should scrutinize the same artifact with the project created for it before", func() {
+			artifact := filepath.Join(dir, "widget.tgz")
+			ioutil.WriteFile(artifact, []byte("widget"), 0644)
+			opts := &ScrutinizeOptions{URL: artifact, Name: "widget", Version: "1.0.0", Team: "team-1"}
+
+			first, err := r.Scrutinize(context.Background(), opts)
+			Expect(err).To(BeNil())
+			second, err := r.Scrutinize(context.Background(), opts)
+			Expect(err).To(BeNil())
+
+			Expect(second.ProjectID).To(Equal(first.ProjectID))
+			Expect(strings.Count(strings.Join(server.Requests(), "\n"), "POST /"+projects.CreateProjectEndpoint)).To(Equal(1))
+			Expect(server.Projects).To(HaveLen(1))
+			Expect(*server.Projects[0].Source).To(Equal(first.Artifact.Key))
+			Expect(*server.Projects[0].Description).To(Equal("sha256:" + first.Artifact.Digest))
+		})
+
+		g.It("should resolve the dependency tree of a manifest with the latest versions", func() {
+			manifest := filepath.Join(dir, "package-lock.json")
+			ioutil.WriteFile(manifest, []byte("{}"), 0644)
+			server.Dependencies = []dependencies.Dependency{
+				{Name: "express", Version: "4.16.0", LatestVersion: "4.17.1", Dependencies: []dependencies.Dependency{
+					{Name: "debug", Version: "2.6.9"},
+					{Name: "qs", Version: "6.5.1"},
+				}},
+				{Name: "debug", Version: "2.6.9"},
+				{Name: "left-pad"},
+			}
+			server.Versions = map[string][]string{"debug": {"2.6.9", "4.1.1"}, "qs": {"6.5.1"}}
+
+			res, err := r.ResolveDependencies(context.Background(), &ResolveOptions{File: manifest})
+			Expect(err).To(BeNil())
+			Expect(res.Ecosystem).To(Equal("npm"))
+			Expect(res.Dependencies[0].Dependencies[0].LatestVersion).To(Equal("4.1.1"))
+			Expect(res.Dependencies[1].LatestVersion).To(Equal("4.1.1"))
+			Expect(res.Meta).To(Equal(dependencies.Meta{FirstDegreeCount: 3, TotalUniqueCount: 4, NoVersionCount: 1, UpdateAvailableCount: 2}))
+			Expect(server.Manifests()[0].Ecosystem).To(Equal("npm"))
+			Expect(strings.Count(strings.Join(server.Requests(), "\n"), dependencies.GetLatestVersionForDependencyEndpoint)).To(Equal(3))
+			Expect(logs.String()).To(ContainSubstring("Failed to find the latest version of left-pad"))
+
+			PrintDependencies(out, res)
+			Expect(out.String()).To(Equal("" +
+				"DEPENDENCY  VERSION  LATEST\n" +
+				"express     4.16.0   4.17.1 (update available)\n" +
+				"  debug     2.6.9    4.1.1 (update available)\n" +
+				"  qs        6.5.1    6.5.1\n" +
+				"debug       2.6.9    4.1.1 (update available)\n" +
+				"left-pad    -        -\n" +
+				"3 first degree and 4 unique dependencies, 2 with an update available and 1 without a version\n"))
+
+			res, err = r.ResolveDependencies(context.Background(), &ResolveOptions{File: manifest, Ecosystem: "yarn"})
+			Expect(err).To(BeNil())
+			Expect(server.Manifests()[1].Ecosystem).To(Equal("yarn"))
+
+			_, err = r.ResolveDependencies(context.Background(), &ResolveOptions{File: filepath.Join(dir, "coverage.txt")})
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("can not tell the ecosystem of coverage.txt"))
+
+			_, err = r.ResolveDependencies(context.Background(), &ResolveOptions{File: filepath.Join(dir, "go.mod")})
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+
+			server.Fail(dependencies.ResolveDependenciesInFileEndpoint, http.StatusBadRequest)
+			_, err = r.ResolveDependencies(context.Background(), &ResolveOptions{File: manifest})
+			Expect(errors.Is(err, ErrAPI)).To(BeTrue())
+		})
+
+		g.It("should keep the counts the API gives with the tree", func() {
+			manifest := filepath.Join(dir, "Gemfile.lock")
+			ioutil.WriteFile(manifest, []byte("GEM\n"), 0644)
+			server.Dependencies = []dependencies.Dependency{{Name: "rails", Version: "5.2.0", LatestVersion: "6.0.3"}}
+			server.DependencyMeta = dependencies.Meta{FirstDegreeCount: 1, TotalUniqueCount: 62, UpdateAvailableCount: 12}
+
+			res, err := r.ResolveDependencies(context.Background(), &ResolveOptions{File: manifest})
+			Expect(err).To(BeNil())
+			Expect(res.Meta).To(Equal(server.DependencyMeta))
+		})
+
+		g.It("should resolve each type of manifest in its ecosystem", func() {
+			server.Dependencies = []dependencies.Dependency{{Name: "left-pad", Version: "1.3.0", LatestVersion: "1.3.0"}}
+			files := []string{"go.mod", "package.json", "package-lock.json", "requirements.txt", "requirements-dev.txt", "Gemfile.lock", "pom.xml", "Cargo.lock"}
+			ecosystems := []string{"golang", "npm", "npm", "pypi", "pypi", ionic.RubyEcosystem, "maven", "cargo"}
+
+			for i, file := range files {
+				manifest := filepath.Join(dir, file)
+				ioutil.WriteFile(manifest, []byte(file), 0644)
+
+				res, err := r.ResolveDependencies(context.Background(), &ResolveOptions{File: manifest})
+				Expect(err).To(BeNil(), file)
+				Expect(res.Ecosystem).To(Equal(ecosystems[i]), file)
+				Expect(res.Meta).To(Equal(dependencies.Meta{FirstDegreeCount: 1, TotalUniqueCount: 1}), file)
+
+				sent := server.Manifests()[i]
+				Expect(sent.Ecosystem).To(Equal(ecosystems[i]), file)
+				Expect(sent.File).To(Equal(file))
+				Expect(string(sent.Content)).To(Equal(file))
+			}
+		})
+
+		g.It("should detect the ecosystem of each manifest", func() {
+			for file, ecosystem := range map[string]string{
+				"go.mod":               "golang",
+				"web/package.json":     "npm",
+				"package-lock.json":    "npm",
+				"requirements.txt":     "pypi",
+				"requirements-dev.txt": "pypi",
+				"Gemfile.lock":         "ruby",
+				"pom.xml":              "maven",
+				"Cargo.lock":           "cargo",
+			} {
+				e, err := Ecosystem(file)
+				Expect(err).To(BeNil())
+				Expect(e).To(Equal(ecosystem), file)
+			}
+
+			_, err := Ecosystem("Gemfile")
+			Expect(err).NotTo(BeNil())
+		})
+
+		g.It("should find the versions of a package", func() {
+			server.Versions = map[string][]string{"rails": {"5.2.0", "6.0.3"}}
+
+			v, err := r.DependencyVersions("rails", "ruby")
+			Expect(err).To(BeNil())
+			Expect(v).To(Equal(&Versions{Name: "rails", Ecosystem: "ruby", Versions: []string{"5.2.0", "6.0.3"}, Latest: "6.0.3"}))
+
+			PrintVersions(out, v)
+			Expect(out.String()).To(Equal("5.2.0\n6.0.3 (latest)\n"))
+
+			_, err = r.DependencyVersions("rake", "ruby")
+			Expect(errors.Is(err, ErrAPI)).To(BeTrue())
+
+			_, err = r.DependencyVersions("rails", "")
+			Expect(errors.Is(err, ErrInput)).To(BeTrue())
+		})
+	})
+}
@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ion-channel/ionic"
+	"github.com/ion-channel/ionic/pagination"
+	"github.com/ion-channel/ionic/projects"
+	"github.com/ion-channel/ionize/dropbox"
+	"github.com/ion-channel/ionize/logging"
+)
+
+// ScrutinizeOptions are the artifact to analyze and the project to analyze
+// it as
+type ScrutinizeOptions struct {
+	// URL of the artifact, a local file which is uploaded to the dropbox, or
+	// - to upload what is read from Stdin
+	URL     string
+	Name    string
+	Version string
+	Team    string
+
+	// Ruleset the project is created with, the team's first when empty
+	Ruleset string
+
+	// Rehost fetches http(s), s3, and gs urls and uploads them to the
+	// dropbox for the analyzer
+	Rehost bool
+
+	// Stdin is read for the artifact when the url is -, os.Stdin when nil
+	Stdin io.Reader
+}
+
+// Scrutinize creates a project for the artifact, or finds the one created
+// before, then analyzes it and waits for the evaluation.  An analysis that
+// fails a rule is not an error, check the result's Passed.
+func (r *Runner) Scrutinize(ctx context.Context, opts *ScrutinizeOptions) (*Result, error) {
+	team := opts.Team
+	if team == "" || opts.URL == "" || opts.Name == "" || opts.Version == "" {
+		return nil, inputError("", fmt.Errorf("a team, url, name, and version are required"))
+	}
+
+	uploader, err := r.uploader()
+	if err != nil {
+		return nil, err
+	}
+	uploader.Tags[dropbox.TeamTag] = team
+	if opts.Stdin != nil {
+		uploader.Stdin = opts.Stdin
+	}
+	if opts.Rehost {
+		uploader.Rehost = true
+	}
+
+	artifact, err := uploader.ParseURL(opts.URL)
+	if err != nil {
+		return nil, fileError("", err, fmt.Errorf("Failed to parse url: %v", err.Error()))
+	}
+	url := artifact.URL
+
+	rulesetID := opts.Ruleset
+	if rulesetID == "" {
+		rulesets, err := r.Client.GetRuleSets(team, r.Key, nil)
+		if err != nil || len(rulesets) == 0 {
+			return nil, apiError("", fmt.Errorf("Failed to retrieve rulesets for team, make sure a valid ruleset exists"))
+		}
+		rulesetID = rulesets[0].ID
+	}
+
+	project, err := r.findOrCreateProject(opts, artifact, rulesetID)
+	if err != nil {
+		return nil, err
+	}
+	r.Log.With(logging.Fields{logging.Team: team, logging.Project: *project.ID, logging.Phase: "project"}).Infof("Created project: %v (%v) for %s", *project.ID, team, url)
+
+	status, err := r.Client.AnalyzeProject(*project.ID, team, opts.Version, r.Key)
+	if err != nil {
+		return nil, apiError(*project.ID, fmt.Errorf("Analysis request failed for %v: %v", *project.ID, err.Error()))
+	}
+
+	res := &Result{
+		AnalysisID: status.ID,
+		ProjectID:  *project.ID,
+		TeamID:     team,
+		Status:     status.Status,
+		Artifact:   artifact,
+	}
+
+	if artifact.Key != "" {
+		err = uploader.AddTags(artifact.Key, map[string]string{
+			dropbox.ProjectTag:  res.ProjectID,
+			dropbox.AnalysisTag: res.AnalysisID,
+		})
+		if err != nil {
+			r.log(res, "upload").Warnf("Failed to tag uploaded artifact: %v", err)
+		}
+	}
+
+	err = r.wait(ctx, res, status)
+	if err != nil {
+		return res, err
+	}
+
+	err = r.evaluate(res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// findOrCreateProject returns the project created for the artifact before, or
+// creates one aliased with its name and version. Projects are keyed by the
+// stable location and digest of the artifact rather than its presigned url,
+// which expires and changes between runs.
+func (r *Runner) findOrCreateProject(opts *ScrutinizeOptions, artifact *dropbox.Artifact, rulesetID string) (*projects.Project, error) {
+	team := opts.Team
+	source := artifact.URL
+	if artifact.Key != "" {
+		source = artifact.Key
+	}
+	ty := "artifact"
+	log := r.Log.With(logging.Fields{logging.Team: team, logging.Phase: "project"})
+
+	project := &projects.Project{
+		Name:      &opts.Name,
+		Branch:    &opts.Version,
+		Source:    &source,
+		Type:      &ty,
+		POCEmail:  "",
+		POCName:   "",
+		TeamID:    &team,
+		Active:    true,
+		RulesetID: &rulesetID,
+	}
+
+	if artifact.Digest != "" {
+		// record the digest so the analyzed artifact can be proven to be
+		// the one that was shipped
+		log.Infof("Artifact sha256: %s", artifact.Digest)
+		description := fmt.Sprintf("sha256:%s", artifact.Digest)
+		project.Description = &description
+	}
+
+	existing, err := r.findProject(project)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	created, err := r.Client.CreateProject(project, team, r.Key)
+	if err != nil {
+		// another run may have created it in the meantime
+		existing, er := r.findProject(project)
+		if er == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, apiError("", fmt.Errorf("Failed to create project: %v", err.Error()))
+	}
+
+	o := ionic.AddAliasOptions{
+		Name:      opts.Name,
+		ProjectID: *created.ID,
+		TeamID:    team,
+		Version:   opts.Version,
+	}
+	_, err = r.Client.AddAlias(o, r.Key)
+	if err != nil {
+		return nil, apiError(*created.ID, fmt.Errorf("Failed to add alias to project, analysis depth will be reduced: %v", err.Error()))
+	}
+	log.With(logging.Fields{logging.Project: *created.ID}).Infof("Created alias %s for %v (%v)", opts.Name, *created.ID, team)
+
+	return created, nil
+}
+
+// findProject returns the project of the team for the same version of the
+// artifact, matched by its digest when it has one and its source otherwise, or
+// nil when there is none
+func (r *Runner) findProject(project *projects.Project) (*projects.Project, error) {
+	existing, err := r.Client.GetProjects(*project.TeamID, r.Key, pagination.AllItems, nil)
+	if err != nil {
+		return nil, apiError("", fmt.Errorf("Failed to receive projects: %v", err.Error()))
+	}
+
+	for i, p := range existing {
+		if p.Branch == nil || *p.Branch != *project.Branch {
+			continue
+		}
+		if project.Description != nil {
+			if p.Description != nil && *p.Description == *project.Description {
+				return &existing[i], nil
+			}
+			continue
+		}
+		if p.Source != nil && *p.Source == *project.Source {
+			return &existing[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,259 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ion-channel/ionic/analyses"
+	"github.com/ion-channel/ionic/pagination"
+	"github.com/ion-channel/ionic/scanner"
+	"github.com/ion-channel/ionic/scans"
+	"github.com/ion-channel/ionize/logging"
+)
+
+// trendAnalyses is how many of the latest analyses of a project are searched
+// for the one coverage is compared with
+const trendAnalyses = 100
+
+// TrendOptions compare the coverage of an analysis with the coverage of the
+// last finished analysis of a branch
+type TrendOptions struct {
+	// BaseBranch is the branch compared with, the analyzed branch when empty
+	BaseBranch string
+
+	// Cache is a file the coverage of each branch's last passing analysis is
+	// kept in, by the runs that analyzed it.  It is compared with when the
+	// API has no newer finished analysis of the branch, or can not be read.
+	Cache string
+}
+
+// Trend is how the coverage of an analysis compares with an earlier one
+type Trend struct {
+	Coverage float64
+	Previous float64
+
+	// AnalysisID and Branch are of the analysis compared with
+	AnalysisID string
+	Branch     string
+
+	// Cached is set when the previous coverage was read from the cache
+	Cached bool
+}
+
+// Delta is how much the coverage rose, negative when it dropped
+func (t *Trend) Delta() float64 {
+	return t.Coverage - t.Previous
+}
+
+// String describes the trend for reports
+func (t *Trend) String() string {
+	change := "is unchanged at"
+	switch d := t.Delta(); {
+	case d > 0:
+		change = fmt.Sprintf("is up %v from", formatCoverage(d))
+	case d < 0:
+		change = fmt.Sprintf("is down %v from", formatCoverage(-d))
+	}
+
+	from := fmt.Sprintf("analysis %v", t.AnalysisID)
+	if t.Cached {
+		from = "the cached " + from
+	}
+
+	return fmt.Sprintf("Coverage %v %v %v in %v on %v", formatCoverage(t.Coverage), change, formatCoverage(t.Previous), from, branchName(t.Branch))
+}
+
+func formatCoverage(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func branchName(branch string) string {
+	if branch == "" {
+		return "the default branch"
+	}
+
+	return branch
+}
+
+// coverageOf returns the coverage read from the scans, nil when there is none
+func coverageOf(scans []*Scan) *float64 {
+	for _, s := range scans {
+		if s.Payload != nil && s.Payload.Coverage != nil {
+			coverage := s.Payload.Coverage.Value
+			return &coverage
+		}
+	}
+
+	return nil
+}
+
+// trend compares the coverage with the last finished analysis of the branch
+// compared with, found with the API, or with the cached coverage of the branch
+// when the API has no newer analysis with coverage or can not be read.  Nil is
+// returned when there is no earlier coverage, or it could not be found, which
+// is logged rather than failing the analysis.
+func (r *Runner) trend(opts *AnalyzeOptions, coverage float64) *Trend {
+	branch := opts.Trend.BaseBranch
+	if branch == "" {
+		branch = opts.Branch
+	}
+	log := r.Log.With(logging.Fields{logging.Team: opts.Team, logging.Project: opts.Project, logging.Phase: "trend"})
+
+	var cached *Trend
+	var cachedAt time.Time
+	if opts.Trend.Cache != "" {
+		cache, err := readTrendCache(opts.Trend.Cache)
+		if err != nil {
+			log.Warnf("Failed to read the coverage cache: %v", err.Error())
+		}
+		if c, ok := cache[trendKey(opts.Team, opts.Project, branch)]; ok {
+			cached = &Trend{Coverage: coverage, Previous: c.Coverage, AnalysisID: c.AnalysisID, Branch: branch, Cached: true}
+			cachedAt = c.UpdatedAt
+		}
+	}
+
+	list, err := r.Client.GetAnalyses(opts.Team, opts.Project, r.Key, pagination.New(0, trendAnalyses))
+	if err != nil {
+		if cached != nil {
+			log.Warnf("Failed to find the earlier coverage of %s on %s, comparing with the cache: %v", opts.Project, branchName(branch), err.Error())
+			return cached
+		}
+		log.Warnf("Failed to find the earlier coverage of %s on %s: %v", opts.Project, branchName(branch), err.Error())
+		return nil
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	for _, a := range list {
+		if a.Branch != branch || a.Status != scanner.AnalysisStatusFinished {
+			continue
+		}
+
+		previous, ok := analysisCoverage(&a)
+		if !ok {
+			continue
+		}
+
+		if cached != nil && (a.ID == cached.AnalysisID || !a.UpdatedAt.After(cachedAt)) {
+			return cached
+		}
+
+		return &Trend{Coverage: coverage, Previous: previous, AnalysisID: a.ID, Branch: branch}
+	}
+
+	if cached != nil {
+		return cached
+	}
+
+	log.Infof("No finished analysis of %s on %s has coverage to compare with", opts.Project, branchName(branch))
+	return nil
+}
+
+// analysisCoverage returns the coverage added to the analysis, if any was
+func analysisCoverage(a *analyses.Analysis) (float64, bool) {
+	for _, s := range a.ScanSummaries {
+		if s.TranslatedResults == nil {
+			continue
+		}
+
+		switch c := s.TranslatedResults.Data.(type) {
+		case scans.CoverageResults:
+			return c.Value, true
+		case *scans.CoverageResults:
+			return c.Value, true
+		}
+	}
+
+	return 0, false
+}
+
+// trendCacheMu keeps the runs of a process from losing each other's updates
+// to the cache
+var trendCacheMu sync.Mutex
+
+type trendEntry struct {
+	AnalysisID string    `json:"analysis_id"`
+	Coverage   float64   `json:"coverage"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func trendKey(team, project, branch string) string {
+	return team + "/" + project + "/" + branch
+}
+
+func readTrendCache(path string) (map[string]trendEntry, error) {
+	cache := map[string]trendEntry{}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+
+	err = json.Unmarshal(b, &cache)
+	if err != nil {
+		return map[string]trendEntry{}, fmt.Errorf("%v is not a coverage cache: %v", path, err.Error())
+	}
+
+	return cache, nil
+}
+
+// cacheCoverage records the coverage of the passing analysis as the latest of
+// its branch
+func (r *Runner) cacheCoverage(opts *AnalyzeOptions, res *Result, coverage float64) {
+	trendCacheMu.Lock()
+	defer trendCacheMu.Unlock()
+
+	err := writeTrendCache(opts.Trend.Cache, trendKey(opts.Team, opts.Project, opts.Branch), trendEntry{
+		AnalysisID: res.AnalysisID,
+		Coverage:   coverage,
+		UpdatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		r.log(res, "trend").Warnf("Failed to update the coverage cache: %v", err.Error())
+	}
+}
+
+func writeTrendCache(path, key string, entry trendEntry) error {
+	cache, err := readTrendCache(path)
+	if err != nil {
+		return err
+	}
+	cache[key] = entry
+
+	b, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".ionize-coverage-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(b, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+)
+
+func disableEcho(f *os.File) (func(), error) {
+	return nil, fmt.Errorf("hiding input is not supported on this platform")
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package terminal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// disableEcho turns off echo on the terminal, returning a func that restores
+// it
+func disableEcho(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+
+	termios, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	original := *termios
+	termios.Lflag &^= unix.ECHO
+	err = unix.IoctlSetTermios(fd, setTermios, termios)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { unix.IoctlSetTermios(fd, setTermios, &original) }, nil
+}
@@ -0,0 +1,68 @@
+// Package terminal prompts the user for input, hiding what is typed for
+// secrets when reading from a terminal.
+package terminal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadLine prints the prompt and reads a line from in
+func ReadLine(in *os.File, out io.Writer, prompt string) (string, error) {
+	fmt.Fprint(out, prompt)
+
+	return readLine(in)
+}
+
+// ReadSecret prints the prompt and reads a line from in without echoing it
+// when in is a terminal
+func ReadSecret(in *os.File, out io.Writer, prompt string) (string, error) {
+	fmt.Fprint(out, prompt)
+
+	restore, err := disableEcho(in)
+	if err == nil {
+		defer func() {
+			restore()
+			fmt.Fprintln(out)
+		}()
+	}
+
+	return readLine(in)
+}
+
+// IsTerminal reports whether the file is a terminal rather than a pipe or a
+// regular file
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// readLine reads a single byte at a time so nothing after the line is
+// consumed from in
+func readLine(in io.Reader) (string, error) {
+	line, err := bufio.NewReader(&byteReader{in}).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read input: %v", err.Error())
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+
+	return b.r.Read(p)
+}